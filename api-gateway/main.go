@@ -68,6 +68,12 @@ func main() {
 	router.PathPrefix("/api/promotions").Handler(proxyTo(services["promotion"]))
 	router.PathPrefix("/api/payments").Handler(proxyTo(services["payment"]))
 	router.PathPrefix("/api/qr-sessions").Handler(proxyTo(services["order"]))
+	router.PathPrefix("/api/products").Handler(proxyTo(services["order"]))
+	router.PathPrefix("/api/categories").Handler(proxyTo(services["order"]))
+	router.PathPrefix("/api/menus").Handler(proxyTo(services["order"]))
+	router.PathPrefix("/api/menu-versions").Handler(proxyTo(services["order"]))
+	router.PathPrefix("/api/kitchen").Handler(proxyTo(services["order"]))
+	router.PathPrefix("/api/tables").Handler(proxyTo(services["order"]))
 	router.PathPrefix("/api/reports").Handler(proxyTo(services["order"])) // Reports go to order service
 	router.PathPrefix("/ws").Handler(proxyTo(services["notification"]))   // WebSocket
 	router.PathPrefix("/api/events").Handler(proxyTo(services["notification"]))
@@ -109,7 +115,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Organization-ID, X-Branch-ID, X-User-Role")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Organization-ID, X-Branch-ID, X-User-Role, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -121,14 +128,30 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 func authMiddleware(secret string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// User identity only ever comes from a verified token, never from the client
+		r.Header.Del("X-User-ID")
+		r.Header.Del("X-User-Role")
+
 		// Skip auth for: OPTIONS, health, auth endpoints, and user endpoints (GET/POST orders for QR menu)
 		if r.Method == http.MethodOptions || r.URL.Path == "/health" || strings.HasPrefix(r.URL.Path, "/api/auth") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Allow GET /api/orders for user (list orders)
-		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/orders") {
+		// Allow GET /api/orders/{id} for user (order status page), the QR menu's note presets and the pickup display
+		if r.Method == http.MethodGet && isGuestOrderPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Allow GET /api/products for user (QR menu, scoped by organization_id param)
+		if r.Method == http.MethodGet && r.URL.Path == "/api/products" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Allow GET /api/categories for user (QR menu sections, scoped by organization_id param)
+		if r.Method == http.MethodGet && r.URL.Path == "/api/categories" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -186,6 +209,16 @@ func authMiddleware(secret string, next http.Handler) http.Handler {
 	})
 }
 
+// isGuestOrderPath matches GET /api/orders/{id}, /api/orders/note-presets and
+// the pickup queue display. Order lists and per-order histories need a token.
+func isGuestOrderPath(path string) bool {
+	if !strings.HasPrefix(path, "/api/orders/") {
+		return false
+	}
+	rest := strings.TrimPrefix(path, "/api/orders/")
+	return rest != "" && !strings.Contains(rest, "/")
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/health" {
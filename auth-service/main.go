@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type LoginRequest struct {
@@ -37,21 +40,31 @@ type Organization struct {
 }
 
 type Branch struct {
-	ID             string    `json:"id"`
-	OrganizationID string    `json:"organization_id"`
-	Name           string    `json:"name"`
-	Slug           string    `json:"slug"`
-	Address        string    `json:"address"`
-	City           string    `json:"city"`
-	Province       string    `json:"province"`
-	PostalCode     string    `json:"postal_code"`
-	Phone          string    `json:"phone"`
-	Email          string    `json:"email"`
-	OpeningTime    string    `json:"opening_time"`
-	ClosingTime    string    `json:"closing_time"`
-	IsActive       bool      `json:"is_active"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID                string    `json:"id"`
+	OrganizationID    string    `json:"organization_id"`
+	Name              string    `json:"name"`
+	Slug              string    `json:"slug"`
+	Address           string    `json:"address"`
+	City              string    `json:"city"`
+	Province          string    `json:"province"`
+	PostalCode        string    `json:"postal_code"`
+	Phone             string    `json:"phone"`
+	Email             string    `json:"email"`
+	OpeningTime       string    `json:"opening_time"`
+	ClosingTime       string    `json:"closing_time"`
+	TaxRate           float64   `json:"tax_rate"`
+	ServiceChargeRate float64   `json:"service_charge_rate"`
+	PricesIncludeTax  bool      `json:"prices_include_tax"`
+	SessionLifetime   int       `json:"session_lifetime_minutes"`
+	LastOrderMinutes  int       `json:"last_order_minutes"`
+	MenuBaseURL       string    `json:"menu_base_url"`
+	Timezone          string    `json:"timezone"`
+	BranchCode        string    `json:"branch_code"`
+	OrderNumberFormat string    `json:"order_number_format"`
+	OrderNumberDaily  bool      `json:"order_number_daily_reset"`
+	IsActive          bool      `json:"is_active"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 type ManagerUser struct {
@@ -85,6 +98,28 @@ type CreateBranchRequest struct {
 	Email       string `json:"email"`
 	OpeningTime string `json:"opening_time"`
 	ClosingTime string `json:"closing_time"`
+
+	// Pricing settings; nil keeps the current value (or the schema default on create)
+	TaxRate           *float64 `json:"tax_rate"`
+	ServiceChargeRate *float64 `json:"service_charge_rate"`
+	PricesIncludeTax  *bool    `json:"prices_include_tax"`
+
+	// QR session window in minutes; lifetime 0 disables expiry. Ordering stops
+	// LastOrderMinutes before the session expires.
+	SessionLifetime  *int `json:"session_lifetime_minutes"`
+	LastOrderMinutes *int `json:"last_order_minutes"`
+
+	// Base URL printed in table QR codes; empty uses the order-service default
+	MenuBaseURL *string `json:"menu_base_url"`
+
+	// IANA timezone, e.g. "Asia/Bangkok"; menu schedules run on local time
+	Timezone *string `json:"timezone"`
+
+	// Order numbering, e.g. "{branch}-{date}-{seq:4}" -> B01-20261016-0042.
+	// Daily reset restarts {seq} every day (branch local time) and needs {date} in the format.
+	BranchCode        *string `json:"branch_code"`
+	OrderNumberFormat *string `json:"order_number_format"`
+	OrderNumberDaily  *bool   `json:"order_number_daily_reset"`
 }
 
 type CreateManagerRequest struct {
@@ -440,6 +475,11 @@ func main() {
 		deleteCashier(db, w, r)
 	}).Methods(http.MethodDelete)
 
+	// Approval PIN of the signed-in manager (voids and comps)
+	router.HandleFunc("/api/users/me/pin", func(w http.ResponseWriter, r *http.Request) {
+		setApprovalPIN(db, w, r)
+	}).Methods(http.MethodPut)
+
 	// Manager's branches (get branches assigned to manager's org)
 	router.HandleFunc("/api/manager/branches", func(w http.ResponseWriter, r *http.Request) {
 		listManagerBranches(db, w, r)
@@ -634,7 +674,9 @@ func listBranches(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	rows, err := db.Query(`
 		SELECT id, organization_id, name, slug, address, city, province, postal_code, phone, email, 
-		       opening_time, closing_time, is_active, created_at, updated_at
+		       opening_time, closing_time, tax_rate, service_charge_rate, prices_include_tax,
+		       session_lifetime_minutes, last_order_minutes, menu_base_url, timezone,
+		       branch_code, order_number_format, order_number_daily_reset, is_active, created_at, updated_at
 		FROM branches WHERE organization_id = $1 AND is_active = true ORDER BY created_at DESC
 	`, orgID)
 	if err != nil {
@@ -649,7 +691,9 @@ func listBranches(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		var b Branch
 		var openingTime, closingTime sql.NullString
 		rows.Scan(&b.ID, &b.OrganizationID, &b.Name, &b.Slug, &b.Address, &b.City, &b.Province, &b.PostalCode, &b.Phone, &b.Email,
-			&openingTime, &closingTime, &b.IsActive, &b.CreatedAt, &b.UpdatedAt)
+			&openingTime, &closingTime, &b.TaxRate, &b.ServiceChargeRate, &b.PricesIncludeTax,
+			&b.SessionLifetime, &b.LastOrderMinutes, &b.MenuBaseURL, &b.Timezone,
+			&b.BranchCode, &b.OrderNumberFormat, &b.OrderNumberDaily, &b.IsActive, &b.CreatedAt, &b.UpdatedAt)
 		if openingTime.Valid {
 			b.OpeningTime = openingTime.String
 		}
@@ -686,15 +730,50 @@ func createBranch(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name_and_slug_required"})
 		return
 	}
+	if !validRate(req.TaxRate) || !validRate(req.ServiceChargeRate) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_rate"})
+		return
+	}
+	if !validMinutes(req.SessionLifetime) || !validMinutes(req.LastOrderMinutes) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_session_window"})
+		return
+	}
+	if !validBaseURL(req.MenuBaseURL) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_menu_base_url"})
+		return
+	}
+	if ok, err := validTimezone(db, req.Timezone); err != nil {
+		log.Printf("Failed to check timezone: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	} else if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_timezone"})
+		return
+	}
+	if !validBranchCode(req.BranchCode) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_branch_code"})
+		return
+	}
+	if code := orderNumberFormatError(valueOr(req.OrderNumberFormat, defaultOrderNumberFormat), req.OrderNumberDaily != nil && *req.OrderNumberDaily); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
 
 	branchID := uuid.New().String()
 
 	_, err := db.Exec(`
 		INSERT INTO branches (id, organization_id, name, slug, address, city, province, postal_code, phone, email,
-		                      opening_time, closing_time, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, true, NOW(), NOW())
+		                      opening_time, closing_time, tax_rate, service_charge_rate, prices_include_tax,
+		                      session_lifetime_minutes, last_order_minutes, menu_base_url, timezone,
+		                      branch_code, order_number_format, order_number_daily_reset, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+		        COALESCE($13, 7.00), COALESCE($14, 0.00), COALESCE($15, true),
+		        COALESCE($16, 120), COALESCE($17, 15), COALESCE($18, ''), COALESCE($19, 'Asia/Bangkok'),
+		        COALESCE($20, ''), COALESCE($21, '{seq:4}'), COALESCE($22, false), true, NOW(), NOW())
 	`, branchID, orgID, req.Name, req.Slug, req.Address, req.City, req.Province, req.PostalCode, req.Phone, req.Email,
-		req.OpeningTime, req.ClosingTime)
+		req.OpeningTime, req.ClosingTime, req.TaxRate, req.ServiceChargeRate, req.PricesIncludeTax,
+		req.SessionLifetime, req.LastOrderMinutes, req.MenuBaseURL, req.Timezone,
+		req.BranchCode, req.OrderNumberFormat, req.OrderNumberDaily)
 
 	if err != nil {
 		log.Printf("Failed to create branch: %v", err)
@@ -726,10 +805,14 @@ func getBranch(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	var openingTime, closingTime sql.NullString
 	err := db.QueryRow(`
 		SELECT id, organization_id, name, slug, address, city, province, postal_code, phone, email,
-		       opening_time, closing_time, is_active, created_at, updated_at
+		       opening_time, closing_time, tax_rate, service_charge_rate, prices_include_tax,
+		       session_lifetime_minutes, last_order_minutes, menu_base_url, timezone,
+		       branch_code, order_number_format, order_number_daily_reset, is_active, created_at, updated_at
 		FROM branches WHERE id = $1 AND organization_id = $2
 	`, id, orgID).Scan(&b.ID, &b.OrganizationID, &b.Name, &b.Slug, &b.Address, &b.City, &b.Province, &b.PostalCode, &b.Phone, &b.Email,
-		&openingTime, &closingTime, &b.IsActive, &b.CreatedAt, &b.UpdatedAt)
+		&openingTime, &closingTime, &b.TaxRate, &b.ServiceChargeRate, &b.PricesIncludeTax,
+		&b.SessionLifetime, &b.LastOrderMinutes, &b.MenuBaseURL, &b.Timezone,
+		&b.BranchCode, &b.OrderNumberFormat, &b.OrderNumberDaily, &b.IsActive, &b.CreatedAt, &b.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "branch_not_found"})
@@ -772,13 +855,70 @@ func updateBranch(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
 		return
 	}
+	if !validRate(req.TaxRate) || !validRate(req.ServiceChargeRate) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_rate"})
+		return
+	}
+	if !validMinutes(req.SessionLifetime) || !validMinutes(req.LastOrderMinutes) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_session_window"})
+		return
+	}
+	if !validBaseURL(req.MenuBaseURL) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_menu_base_url"})
+		return
+	}
+	if ok, err := validTimezone(db, req.Timezone); err != nil {
+		log.Printf("Failed to check timezone: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	} else if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_timezone"})
+		return
+	}
+	if !validBranchCode(req.BranchCode) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_branch_code"})
+		return
+	}
+	if req.OrderNumberFormat != nil || req.OrderNumberDaily != nil {
+		// Validate the combination the branch will end up with.
+		var format string
+		var daily bool
+		err := db.QueryRow(`SELECT order_number_format, order_number_daily_reset FROM branches WHERE id = $1 AND organization_id = $2`, id, orgID).Scan(&format, &daily)
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "branch_not_found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get branch: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if req.OrderNumberDaily != nil {
+			daily = *req.OrderNumberDaily
+		}
+		if code := orderNumberFormatError(valueOr(req.OrderNumberFormat, format), daily); code != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+			return
+		}
+	}
 
 	_, err := db.Exec(`
 		UPDATE branches SET name = $1, slug = $2, address = $3, city = $4, province = $5, postal_code = $6,
-		                   phone = $7, email = $8, opening_time = $9, closing_time = $10, updated_at = NOW()
-		WHERE id = $11 AND organization_id = $12
+		                   phone = $7, email = $8, opening_time = $9, closing_time = $10,
+		                   tax_rate = COALESCE($11, tax_rate), service_charge_rate = COALESCE($12, service_charge_rate),
+		                   prices_include_tax = COALESCE($13, prices_include_tax),
+		                   session_lifetime_minutes = COALESCE($14, session_lifetime_minutes),
+		                   last_order_minutes = COALESCE($15, last_order_minutes),
+		                   menu_base_url = COALESCE($16, menu_base_url),
+		                   timezone = COALESCE($17, timezone),
+		                   branch_code = COALESCE($18, branch_code),
+		                   order_number_format = COALESCE($19, order_number_format),
+		                   order_number_daily_reset = COALESCE($20, order_number_daily_reset), updated_at = NOW()
+		WHERE id = $21 AND organization_id = $22
 	`, req.Name, req.Slug, req.Address, req.City, req.Province, req.PostalCode, req.Phone, req.Email,
-		req.OpeningTime, req.ClosingTime, id, orgID)
+		req.OpeningTime, req.ClosingTime, req.TaxRate, req.ServiceChargeRate, req.PricesIncludeTax,
+		req.SessionLifetime, req.LastOrderMinutes, req.MenuBaseURL, req.Timezone,
+		req.BranchCode, req.OrderNumberFormat, req.OrderNumberDaily, id, orgID)
 
 	if err != nil {
 		log.Printf("Failed to update branch: %v", err)
@@ -789,6 +929,80 @@ func updateBranch(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
 
+// validRate accepts an unset rate or a percentage between 0 and 100.
+func validRate(rate *float64) bool {
+	return rate == nil || (*rate >= 0 && *rate <= 100)
+}
+
+// validTimezone accepts an unset timezone or a name PostgreSQL knows, so
+// menu schedules can convert to branch-local time.
+func validTimezone(db *sql.DB, tz *string) (bool, error) {
+	if tz == nil {
+		return true, nil
+	}
+	var ok bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM pg_timezone_names WHERE name = $1)`, *tz).Scan(&ok)
+	return ok, err
+}
+
+// validMinutes accepts an unset duration or one between 0 and 24 hours.
+func validMinutes(minutes *int) bool {
+	return minutes == nil || (*minutes >= 0 && *minutes <= 24*60)
+}
+
+// validBaseURL accepts an unset or empty URL, or an absolute http(s) URL.
+func validBaseURL(raw *string) bool {
+	if raw == nil || *raw == "" {
+		return true
+	}
+	u, err := url.Parse(*raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const defaultOrderNumberFormat = "{seq:4}"
+
+// orderNumberTokens matches the placeholders generate_order_number understands.
+var orderNumberTokens = regexp.MustCompile(`\{(branch|date|seq(:[1-9])?)\}`)
+
+// orderNumberFormatError returns an error code when the format cannot produce
+// unique order numbers: it needs exactly one {seq} (optionally padded, {seq:4}),
+// and {date} when numbering restarts daily.
+func orderNumberFormatError(format string, daily bool) string {
+	if len(format) > 40 || strings.Count(format, "{seq") != 1 {
+		return "invalid_order_number_format"
+	}
+	if rest := orderNumberTokens.ReplaceAllString(format, ""); strings.ContainsAny(rest, "{}") {
+		return "invalid_order_number_format"
+	}
+	if daily && !strings.Contains(format, "{date}") {
+		return "order_number_date_required"
+	}
+	return ""
+}
+
+// validBranchCode accepts an unset code or up to 10 letters, digits and dashes.
+func validBranchCode(code *string) bool {
+	if code == nil {
+		return true
+	}
+	if len(*code) > 10 {
+		return false
+	}
+	for _, c := range *code {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func valueOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
 // listManagers returns active managers with org/branch context for admins.
 func listManagers(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	role := r.Header.Get("X-User-Role")
@@ -1211,3 +1425,63 @@ func deleteCashier(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
+
+// validPIN accepts 4 to 8 digits.
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// setApprovalPIN sets or clears (empty pin) the caller's approval PIN.
+func setApprovalPIN(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	role := r.Header.Get("X-User-Role")
+	if role != "MANAGER" && role != "ADMIN" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return
+	}
+	userID := r.Header.Get("X-User-ID")
+
+	var req struct {
+		PIN string `json:"pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	var hash any
+	if req.PIN != "" {
+		if !validPIN(req.PIN) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_pin"})
+			return
+		}
+		// order-service checks approvals with bcrypt.CompareHashAndPassword
+		sum, err := bcrypt.GenerateFromPassword([]byte(req.PIN), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Failed to hash approval pin: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "hash_error"})
+			return
+		}
+		hash = string(sum)
+	}
+
+	res, err := db.Exec(`UPDATE users SET approval_pin_hash = $1, updated_at = NOW() WHERE id = $2`, hash, userID)
+	if err != nil {
+		log.Printf("Failed to set approval pin: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user_not_found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
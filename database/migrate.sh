@@ -21,8 +21,21 @@ else
   psql -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" -c "CREATE DATABASE $DB_NAME;"
 fi
 
-# Run schema
-echo "Running schema.sql..."
-psql -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" -d "$DB_NAME" -f "$(dirname "$0")/schema.sql" > /dev/null
+# Run schema on an empty database only: on an existing one it would create
+# new tables in their latest shape ahead of the migrations that expect to
+# convert them
+if psql -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" -d "$DB_NAME" -tAc "SELECT to_regclass('public.organizations')" | grep -q organizations; then
+  echo "Schema already loaded, skipping schema.sql"
+else
+  echo "Running schema.sql..."
+  psql -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" -d "$DB_NAME" -v ON_ERROR_STOP=1 -f "$(dirname "$0")/schema.sql" > /dev/null
+fi
+
+# Bring databases created from an older schema.sql up to date, starting with
+# 000 for the original schema; each migration is a no-op on a fresh database
+for migration in "$(dirname "$0")"/migrations/*.sql; do
+  echo "Running $(basename "$migration")..."
+  psql -h "$DB_HOST" -p "$DB_PORT" -U "$DB_USER" -d "$DB_NAME" -v ON_ERROR_STOP=1 -f "$migration" > /dev/null
+done
 
 echo "✓ Migrations complete"
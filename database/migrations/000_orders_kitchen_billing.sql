@@ -0,0 +1,468 @@
+-- ============================================================================
+-- 000: order workflow, kitchen stations and billing
+-- ============================================================================
+-- Brings a database created from the original schema.sql up to the schema
+-- the later migrations start from: branch tax, QR session and order-number
+-- settings, order types, versions and status history, item modifiers, notes,
+-- courses and voids, kitchen stations, bill splits, adjustments, refunds,
+-- table moves, the order timeline and note presets.
+-- Existing rows: COOKING items become PREPARING and REMOVED items CANCELLED;
+-- ready_at is renamed prepared_at; orders without a table become TAKEAWAY,
+-- and the paid ones count as picked up so the pickup queue starts empty.
+-- kitchen_station_categories is created keyed by category text, which 001
+-- converts; 001 also creates v_order_item_stations, 006 generate_order_number
+-- and 007 v_kitchen_display.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+-- Branch settings
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 7.00;
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS service_charge_rate NUMERIC(5, 2) NOT NULL DEFAULT 0.00;
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS session_lifetime_minutes INT NOT NULL DEFAULT 120 CHECK (session_lifetime_minutes >= 0);
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS last_order_minutes INT NOT NULL DEFAULT 15 CHECK (last_order_minutes >= 0);
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS menu_base_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS branch_code VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS order_number_format VARCHAR(40) NOT NULL DEFAULT '{seq:4}';
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS order_number_daily_reset BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS approval_pin_hash VARCHAR(64);
+
+-- QR session expiry and final bills
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS last_order_at TIMESTAMP;
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS expired_at TIMESTAMP;
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS closed_by UUID REFERENCES users(id);
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS final_bill JSONB;
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS close_override_reason TEXT;
+ALTER TABLE qr_sessions ADD COLUMN IF NOT EXISTS merged_into UUID REFERENCES qr_sessions(id);
+
+CREATE INDEX IF NOT EXISTS idx_qr_sessions_expiry ON qr_sessions(expires_at) WHERE is_active = true AND expired_at IS NULL;
+
+-- Orders
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS order_code VARCHAR(64);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS customer_name VARCHAR(100);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS customer_phone VARCHAR(30);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_address TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promised_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS picked_up_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS notes VARCHAR(500);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS service_charge NUMERIC(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'orders' AND column_name = 'order_type'
+  ) THEN
+    RETURN;
+  END IF;
+
+  ALTER TABLE orders ADD COLUMN order_type VARCHAR(20) NOT NULL DEFAULT 'DINE_IN';
+
+  UPDATE orders
+  SET order_type = 'TAKEAWAY',
+      picked_up_at = CASE WHEN status = 'PAID' THEN COALESCE(paid_at, updated_at) END
+  WHERE table_id IS NULL;
+END $$;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS valid_status;
+ALTER TABLE orders ADD CONSTRAINT valid_status CHECK (status IN ('OPEN', 'CONFIRMED', 'COMPLETED', 'PAID', 'CANCELLED'));
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'orders'::REGCLASS AND conname = 'valid_order_type') THEN
+    ALTER TABLE orders ADD CONSTRAINT valid_order_type CHECK (order_type IN ('DINE_IN', 'TAKEAWAY', 'DELIVERY'));
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'orders'::REGCLASS AND conname = 'delivery_has_address') THEN
+    ALTER TABLE orders ADD CONSTRAINT delivery_has_address CHECK (order_type <> 'DELIVERY' OR delivery_address IS NOT NULL);
+  END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_orders_branch_created ON orders(branch_id, created_at DESC, id DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_orders_branch_code ON orders(branch_id, order_code) WHERE order_code IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_orders_pickup_queue ON orders(branch_id, created_at)
+  WHERE order_type <> 'DINE_IN' AND picked_up_at IS NULL AND status <> 'CANCELLED';
+
+CREATE TABLE IF NOT EXISTS order_number_counters (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  period_key VARCHAR(8) NOT NULL,
+  last_number INT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (branch_id, period_key)
+);
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  from_status VARCHAR(20) NOT NULL,
+  to_status VARCHAR(20) NOT NULL,
+  reason TEXT,
+  changed_by UUID REFERENCES users(id),
+  changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_id, changed_at);
+
+-- Order items
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'order_items' AND column_name = 'ready_at'
+  ) THEN
+    ALTER TABLE order_items RENAME COLUMN ready_at TO prepared_at;
+  END IF;
+END $$;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS prepared_at TIMESTAMP;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS modifiers JSONB;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS notes VARCHAR(200);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS course SMALLINT NOT NULL DEFAULT 1 CHECK (course BETWEEN 1 AND 9);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS held BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS fired_at TIMESTAMP;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS void_type VARCHAR(10);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS void_reason_code VARCHAR(30);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS void_note TEXT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS voided_by UUID REFERENCES users(id);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS void_approved_by UUID REFERENCES users(id);
+
+UPDATE order_items SET item_status = 'PREPARING' WHERE item_status = 'COOKING';
+UPDATE order_items SET item_status = 'CANCELLED' WHERE item_status = 'REMOVED';
+
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_item_status_check;
+ALTER TABLE order_items ADD CONSTRAINT order_items_item_status_check
+  CHECK (item_status IN ('PENDING', 'PREPARING', 'READY', 'SERVED', 'CANCELLED'));
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'order_items'::REGCLASS AND conname = 'valid_void_type') THEN
+    ALTER TABLE order_items ADD CONSTRAINT valid_void_type CHECK (void_type IS NULL OR void_type IN ('VOID', 'COMP'));
+  END IF;
+END $$;
+
+DROP INDEX IF EXISTS idx_order_items_status;
+CREATE INDEX idx_order_items_status ON order_items(item_status) WHERE item_status IN ('PENDING', 'PREPARING', 'READY');
+CREATE INDEX IF NOT EXISTS idx_order_items_held ON order_items(order_id, course) WHERE held;
+CREATE INDEX IF NOT EXISTS idx_order_items_voided ON order_items(cancelled_at DESC) WHERE void_type IS NOT NULL;
+
+-- Bill splits
+CREATE TABLE IF NOT EXISTS bill_splits (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID REFERENCES orders(id) ON DELETE CASCADE,
+  qr_session_id UUID REFERENCES qr_sessions(id) ON DELETE CASCADE,
+  mode VARCHAR(20) NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE',
+  total_amount NUMERIC(10, 2) NOT NULL,
+  created_by UUID REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  settled_at TIMESTAMP,
+  cancelled_at TIMESTAMP,
+  CONSTRAINT valid_split_target CHECK ((order_id IS NULL) <> (qr_session_id IS NULL)),
+  CONSTRAINT valid_split_mode CHECK (mode IN ('BY_ITEMS', 'EQUAL', 'CUSTOM')),
+  CONSTRAINT valid_split_status CHECK (status IN ('ACTIVE', 'SETTLED', 'CANCELLED'))
+);
+
+CREATE TABLE IF NOT EXISTS bill_split_orders (
+  split_id UUID NOT NULL REFERENCES bill_splits(id) ON DELETE CASCADE,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  PRIMARY KEY (split_id, order_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bill_split_orders_order ON bill_split_orders(order_id);
+
+CREATE TABLE IF NOT EXISTS bill_split_shares (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  split_id UUID NOT NULL REFERENCES bill_splits(id) ON DELETE CASCADE,
+  position INT NOT NULL,
+  label VARCHAR(100) NOT NULL,
+  amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+  status VARCHAR(20) NOT NULL DEFAULT 'UNPAID',
+  payment_method VARCHAR(50),
+  idempotency_key VARCHAR(255),
+  paid_at TIMESTAMP,
+  UNIQUE(split_id, position),
+  CONSTRAINT valid_share_status CHECK (status IN ('UNPAID', 'PAID'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_bill_split_shares_split ON bill_split_shares(split_id);
+
+CREATE TABLE IF NOT EXISTS bill_split_share_items (
+  share_id UUID NOT NULL REFERENCES bill_split_shares(id) ON DELETE CASCADE,
+  order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+  PRIMARY KEY (share_id, order_item_id)
+);
+
+-- Adjustments of paid orders and refunds
+CREATE TABLE IF NOT EXISTS order_adjustments (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  adjustment_number INT NOT NULL,
+  adjustment_type VARCHAR(20) NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+  reason VARCHAR(500) NOT NULL,
+  subtotal NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  service_charge NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  tax NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  total_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  created_by UUID REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  settled_at TIMESTAMP,
+  cancelled_at TIMESTAMP,
+  UNIQUE(order_id, adjustment_number),
+  CONSTRAINT valid_adjustment_type CHECK (adjustment_type IN ('CHARGE', 'REFUND', 'EVEN')),
+  CONSTRAINT valid_adjustment_status CHECK (status IN ('PENDING', 'SETTLED', 'CANCELLED')),
+  CONSTRAINT adjustment_amounts_positive CHECK (subtotal >= 0 AND total_amount >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_adjustments_order ON order_adjustments(order_id, adjustment_number);
+
+CREATE TABLE IF NOT EXISTS order_adjustment_items (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  adjustment_id UUID NOT NULL REFERENCES order_adjustments(id) ON DELETE CASCADE,
+  kind VARCHAR(10) NOT NULL,
+  order_item_id UUID REFERENCES order_items(id),
+  menu_item_id UUID NOT NULL,
+  menu_item_name VARCHAR(255) NOT NULL,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  unit_price NUMERIC(10, 2) NOT NULL,
+  item_total NUMERIC(10, 2) NOT NULL,
+  modifiers JSONB,
+  notes VARCHAR(200),
+  CONSTRAINT valid_adjustment_item_kind CHECK (kind IN ('ADD', 'REFUND')),
+  CONSTRAINT refund_has_item CHECK (kind <> 'REFUND' OR order_item_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_adjustment_items_adjustment ON order_adjustment_items(adjustment_id);
+CREATE INDEX IF NOT EXISTS idx_order_adjustment_items_refunded ON order_adjustment_items(order_item_id) WHERE kind = 'REFUND';
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS split_share_id UUID REFERENCES bill_split_shares(id);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS adjustment_id UUID REFERENCES order_adjustments(id);
+
+CREATE TABLE IF NOT EXISTS refunds (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  adjustment_id UUID NOT NULL UNIQUE REFERENCES order_adjustments(id) ON DELETE CASCADE,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+  status VARCHAR(20) NOT NULL DEFAULT 'REQUESTED',
+  refund_method VARCHAR(50),
+  reference VARCHAR(255),
+  requested_by UUID REFERENCES users(id),
+  processed_by UUID REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMP,
+  CONSTRAINT valid_refund_status CHECK (status IN ('REQUESTED', 'COMPLETED', 'CANCELLED'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_requested ON refunds(created_at) WHERE status = 'REQUESTED';
+
+-- Option group rules
+CREATE TABLE IF NOT EXISTS product_option_groups (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  name VARCHAR(100) NOT NULL,
+  selection_type VARCHAR(10) NOT NULL DEFAULT 'SINGLE',
+  min_selections INT NOT NULL DEFAULT 0,
+  max_selections INT,
+  sort_order INTEGER DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE(product_id, name),
+  CONSTRAINT valid_selection_type CHECK (selection_type IN ('SINGLE', 'MULTIPLE')),
+  CONSTRAINT valid_selection_range CHECK (
+    min_selections >= 0 AND (max_selections IS NULL OR max_selections >= GREATEST(min_selections, 1))
+  )
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_option_groups_product ON product_option_groups(product_id);
+
+-- Kitchen stations
+CREATE TABLE IF NOT EXISTS kitchen_stations (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  code VARCHAR(50) NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  is_default BOOLEAN NOT NULL DEFAULT false,
+  sort_order INTEGER DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE(branch_id, code)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_kitchen_stations_default ON kitchen_stations(branch_id) WHERE is_default = true;
+
+CREATE TABLE IF NOT EXISTS kitchen_station_categories (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  category VARCHAR(100) NOT NULL,
+  station_id UUID NOT NULL REFERENCES kitchen_stations(id) ON DELETE CASCADE,
+  PRIMARY KEY (branch_id, category)
+);
+
+CREATE INDEX IF NOT EXISTS idx_kitchen_station_categories_station ON kitchen_station_categories(station_id);
+
+-- Table moves, order timeline and note presets
+CREATE TABLE IF NOT EXISTS table_moves (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL,
+  branch_id UUID REFERENCES branches(id) ON DELETE SET NULL,
+  kind VARCHAR(20) NOT NULL,
+  from_table_id INT NOT NULL REFERENCES tables(id),
+  to_table_id INT NOT NULL REFERENCES tables(id),
+  from_qr_session_id UUID REFERENCES qr_sessions(id),
+  to_qr_session_id UUID REFERENCES qr_sessions(id),
+  order_ids UUID[] NOT NULL DEFAULT '{}',
+  token_reissued BOOLEAN NOT NULL DEFAULT false,
+  reason TEXT,
+  moved_by UUID REFERENCES users(id),
+  moved_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_move_kind CHECK (kind IN ('TRANSFER', 'MERGE')),
+  CONSTRAINT distinct_move_tables CHECK (from_table_id <> to_table_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_table_moves_branch ON table_moves(branch_id, moved_at DESC);
+
+CREATE TABLE IF NOT EXISTS order_events (
+  id BIGSERIAL PRIMARY KEY,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  source VARCHAR(20) NOT NULL,
+  event_type VARCHAR(40) NOT NULL,
+  actor_id UUID REFERENCES users(id),
+  before_value JSONB,
+  after_value JSONB,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_events_order ON order_events(order_id, created_at, id);
+
+CREATE TABLE IF NOT EXISTS note_presets (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  label VARCHAR(200) NOT NULL,
+  sort_order INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT uq_note_preset_label UNIQUE (organization_id, label)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_presets_org ON note_presets(organization_id, sort_order);
+
+-- Views: kitchen_display became v_kitchen_display (007)
+DROP VIEW IF EXISTS kitchen_display;
+
+CREATE OR REPLACE VIEW open_bills AS
+SELECT
+  o.id,
+  o.order_number,
+  COALESCE(t.table_number::TEXT, 'TAKEAWAY') AS location,
+  o.total_amount,
+  o.created_at,
+  EXTRACT(EPOCH FROM (NOW() - o.created_at))/60 AS minutes_open,
+  COUNT(DISTINCT oi.id) AS item_count,
+  SUM(CASE WHEN oi.item_status = 'SERVED' THEN 1 ELSE 0 END) AS served_items,
+  SUM(CASE WHEN oi.item_status IN ('PENDING', 'PREPARING', 'READY') THEN 1 ELSE 0 END) AS pending_items
+FROM orders o
+LEFT JOIN tables t ON o.table_id = t.id
+LEFT JOIN order_items oi ON o.id = oi.order_id
+WHERE o.status IN ('OPEN', 'CONFIRMED')
+GROUP BY o.id, t.table_number;
+
+-- Functions
+CREATE OR REPLACE FUNCTION branch_charges(p_branch_id UUID, p_net NUMERIC)
+RETURNS TABLE (new_service_charge NUMERIC, new_tax NUMERIC, new_total NUMERIC) AS $$
+DECLARE
+  v_tax_rate NUMERIC(5, 2) := 7.00;
+  v_service_rate NUMERIC(5, 2) := 0.00;
+  v_inclusive BOOLEAN := true;
+  v_service NUMERIC(10, 2);
+  v_tax NUMERIC(10, 2);
+  v_total NUMERIC(10, 2);
+BEGIN
+  SELECT COALESCE(b.tax_rate, 7.00), COALESCE(b.service_charge_rate, 0.00), COALESCE(b.prices_include_tax, true)
+  INTO v_tax_rate, v_service_rate, v_inclusive
+  FROM branches b
+  WHERE b.id = p_branch_id;
+
+  IF NOT FOUND THEN
+    v_tax_rate := 7.00;
+    v_service_rate := 0.00;
+    v_inclusive := true;
+  END IF;
+
+  v_service := ROUND(p_net * v_service_rate / 100, 2);
+
+  IF v_inclusive THEN
+    v_tax := ROUND((p_net + v_service) * v_tax_rate / (100 + v_tax_rate), 2);
+    v_total := p_net + v_service;
+  ELSE
+    v_tax := ROUND((p_net + v_service) * v_tax_rate / 100, 2);
+    v_total := p_net + v_service + v_tax;
+  END IF;
+
+  RETURN QUERY SELECT v_service::NUMERIC, v_tax::NUMERIC, v_total::NUMERIC;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION recalc_order_totals(p_order_id UUID)
+RETURNS TABLE (new_subtotal NUMERIC, new_service_charge NUMERIC, new_tax NUMERIC, new_total NUMERIC) AS $$
+DECLARE
+  v_subtotal NUMERIC(10, 2);
+  v_discount NUMERIC(10, 2);
+  v_branch_id UUID;
+  v_net NUMERIC(10, 2);
+  v_service NUMERIC(10, 2);
+  v_tax NUMERIC(10, 2);
+  v_total NUMERIC(10, 2);
+BEGIN
+  SELECT o.discount_amount, o.branch_id
+  INTO v_discount, v_branch_id
+  FROM orders o
+  WHERE o.id = p_order_id
+  FOR UPDATE OF o;
+
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+
+  SELECT COALESCE(SUM(oi.item_total), 0)
+  INTO v_subtotal
+  FROM order_items oi
+  WHERE oi.order_id = p_order_id
+    AND oi.item_status <> 'CANCELLED';
+
+  v_net := GREATEST(v_subtotal - v_discount, 0);
+
+  SELECT c.new_service_charge, c.new_tax, c.new_total
+  INTO v_service, v_tax, v_total
+  FROM branch_charges(v_branch_id, v_net) c;
+
+  UPDATE orders
+  SET subtotal = v_subtotal,
+      service_charge = v_service,
+      tax = v_tax,
+      total_amount = v_total,
+      updated_at = NOW()
+  WHERE id = p_order_id;
+
+  RETURN QUERY SELECT v_subtotal::NUMERIC, v_service::NUMERIC, v_tax::NUMERIC, v_total::NUMERIC;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION bump_order_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.version := OLD.version + 1;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_orders_version ON orders;
+CREATE TRIGGER trg_orders_version
+BEFORE UPDATE ON orders
+FOR EACH ROW EXECUTE FUNCTION bump_order_version();
+
+COMMIT;
@@ -0,0 +1,142 @@
+-- ============================================================================
+-- 001: products.category (free text) -> categories table
+-- ============================================================================
+-- Every distinct category string of an organization becomes a top-level
+-- category; strings that differ only in case or surrounding spaces merge into
+-- one, named after the most used spelling. Products and kitchen station
+-- mappings are re-pointed at the new IDs and the old text columns dropped.
+-- Safe to run again: it does nothing once products.category is gone.
+-- ============================================================================
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS categories (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  parent_id UUID REFERENCES categories(id), -- NULL = top level
+
+  name VARCHAR(100) NOT NULL,
+  description TEXT,
+  image_url TEXT,
+  is_visible BOOLEAN NOT NULL DEFAULT true,
+
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT category_not_own_parent CHECK (parent_id <> id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_sibling_name ON categories(
+  organization_id, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'::UUID), LOWER(name)
+);
+CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_id);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'products' AND column_name = 'category'
+  ) THEN
+    RAISE NOTICE 'products.category already migrated';
+    RETURN;
+  END IF;
+
+  -- One category per organization and case-insensitive name
+  INSERT INTO categories (organization_id, name, sort_order)
+  SELECT organization_id, name, ROW_NUMBER() OVER (PARTITION BY organization_id ORDER BY LOWER(name)) - 1
+  FROM (
+    SELECT DISTINCT ON (organization_id, LOWER(TRIM(category)))
+           organization_id, LEFT(TRIM(category), 100) AS name
+    FROM products
+    WHERE TRIM(COALESCE(category, '')) <> ''
+    GROUP BY organization_id, TRIM(category)
+    ORDER BY organization_id, LOWER(TRIM(category)), COUNT(*) DESC, TRIM(category)
+  ) names
+  ON CONFLICT DO NOTHING;
+
+  ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id UUID REFERENCES categories(id) ON DELETE SET NULL;
+
+  UPDATE products p
+  SET category_id = c.id
+  FROM categories c
+  WHERE c.organization_id = p.organization_id
+    AND c.parent_id IS NULL
+    AND LOWER(c.name) = LOWER(LEFT(TRIM(p.category), 100));
+
+  -- Station mappings: one row per branch and category; where two spellings
+  -- pointed at different stations the first station by sort order wins
+  ALTER TABLE kitchen_station_categories ADD COLUMN category_id UUID REFERENCES categories(id) ON DELETE CASCADE;
+
+  UPDATE kitchen_station_categories ksc
+  SET category_id = c.id
+  FROM branches b, categories c
+  WHERE b.id = ksc.branch_id
+    AND c.organization_id = b.organization_id
+    AND c.parent_id IS NULL
+    AND LOWER(c.name) = LOWER(LEFT(TRIM(ksc.category), 100));
+
+  DELETE FROM kitchen_station_categories WHERE category_id IS NULL;
+
+  DELETE FROM kitchen_station_categories ksc
+  USING kitchen_station_categories keep, kitchen_stations ks_keep, kitchen_stations ks
+  WHERE keep.branch_id = ksc.branch_id
+    AND keep.category_id = ksc.category_id
+    AND keep.category <> ksc.category
+    AND ks_keep.id = keep.station_id
+    AND ks.id = ksc.station_id
+    AND (COALESCE(ks_keep.sort_order, 0), keep.category) < (COALESCE(ks.sort_order, 0), ksc.category);
+
+  -- Route by category_id before the text columns go
+  CREATE OR REPLACE VIEW v_order_item_stations AS
+  SELECT
+    oi.id AS item_id,
+    oi.order_id,
+    ks.id AS station_id,
+    ks.code AS station_code,
+    ks.name AS station_name
+  FROM order_items oi
+  JOIN orders o ON o.id = oi.order_id
+  LEFT JOIN products p ON p.id::TEXT = oi.menu_item_id
+  LEFT JOIN LATERAL (
+    WITH RECURSIVE chain AS (
+      SELECT c.id, c.parent_id, 0 AS depth FROM categories c WHERE c.id = p.category_id
+      UNION ALL
+      SELECT c.id, c.parent_id, chain.depth + 1
+      FROM categories c JOIN chain ON c.id = chain.parent_id
+      WHERE chain.depth < 10
+    )
+    SELECT ksc.station_id
+    FROM chain
+    JOIN kitchen_station_categories ksc ON ksc.branch_id = o.branch_id AND ksc.category_id = chain.id
+    ORDER BY chain.depth
+    LIMIT 1
+  ) mapped ON true
+  LEFT JOIN kitchen_stations ks ON ks.id = COALESCE(
+    mapped.station_id,
+    (SELECT d.id FROM kitchen_stations d WHERE d.branch_id = o.branch_id AND d.is_default)
+  );
+
+  ALTER TABLE kitchen_station_categories DROP CONSTRAINT kitchen_station_categories_pkey;
+  ALTER TABLE kitchen_station_categories DROP COLUMN category;
+  ALTER TABLE kitchen_station_categories ALTER COLUMN category_id SET NOT NULL;
+  ALTER TABLE kitchen_station_categories ADD PRIMARY KEY (branch_id, category_id);
+
+  DROP INDEX IF EXISTS idx_products_category;
+  ALTER TABLE products DROP COLUMN category;
+  CREATE INDEX idx_products_category ON products(category_id);
+END $$;
+
+CREATE OR REPLACE VIEW v_category_tree AS
+WITH RECURSIVE tree AS (
+  SELECT c.id, c.organization_id, 0 AS depth, c.is_visible AS visible_path, ARRAY[c.id] AS path
+  FROM categories c
+  WHERE c.parent_id IS NULL
+  UNION ALL
+  SELECT c.id, c.organization_id, tree.depth + 1, tree.visible_path AND c.is_visible, tree.path || c.id
+  FROM categories c
+  JOIN tree ON c.parent_id = tree.id
+)
+SELECT id AS category_id, organization_id, depth, visible_path, path FROM tree;
+
+COMMIT;
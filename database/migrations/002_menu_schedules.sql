@@ -0,0 +1,114 @@
+-- ============================================================================
+-- 002: scheduled menus and branch timezones
+-- ============================================================================
+-- Adds branches.timezone (existing branches get Asia/Bangkok), the menu
+-- tables and the product_on_menu check. No menus exist afterwards, so every
+-- product stays orderable all day until a manager sets some up.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS timezone VARCHAR(50) NOT NULL DEFAULT 'Asia/Bangkok';
+
+-- MENUS (Named menu schedules: breakfast, lunch, late night...)
+-- A product on one or more menus (directly or through its category or a parent
+-- category) can only be ordered while one of them is open; products on no menu
+-- are served all day
+CREATE TABLE IF NOT EXISTS menus (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+
+  name VARCHAR(100) NOT NULL,
+  is_active BOOLEAN NOT NULL DEFAULT true, -- false = never open, whatever the windows say
+
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT uq_menu_name UNIQUE (organization_id, name)
+);
+
+-- When a menu is open, in each branch's local time (branches.timezone)
+-- end_time before start_time runs past midnight (22:00-02:00 on Friday ends
+-- Saturday 02:00); start_time = end_time is the whole day
+CREATE TABLE IF NOT EXISTS menu_windows (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+
+  days SMALLINT[] NOT NULL DEFAULT '{0,1,2,3,4,5,6}', -- days the window starts on, 0 = Sunday
+  start_time TIME NOT NULL,
+  end_time TIME NOT NULL,
+
+  CONSTRAINT valid_window_days CHECK (days <@ '{0,1,2,3,4,5,6}'::SMALLINT[] AND cardinality(days) > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_menu_windows_menu ON menu_windows(menu_id);
+
+CREATE TABLE IF NOT EXISTS menu_products (
+  menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  PRIMARY KEY (menu_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_menu_products_product ON menu_products(product_id);
+
+-- A category brings its subcategories onto the menu too
+CREATE TABLE IF NOT EXISTS menu_categories (
+  menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+  category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+  PRIMARY KEY (menu_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_menu_categories_category ON menu_categories(category_id);
+
+-- Function: is a menu open at a branch-local time?
+-- Windows past midnight count for the day they start on
+CREATE OR REPLACE FUNCTION menu_open_at(p_menu_id UUID, p_local TIMESTAMP)
+RETURNS BOOLEAN AS $$
+  SELECT EXISTS (
+    SELECT 1
+    FROM menus m
+    JOIN menu_windows w ON w.menu_id = m.id
+    WHERE m.id = p_menu_id
+      AND m.is_active
+      AND CASE
+        WHEN w.start_time = w.end_time THEN
+          EXTRACT(DOW FROM p_local)::SMALLINT = ANY(w.days)
+        WHEN w.start_time < w.end_time THEN
+          EXTRACT(DOW FROM p_local)::SMALLINT = ANY(w.days)
+          AND p_local::TIME >= w.start_time AND p_local::TIME < w.end_time
+        ELSE
+          (EXTRACT(DOW FROM p_local)::SMALLINT = ANY(w.days) AND p_local::TIME >= w.start_time)
+          OR (EXTRACT(DOW FROM p_local - INTERVAL '1 day')::SMALLINT = ANY(w.days) AND p_local::TIME < w.end_time)
+      END
+  );
+$$ LANGUAGE sql STABLE;
+
+-- Function: can the product be ordered in the branch right now, as far as menu
+-- schedules go? True when it is on no menu at all; a NULL branch uses the
+-- default timezone
+CREATE OR REPLACE FUNCTION product_on_menu(p_product_id UUID, p_branch_id UUID)
+RETURNS BOOLEAN AS $$
+  WITH product_menus AS (
+    SELECT mp.menu_id FROM menu_products mp WHERE mp.product_id = p_product_id
+    UNION
+    SELECT mc.menu_id
+    FROM products p
+    JOIN v_category_tree t ON t.category_id = p.category_id
+    JOIN menu_categories mc ON mc.category_id = ANY(t.path)
+    WHERE p.id = p_product_id
+  ),
+  branch_now AS (
+    SELECT NOW() AT TIME ZONE COALESCE(
+      (SELECT timezone FROM branches WHERE id = p_branch_id), 'Asia/Bangkok'
+    ) AS local_time
+  )
+  SELECT NOT EXISTS (SELECT 1 FROM product_menus)
+      OR EXISTS (
+        SELECT 1 FROM product_menus pm, branch_now
+        WHERE menu_open_at(pm.menu_id, branch_now.local_time)
+      );
+$$ LANGUAGE sql STABLE;
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- ============================================================================
+-- 003: published menu versions
+-- ============================================================================
+-- Adds menu_versions and the version columns on orders and order items.
+-- Nothing is published by the migration: until a manager publishes the first
+-- version, menus and prices keep coming straight from the catalog tables.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS menu_versions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  version INT NOT NULL,
+  snapshot JSONB NOT NULL,
+  note TEXT,
+  restored_from INT,
+  published_by UUID REFERENCES users(id),
+  published_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT uq_menu_version UNIQUE (organization_id, version)
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS menu_version_id UUID REFERENCES menu_versions(id);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS menu_version_id UUID REFERENCES menu_versions(id);
+
+CREATE OR REPLACE FUNCTION forbid_menu_version_update()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'menu version % of organization % is immutable', OLD.version, OLD.organization_id;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_menu_versions_immutable ON menu_versions;
+CREATE TRIGGER trg_menu_versions_immutable
+BEFORE UPDATE ON menu_versions
+FOR EACH ROW EXECUTE FUNCTION forbid_menu_version_update();
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- ============================================================================
+-- 004: product SKUs
+-- ============================================================================
+-- Adds products.sku, unique per organization, for catalog import/export.
+-- Existing products get no SKU; set one before importing over them.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_products_sku ON products(organization_id, sku) WHERE sku IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,24 @@
+-- ============================================================================
+-- 005: approval PIN lockout
+-- ============================================================================
+-- Records wrong manager approval PINs so order-service can lock out users
+-- and branches that keep guessing.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS approval_pin_failures (
+  id BIGSERIAL PRIMARY KEY,
+  organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+  branch_id UUID REFERENCES branches(id) ON DELETE CASCADE,
+  user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+  order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+  item_id UUID,
+  attempted_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_approval_pin_failures_user ON approval_pin_failures(user_id, attempted_at DESC);
+CREATE INDEX IF NOT EXISTS idx_approval_pin_failures_branch ON approval_pin_failures(branch_id, attempted_at DESC);
+
+COMMIT;
@@ -0,0 +1,46 @@
+-- ============================================================================
+-- 007: paid takeaway orders on the kitchen display
+-- ============================================================================
+-- v_kitchen_display keeps takeaway and delivery orders that were paid up
+-- front until they are picked up, instead of dropping them at payment.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+-- View: Kitchen display system (what needs to be cooked)
+-- Real-time view of what kitchen needs to see, routed to stations
+CREATE OR REPLACE VIEW v_kitchen_display AS
+SELECT 
+  o.id AS order_id,
+  o.organization_id,
+  o.branch_id,
+  o.order_number,
+  o.order_code,
+  t.table_number,
+  oi.id AS item_id,
+  oi.menu_item_name,
+  oi.quantity,
+  oi.course,
+  oi.item_status,
+  oi.notes AS item_notes,
+  o.notes AS order_notes,
+  s.station_id,
+  s.station_code,
+  s.station_name,
+  oi.created_at,
+  COALESCE(oi.fired_at, oi.created_at) AS sent_at,
+  oi.prepared_at,
+  EXTRACT(EPOCH FROM (NOW() - COALESCE(oi.fired_at, oi.created_at)))/60 AS minutes_waiting
+FROM orders o
+LEFT JOIN tables t ON o.table_id = t.id
+JOIN order_items oi ON o.id = oi.order_id
+JOIN v_order_item_stations s ON s.item_id = oi.id
+WHERE (o.status IN ('OPEN', 'CONFIRMED')
+       -- takeaway and delivery are usually paid before cooking
+       OR (o.status = 'PAID' AND o.order_type <> 'DINE_IN' AND o.picked_up_at IS NULL))
+  AND oi.item_status IN ('PENDING', 'PREPARING', 'READY')
+  AND NOT oi.held -- held courses appear once fired
+ORDER BY sent_at ASC;
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- ============================================================================
+-- 008: branch price and availability overrides
+-- ============================================================================
+-- Adds the branch override tables. Without rows every branch keeps selling
+-- products at the organization's price and availability.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS branch_product_overrides (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  price NUMERIC(10, 2) CHECK (price > 0),
+  is_available BOOLEAN,
+  updated_by UUID REFERENCES users(id),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (branch_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_branch_product_overrides_product ON branch_product_overrides(product_id);
+
+CREATE TABLE IF NOT EXISTS branch_option_overrides (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  option_group VARCHAR(100) NOT NULL,
+  option_name VARCHAR(100) NOT NULL,
+  price_modifier NUMERIC(10, 2) NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (branch_id, product_id, option_group, option_name)
+);
+
+COMMIT;
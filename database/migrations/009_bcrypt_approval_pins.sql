@@ -0,0 +1,17 @@
+-- ============================================================================
+-- 009: bcrypt approval PINs
+-- ============================================================================
+-- Approval PINs are now bcrypt hashes. The old unsalted sha256 hashes can no
+-- longer be verified and are cleared; managers set their PIN again in
+-- auth-service before approving voids and comps.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+UPDATE users
+SET approval_pin_hash = NULL, updated_at = NOW()
+WHERE approval_pin_hash IS NOT NULL
+  AND approval_pin_hash NOT LIKE '$2%';
+
+COMMIT;
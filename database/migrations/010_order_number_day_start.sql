@@ -0,0 +1,71 @@
+-- ============================================================================
+-- 010: order number days independent of the session time zone
+-- ============================================================================
+-- generate_order_number compares orders.created_at (a TIMESTAMP without time
+-- zone, written in the session TimeZone) with the start of the branch's local
+-- day converted to that zone, instead of an implicit timestamptz cast.
+-- Safe to run again.
+-- ============================================================================
+
+BEGIN;
+
+-- Issue the next order number of a branch and render it with the branch format.
+-- Must run inside the order's transaction: the counter row stays locked until
+-- commit, so concurrent orders get consecutive numbers instead of colliding.
+-- A new counter starts after the highest number already used (today's, with
+-- daily reset) so switching settings never reissues a number.
+CREATE OR REPLACE FUNCTION generate_order_number(p_branch_id UUID)
+RETURNS TABLE (seq INT, code TEXT) AS $$
+DECLARE
+  v_format TEXT;
+  v_branch_code TEXT;
+  v_daily BOOLEAN;
+  v_timezone TEXT;
+  v_today DATE;
+  v_day_start TIMESTAMP;
+  v_period VARCHAR(8);
+  v_seq INT;
+  v_width INT;
+  v_code TEXT;
+BEGIN
+  SELECT b.order_number_format, b.branch_code, b.order_number_daily_reset, b.timezone
+  INTO v_format, v_branch_code, v_daily, v_timezone
+  FROM branches b
+  WHERE b.id = p_branch_id;
+
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+
+  -- Days, and {date}, follow the branch's clock, not the database's.
+  v_today := (NOW() AT TIME ZONE v_timezone)::DATE;
+  -- created_at is a plain TIMESTAMP from NOW() in the session TimeZone, so the
+  -- start of the branch's day is compared in that zone too.
+  v_day_start := (v_today::TIMESTAMP AT TIME ZONE v_timezone) AT TIME ZONE current_setting('TimeZone');
+  v_period := CASE WHEN v_daily THEN TO_CHAR(v_today, 'YYYYMMDD') ELSE 'ALL' END;
+
+  UPDATE order_number_counters c
+  SET last_number = c.last_number + 1, updated_at = NOW()
+  WHERE c.branch_id = p_branch_id AND c.period_key = v_period
+  RETURNING c.last_number INTO v_seq;
+
+  IF NOT FOUND THEN
+    INSERT INTO order_number_counters AS c (branch_id, period_key, last_number)
+    SELECT p_branch_id, v_period, COALESCE(MAX(o.order_number), 0) + 1
+    FROM orders o
+    WHERE o.branch_id = p_branch_id
+      AND (NOT v_daily OR o.created_at >= v_day_start)
+    ON CONFLICT (branch_id, period_key)
+      DO UPDATE SET last_number = c.last_number + 1, updated_at = NOW()
+    RETURNING c.last_number INTO v_seq;
+  END IF;
+
+  v_code := replace(replace(v_format, '{branch}', v_branch_code), '{date}', TO_CHAR(v_today, 'YYYYMMDD'));
+  v_width := COALESCE(substring(v_code FROM '\{seq:([0-9])\}')::INT, 0);
+  v_code := regexp_replace(v_code, '\{seq(:[0-9])?\}', LPAD(v_seq::TEXT, GREATEST(v_width, length(v_seq::TEXT)), '0'));
+
+  RETURN QUERY SELECT v_seq, v_code;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMIT;
@@ -38,6 +38,18 @@ CREATE TABLE branches (
   email VARCHAR(255),
   opening_time VARCHAR(20),
   closing_time VARCHAR(20),
+  tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 7.00, -- percent, Thai VAT
+  service_charge_rate NUMERIC(5, 2) NOT NULL DEFAULT 0.00, -- percent
+  prices_include_tax BOOLEAN NOT NULL DEFAULT true, -- menu prices already include VAT
+  session_lifetime_minutes INT NOT NULL DEFAULT 120 CHECK (session_lifetime_minutes >= 0), -- 0 = QR sessions never expire
+  last_order_minutes INT NOT NULL DEFAULT 15 CHECK (last_order_minutes >= 0), -- ordering stops this long before expiry
+  menu_base_url TEXT NOT NULL DEFAULT '', -- customer menu host in table QR codes; '' = service default
+  timezone VARCHAR(50) NOT NULL DEFAULT 'Asia/Bangkok', -- IANA name; menu schedules and order-number days run on local time
+  -- Order numbering (see generate_order_number): {branch} = branch_code, {date} = local YYYYMMDD,
+  -- {seq} or {seq:N} = counter zero-padded to N digits, e.g. '{branch}-{date}-{seq:4}'
+  branch_code VARCHAR(10) NOT NULL DEFAULT '',
+  order_number_format VARCHAR(40) NOT NULL DEFAULT '{seq:4}',
+  order_number_daily_reset BOOLEAN NOT NULL DEFAULT false, -- restart {seq} at 1 every day
   is_active BOOLEAN NOT NULL DEFAULT true,
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
@@ -58,6 +70,7 @@ CREATE TABLE users (
   branch_id UUID REFERENCES branches(id) ON DELETE SET NULL,
   is_active BOOLEAN NOT NULL DEFAULT true,
   last_login_at TIMESTAMP,
+  approval_pin_hash VARCHAR(64), -- managers/admins: bcrypt hash of the PIN, approves voids and comps
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
 
@@ -88,43 +101,95 @@ CREATE TABLE qr_sessions (
   qr_code_token VARCHAR(100) NOT NULL UNIQUE, -- unique token for QR
   is_active BOOLEAN NOT NULL DEFAULT true,
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  closed_at TIMESTAMP
+  closed_at TIMESTAMP,
+  
+  -- Ordering window, from the branch settings at creation (NULL = no expiry)
+  expires_at TIMESTAMP,
+  last_order_at TIMESTAMP, -- guests cannot place orders after this
+  expired_at TIMESTAMP, -- set by the order-service sweeper; the bill stays open until closed
+  
+  -- Final bill of the table (all session orders merged), written on close
+  closed_by UUID REFERENCES users(id),
+  final_bill JSONB,
+  close_override_reason TEXT, -- set when a manager closed with a balance due
+  
+  -- Set when the session was merged into another table's session; the old
+  -- token keeps resolving to the surviving session
+  merged_into UUID REFERENCES qr_sessions(id)
 );
 
 CREATE INDEX idx_qr_sessions_table ON qr_sessions(table_id, is_active);
 CREATE INDEX idx_qr_sessions_token ON qr_sessions(qr_code_token);
+-- Fast lookup: sweeper - active sessions past their lifetime
+CREATE INDEX idx_qr_sessions_expiry ON qr_sessions(expires_at) WHERE is_active = true AND expired_at IS NULL;
+
+-- MENU_VERSIONS (Published, immutable snapshots of an organization's catalog)
+-- The catalog tables (categories, products, options) are the manager's draft;
+-- the QR menu, POS and order pricing serve the newest version. A rollback
+-- republishes an older snapshot as a new version.
+CREATE TABLE menu_versions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  version INT NOT NULL, -- 1, 2, 3... per organization; the highest is live
+
+  -- {"categories": [...], "products": [... with option_groups]}, organization-wide
+  -- values; branch overrides and availability still apply live on top
+  snapshot JSONB NOT NULL,
+  note TEXT,
+  restored_from INT, -- rollbacks: the version whose snapshot was copied
+
+  published_by UUID REFERENCES users(id),
+  published_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT uq_menu_version UNIQUE (organization_id, version)
+);
 
 -- 4. ORDERS (Open bills)
 -- Core table: one order = one bill
--- Status: OPEN -> CONFIRMED -> PAID/CANCELLED
+-- Status: OPEN -> CONFIRMED -> COMPLETED -> PAID, or CANCELLED before payment
 -- Can be created:
 --   - From table (waiter takes order)
 --   - From QR session (customer orders via QR)
---   - Takeaway (no table)
+--   - Takeaway or delivery (no table; customer details, pickup queue)
 CREATE TABLE orders (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL,
   branch_id UUID REFERENCES branches(id) ON DELETE SET NULL,
   table_id INT REFERENCES tables(id), -- NULL for takeaway
   qr_session_id UUID REFERENCES qr_sessions(id), -- NULL if not QR
-  order_number INT NOT NULL, -- for display (e.g., "#0001")
-  status VARCHAR(20) NOT NULL DEFAULT 'OPEN', -- OPEN, CONFIRMED, PAID, CANCELLED
+  order_number INT NOT NULL, -- per-branch counter from generate_order_number
+  order_code VARCHAR(64), -- order_number rendered with the branch format (e.g. "B01-20261016-0042")
+  status VARCHAR(20) NOT NULL DEFAULT 'OPEN', -- OPEN, CONFIRMED, COMPLETED, PAID, CANCELLED
+  order_type VARCHAR(20) NOT NULL DEFAULT 'DINE_IN', -- DINE_IN (has a table), TAKEAWAY, DELIVERY
+  customer_name VARCHAR(100), -- for calling out and searching; required off-premise
+  customer_phone VARCHAR(30), -- required for DELIVERY
+  delivery_address TEXT, -- DELIVERY only
+  promised_at TIMESTAMP, -- pickup/delivery time promised to the customer
+  picked_up_at TIMESTAMP, -- handed to the customer or driver; leaves the pickup queue
+  notes VARCHAR(500), -- whole-order kitchen instructions, e.g. "allergy: peanuts"
   
   -- Cached totals (for fast reporting)
-  -- Updated whenever items change or discounts applied
+  -- Updated whenever items change or discounts applied (see recalc_order_totals)
   subtotal NUMERIC(10, 2) NOT NULL DEFAULT 0,
   tax NUMERIC(10, 2) NOT NULL DEFAULT 0,
   discount_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  service_charge NUMERIC(10, 2) NOT NULL DEFAULT 0,
   total_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
   
   -- Metadata
+  version INT NOT NULL DEFAULT 1, -- bumped on every update (trg_orders_version); served as the ETag
+  menu_version_id UUID REFERENCES menu_versions(id), -- menu the order was placed from; NULL = unpublished catalog
   created_by UUID REFERENCES users(id), -- NULL for guest/anonymous orders
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
   confirmed_at TIMESTAMP,
+  completed_at TIMESTAMP,
   paid_at TIMESTAMP,
+  cancelled_at TIMESTAMP,
   updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
   
-  CONSTRAINT valid_status CHECK (status IN ('OPEN', 'CONFIRMED', 'PAID', 'CANCELLED'))
+  CONSTRAINT valid_status CHECK (status IN ('OPEN', 'CONFIRMED', 'COMPLETED', 'PAID', 'CANCELLED')),
+  CONSTRAINT valid_order_type CHECK (order_type IN ('DINE_IN', 'TAKEAWAY', 'DELIVERY')),
+  CONSTRAINT delivery_has_address CHECK (order_type <> 'DELIVERY' OR delivery_address IS NOT NULL)
 );
 
 -- Critical indexes for reporting
@@ -133,6 +198,40 @@ CREATE INDEX idx_orders_table ON orders(table_id, status);
 CREATE INDEX idx_orders_qr_session ON orders(qr_session_id);
 CREATE INDEX idx_orders_created_at ON orders(created_at DESC);
 CREATE INDEX idx_orders_paid_at ON orders(paid_at DESC);
+-- Fast lookup: order list pages, newest first with (created_at, id) cursors
+CREATE INDEX idx_orders_branch_created ON orders(branch_id, created_at DESC, id DESC);
+CREATE UNIQUE INDEX uq_orders_branch_code ON orders(branch_id, order_code) WHERE order_code IS NOT NULL;
+-- Pickup queue: off-premise orders not yet handed over
+CREATE INDEX idx_orders_pickup_queue ON orders(branch_id, created_at)
+  WHERE order_type <> 'DINE_IN' AND picked_up_at IS NULL AND status <> 'CANCELLED';
+
+-- ORDER_NUMBER_COUNTERS (Last issued order number per branch)
+-- One row per branch, or per branch and day with daily reset; the row lock
+-- taken by generate_order_number serializes concurrent orders
+CREATE TABLE order_number_counters (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  period_key VARCHAR(8) NOT NULL, -- YYYYMMDD with daily reset, 'ALL' otherwise
+  last_number INT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  
+  PRIMARY KEY (branch_id, period_key)
+);
+
+-- ORDER_STATUS_HISTORY (Every status transition, who and why)
+-- Written by order-service and payment-service together with the status change
+CREATE TABLE order_status_history (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  
+  from_status VARCHAR(20) NOT NULL,
+  to_status VARCHAR(20) NOT NULL,
+  reason TEXT,
+  
+  changed_by UUID REFERENCES users(id), -- NULL for guest/system changes
+  changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_status_history_order ON order_status_history(order_id, changed_at);
 
 -- 5. ORDER_ITEMS (Line items - append only, never update)
 -- Append-only: when customer changes order, just add new item
@@ -151,23 +250,63 @@ CREATE TABLE order_items (
   unit_price NUMERIC(10, 2) NOT NULL,
   item_total NUMERIC(10, 2) NOT NULL, -- qty * unit_price
   
+  -- Selected product options (snapshot of option id/group/name/price_modifier)
+  modifiers JSONB,
+  notes VARCHAR(200), -- free-text kitchen instruction, e.g. "no cilantro"
+  
+  -- Courses: held items stay off the kitchen display until their course is fired
+  course SMALLINT NOT NULL DEFAULT 1 CHECK (course BETWEEN 1 AND 9), -- 1 = starters, 2 = mains, ...
+  held BOOLEAN NOT NULL DEFAULT false,
+  fired_at TIMESTAMP, -- when a held item was released to the kitchen
+  
   -- Status for tracking
-  item_status VARCHAR(20) NOT NULL DEFAULT 'PENDING', 
-    -- PENDING, COOKING, READY, SERVED, REMOVED, CANCELLED
+  item_status VARCHAR(20) NOT NULL DEFAULT 'PENDING'
+    CHECK (item_status IN ('PENDING', 'PREPARING', 'READY', 'SERVED', 'CANCELLED')),
+    -- kitchen flow only moves forward: PENDING -> PREPARING -> READY -> SERVED
   
   -- Metadata
+  menu_version_id UUID REFERENCES menu_versions(id), -- menu the item was priced against; NULL = unpublished catalog
   added_by UUID REFERENCES users(id), -- NULL for guest orders
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  ready_at TIMESTAMP, -- when kitchen marked ready
-  served_at TIMESTAMP -- when item served to customer
+  prepared_at TIMESTAMP, -- when kitchen marked ready
+  served_at TIMESTAMP, -- when item served to customer
+  
+  -- Voids and comps cancel the item but keep the row for the voids report
+  cancelled_at TIMESTAMP,
+  void_type VARCHAR(10), -- VOID (mistake/return), COMP (given away)
+  void_reason_code VARCHAR(30),
+  void_note TEXT,
+  voided_by UUID REFERENCES users(id),
+  void_approved_by UUID REFERENCES users(id), -- manager who approved, when approval was needed
+  
+  CONSTRAINT valid_void_type CHECK (void_type IS NULL OR void_type IN ('VOID', 'COMP'))
 );
 
 -- Fast lookup: what items are in this order
 CREATE INDEX idx_order_items_order ON order_items(order_id);
 -- Fast lookup: kitchen view - what items need cooking
-CREATE INDEX idx_order_items_status ON order_items(item_status) WHERE item_status IN ('PENDING', 'COOKING');
+CREATE INDEX idx_order_items_status ON order_items(item_status) WHERE item_status IN ('PENDING', 'PREPARING', 'READY');
+-- Fast lookup: held courses of an order
+CREATE INDEX idx_order_items_held ON order_items(order_id, course) WHERE held;
 -- Fast lookup: recent items
 CREATE INDEX idx_order_items_created ON order_items(created_at DESC);
+-- Fast lookup: voids report
+CREATE INDEX idx_order_items_voided ON order_items(cancelled_at DESC) WHERE void_type IS NOT NULL;
+
+-- Wrong manager approval PINs entered for voids and comps. Recent failures
+-- lock out the user who entered them, and the branch when they pile up
+CREATE TABLE approval_pin_failures (
+  id BIGSERIAL PRIMARY KEY,
+  organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+  branch_id UUID REFERENCES branches(id) ON DELETE CASCADE,
+  user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- who entered the PIN
+  order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+  item_id UUID,
+  attempted_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_approval_pin_failures_user ON approval_pin_failures(user_id, attempted_at DESC);
+CREATE INDEX idx_approval_pin_failures_branch ON approval_pin_failures(branch_id, attempted_at DESC);
 
 -- 6. PROMOTIONS (Promotion rules)
 -- Rules are evaluated at checkout time, not at ordering time
@@ -237,6 +376,121 @@ CREATE TABLE order_discounts (
 
 CREATE INDEX idx_order_discounts_order ON order_discounts(order_id);
 
+-- BILL_SPLITS (One order or a whole QR session bill divided into payable shares)
+-- Modes: BY_ITEMS (shares own line items), EQUAL (N even shares), CUSTOM (fixed amounts)
+-- Orders are marked PAID only when every share of the split is paid
+CREATE TABLE bill_splits (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID REFERENCES orders(id) ON DELETE CASCADE, -- set when splitting one order
+  qr_session_id UUID REFERENCES qr_sessions(id) ON DELETE CASCADE, -- set when splitting a session bill
+  
+  mode VARCHAR(20) NOT NULL, -- BY_ITEMS, EQUAL, CUSTOM
+  status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE', -- ACTIVE, SETTLED, CANCELLED
+  total_amount NUMERIC(10, 2) NOT NULL, -- amount due when the split was made
+  
+  created_by UUID REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  settled_at TIMESTAMP,
+  cancelled_at TIMESTAMP,
+  
+  CONSTRAINT valid_split_target CHECK ((order_id IS NULL) <> (qr_session_id IS NULL)),
+  CONSTRAINT valid_split_mode CHECK (mode IN ('BY_ITEMS', 'EQUAL', 'CUSTOM')),
+  CONSTRAINT valid_split_status CHECK (status IN ('ACTIVE', 'SETTLED', 'CANCELLED'))
+);
+
+-- Orders covered by a split (one for an order split, every unpaid order for a session split)
+CREATE TABLE bill_split_orders (
+  split_id UUID NOT NULL REFERENCES bill_splits(id) ON DELETE CASCADE,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  PRIMARY KEY (split_id, order_id)
+);
+
+CREATE INDEX idx_bill_split_orders_order ON bill_split_orders(order_id);
+
+CREATE TABLE bill_split_shares (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  split_id UUID NOT NULL REFERENCES bill_splits(id) ON DELETE CASCADE,
+  
+  position INT NOT NULL, -- 1-based, display order
+  label VARCHAR(100) NOT NULL, -- e.g. "Guest 1", "Seat 3"
+  amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+  
+  status VARCHAR(20) NOT NULL DEFAULT 'UNPAID', -- UNPAID, PAID
+  payment_method VARCHAR(50),
+  idempotency_key VARCHAR(255),
+  paid_at TIMESTAMP,
+  
+  UNIQUE(split_id, position),
+  CONSTRAINT valid_share_status CHECK (status IN ('UNPAID', 'PAID'))
+);
+
+CREATE INDEX idx_bill_split_shares_split ON bill_split_shares(split_id);
+
+-- Line items owned by a BY_ITEMS share
+CREATE TABLE bill_split_share_items (
+  share_id UUID NOT NULL REFERENCES bill_split_shares(id) ON DELETE CASCADE,
+  order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+  PRIMARY KEY (share_id, order_item_id)
+);
+
+-- ORDER_ADJUSTMENTS (Manager corrections to a PAID order)
+-- The paid bill is never edited: forgotten items and refunded items are recorded
+-- here instead, and the difference is settled in payment-service
+-- CHARGE: customer owes total_amount (paid through /api/payments/adjustments/{id}/pay)
+-- REFUND: customer gets total_amount back (a refunds row is requested with it)
+-- EVEN: the changes cancel out; settled immediately
+CREATE TABLE order_adjustments (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  adjustment_number INT NOT NULL, -- 1, 2, ... per order
+  
+  adjustment_type VARCHAR(20) NOT NULL, -- CHARGE, REFUND, EVEN
+  status VARCHAR(20) NOT NULL DEFAULT 'PENDING', -- PENDING -> SETTLED, or CANCELLED
+  reason VARCHAR(500) NOT NULL,
+  
+  -- Amounts are never negative; adjustment_type gives the direction
+  subtotal NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  service_charge NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  tax NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  total_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+  
+  created_by UUID REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  settled_at TIMESTAMP,
+  cancelled_at TIMESTAMP,
+  
+  UNIQUE(order_id, adjustment_number),
+  CONSTRAINT valid_adjustment_type CHECK (adjustment_type IN ('CHARGE', 'REFUND', 'EVEN')),
+  CONSTRAINT valid_adjustment_status CHECK (status IN ('PENDING', 'SETTLED', 'CANCELLED')),
+  CONSTRAINT adjustment_amounts_positive CHECK (subtotal >= 0 AND total_amount >= 0)
+);
+
+CREATE INDEX idx_order_adjustments_order ON order_adjustments(order_id, adjustment_number);
+
+-- ADD lines are priced like new order items; REFUND lines point at the original
+-- line and carry the amount credited, i.e. their share after the bill's discount
+CREATE TABLE order_adjustment_items (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  adjustment_id UUID NOT NULL REFERENCES order_adjustments(id) ON DELETE CASCADE,
+  
+  kind VARCHAR(10) NOT NULL, -- ADD, REFUND
+  order_item_id UUID REFERENCES order_items(id), -- REFUND: the line on the paid bill
+  menu_item_id UUID NOT NULL,
+  menu_item_name VARCHAR(255) NOT NULL,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  unit_price NUMERIC(10, 2) NOT NULL,
+  item_total NUMERIC(10, 2) NOT NULL,
+  modifiers JSONB,
+  notes VARCHAR(200),
+  
+  CONSTRAINT valid_adjustment_item_kind CHECK (kind IN ('ADD', 'REFUND')),
+  CONSTRAINT refund_has_item CHECK (kind <> 'REFUND' OR order_item_id IS NOT NULL)
+);
+
+CREATE INDEX idx_order_adjustment_items_adjustment ON order_adjustment_items(adjustment_id);
+-- Fast lookup: quantity of a line already refunded
+CREATE INDEX idx_order_adjustment_items_refunded ON order_adjustment_items(order_item_id) WHERE kind = 'REFUND';
+
 -- 9. PAYMENTS (Payment records)
 -- One order can have multiple payments (split payments)
 -- Status: PENDING -> SUCCESS/FAILED
@@ -256,6 +510,11 @@ CREATE TABLE payments (
   -- Error info if failed
   failure_reason TEXT,
   
+  -- Set when the payment settles a split share; one share may span several orders
+  split_share_id UUID REFERENCES bill_split_shares(id),
+  -- Set when the payment settles a CHARGE adjustment of an already paid order
+  adjustment_id UUID REFERENCES order_adjustments(id),
+  
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
   completed_at TIMESTAMP, -- when payment actually succeeded/failed
   
@@ -269,6 +528,31 @@ CREATE INDEX idx_payments_successful ON payments(status) WHERE status = 'SUCCESS
 -- Fast lookup: pending payments (for reconciliation)
 CREATE INDEX idx_payments_pending ON payments(status) WHERE status = 'PENDING';
 
+-- REFUNDS (Money owed back to a customer after a REFUND adjustment)
+-- Requested together with the adjustment; a manager completes it once the money
+-- has gone back, which settles the adjustment
+-- Status: REQUESTED -> COMPLETED, or CANCELLED with the adjustment
+CREATE TABLE refunds (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  adjustment_id UUID NOT NULL UNIQUE REFERENCES order_adjustments(id) ON DELETE CASCADE,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  
+  amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+  status VARCHAR(20) NOT NULL DEFAULT 'REQUESTED', -- REQUESTED, COMPLETED, CANCELLED
+  refund_method VARCHAR(50), -- CASH, CARD, ...; set on completion
+  reference VARCHAR(255), -- e.g. card reversal ID
+  
+  requested_by UUID REFERENCES users(id),
+  processed_by UUID REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMP,
+  
+  CONSTRAINT valid_refund_status CHECK (status IN ('REQUESTED', 'COMPLETED', 'CANCELLED'))
+);
+
+-- Fast lookup: refunds waiting to be paid out
+CREATE INDEX idx_refunds_requested ON refunds(created_at) WHERE status = 'REQUESTED';
+
 -- 10. PAYMENT_METHODS (Stored payment methods)
 -- Optional: if supporting saved cards, etc
 CREATE TABLE payment_methods (
@@ -281,16 +565,43 @@ CREATE TABLE payment_methods (
   updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+-- CATEGORIES (Menu category tree, per organization)
+-- Products point at a category by ID, so a rename is one update
+-- Names are unique among siblings regardless of case ("Drinks" = "drinks")
+-- A hidden category hides its whole subtree from the public menu
+CREATE TABLE categories (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  parent_id UUID REFERENCES categories(id), -- NULL = top level
+
+  name VARCHAR(100) NOT NULL,
+  description TEXT,
+  image_url TEXT,
+  is_visible BOOLEAN NOT NULL DEFAULT true,
+
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT category_not_own_parent CHECK (parent_id <> id)
+);
+
+CREATE UNIQUE INDEX idx_categories_sibling_name ON categories(
+  organization_id, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'::UUID), LOWER(name)
+);
+CREATE INDEX idx_categories_parent ON categories(parent_id);
+
 -- 11. PRODUCTS (Menu items - managed by Manager, available to all branches)
 -- Products created by Manager are available to all branches with Cashiers
 CREATE TABLE products (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
   
+  sku VARCHAR(64), -- organization's own item code; catalog imports match on it
   name VARCHAR(255) NOT NULL,
   description TEXT,
   price NUMERIC(10, 2) NOT NULL,
-  category VARCHAR(100),
+  category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
   image_url TEXT,
   is_available BOOLEAN NOT NULL DEFAULT true, -- Simple boolean availability
   
@@ -301,7 +612,8 @@ CREATE TABLE products (
 
 CREATE INDEX idx_products_org ON products(organization_id);
 CREATE INDEX idx_products_available ON products(is_available) WHERE is_available = true;
-CREATE INDEX idx_products_category ON products(category);
+CREATE INDEX idx_products_category ON products(category_id);
+CREATE UNIQUE INDEX uq_products_sku ON products(organization_id, sku) WHERE sku IS NOT NULL;
 
 -- 12. PRODUCT_OPTIONS (Product options like Size, Spice Level, etc.)
 -- Supports multiple choice, required options, and price modifiers
@@ -321,6 +633,206 @@ CREATE TABLE product_options (
 CREATE INDEX idx_product_options_product ON product_options(product_id);
 CREATE INDEX idx_product_options_group ON product_options(product_id, option_group);
 
+-- 13. PRODUCT_OPTION_GROUPS (Selection rules per option group)
+-- Linked to product_options by (product_id, option_group = name)
+-- Groups without a row fall back to is_required on their options
+CREATE TABLE product_option_groups (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  
+  name VARCHAR(100) NOT NULL, -- matches product_options.option_group
+  selection_type VARCHAR(10) NOT NULL DEFAULT 'SINGLE', -- SINGLE, MULTIPLE
+  min_selections INT NOT NULL DEFAULT 0, -- 1+ makes the group required
+  max_selections INT, -- NULL = unlimited (MULTIPLE only)
+  
+  sort_order INTEGER DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  
+  UNIQUE(product_id, name),
+  CONSTRAINT valid_selection_type CHECK (selection_type IN ('SINGLE', 'MULTIPLE')),
+  CONSTRAINT valid_selection_range CHECK (
+    min_selections >= 0 AND (max_selections IS NULL OR max_selections >= GREATEST(min_selections, 1))
+  )
+);
+
+CREATE INDEX idx_product_option_groups_product ON product_option_groups(product_id);
+
+-- BRANCH_PRODUCT_OVERRIDES (Branch-specific price and availability of org-wide products)
+-- NULL = inherit the product's value; no row = the product as the manager set it up
+-- Availability set here wins over the product's own flag in that branch
+CREATE TABLE branch_product_overrides (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+
+  price NUMERIC(10, 2) CHECK (price > 0),
+  is_available BOOLEAN,
+
+  updated_by UUID REFERENCES users(id),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  PRIMARY KEY (branch_id, product_id)
+);
+
+CREATE INDEX idx_branch_product_overrides_product ON branch_product_overrides(product_id);
+
+-- Option price modifiers per branch
+-- Linked to product_options by (product_id, option_group, option_name) like option
+-- groups, so they survive a group's options being replaced
+CREATE TABLE branch_option_overrides (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  option_group VARCHAR(100) NOT NULL,
+  option_name VARCHAR(100) NOT NULL,
+  price_modifier NUMERIC(10, 2) NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  PRIMARY KEY (branch_id, product_id, option_group, option_name)
+);
+
+-- MENUS (Named menu schedules: breakfast, lunch, late night...)
+-- A product on one or more menus (directly or through its category or a parent
+-- category) can only be ordered while one of them is open; products on no menu
+-- are served all day
+CREATE TABLE menus (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+
+  name VARCHAR(100) NOT NULL,
+  is_active BOOLEAN NOT NULL DEFAULT true, -- false = never open, whatever the windows say
+
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT uq_menu_name UNIQUE (organization_id, name)
+);
+
+-- When a menu is open, in each branch's local time (branches.timezone)
+-- end_time before start_time runs past midnight (22:00-02:00 on Friday ends
+-- Saturday 02:00); start_time = end_time is the whole day
+CREATE TABLE menu_windows (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+
+  days SMALLINT[] NOT NULL DEFAULT '{0,1,2,3,4,5,6}', -- days the window starts on, 0 = Sunday
+  start_time TIME NOT NULL,
+  end_time TIME NOT NULL,
+
+  CONSTRAINT valid_window_days CHECK (days <@ '{0,1,2,3,4,5,6}'::SMALLINT[] AND cardinality(days) > 0)
+);
+
+CREATE INDEX idx_menu_windows_menu ON menu_windows(menu_id);
+
+CREATE TABLE menu_products (
+  menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  PRIMARY KEY (menu_id, product_id)
+);
+
+CREATE INDEX idx_menu_products_product ON menu_products(product_id);
+
+-- A category brings its subcategories onto the menu too
+CREATE TABLE menu_categories (
+  menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+  category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+  PRIMARY KEY (menu_id, category_id)
+);
+
+CREATE INDEX idx_menu_categories_category ON menu_categories(category_id);
+
+-- 14. KITCHEN_STATIONS (Grill, drinks bar, dessert... per branch)
+-- Items are routed by product category, or the nearest mapped parent category;
+-- unmapped categories go to the default station
+CREATE TABLE kitchen_stations (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  
+  code VARCHAR(50) NOT NULL, -- used by screens, e.g. ?station=grill
+  name VARCHAR(100) NOT NULL,
+  is_default BOOLEAN NOT NULL DEFAULT false, -- catches unmapped categories
+  
+  sort_order INTEGER DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  
+  UNIQUE(branch_id, code)
+);
+
+-- At most one default station per branch
+CREATE UNIQUE INDEX idx_kitchen_stations_default ON kitchen_stations(branch_id) WHERE is_default = true;
+
+-- 15. KITCHEN_STATION_CATEGORIES (Product category -> station, per branch)
+-- A category goes to exactly one station in a branch; subcategories follow it
+-- unless mapped themselves
+CREATE TABLE kitchen_station_categories (
+  branch_id UUID NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+  category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+  station_id UUID NOT NULL REFERENCES kitchen_stations(id) ON DELETE CASCADE,
+  
+  PRIMARY KEY (branch_id, category_id)
+);
+
+CREATE INDEX idx_kitchen_station_categories_station ON kitchen_station_categories(station_id);
+
+-- 16. TABLE_MOVES (Audit trail of table transfers and merges)
+-- TRANSFER: open orders and the active session move to another table
+-- MERGE: the source table's orders join the target table's session
+CREATE TABLE table_moves (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL,
+  branch_id UUID REFERENCES branches(id) ON DELETE SET NULL,
+  
+  kind VARCHAR(20) NOT NULL, -- TRANSFER, MERGE
+  from_table_id INT NOT NULL REFERENCES tables(id),
+  to_table_id INT NOT NULL REFERENCES tables(id),
+  from_qr_session_id UUID REFERENCES qr_sessions(id),
+  to_qr_session_id UUID REFERENCES qr_sessions(id),
+  order_ids UUID[] NOT NULL DEFAULT '{}', -- orders re-pointed by the move
+  token_reissued BOOLEAN NOT NULL DEFAULT false,
+  reason TEXT,
+  
+  moved_by UUID REFERENCES users(id),
+  moved_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  
+  CONSTRAINT valid_move_kind CHECK (kind IN ('TRANSFER', 'MERGE')),
+  CONSTRAINT distinct_move_tables CHECK (from_table_id <> to_table_id)
+);
+
+CREATE INDEX idx_table_moves_branch ON table_moves(branch_id, moved_at DESC);
+
+-- 17. ORDER_EVENTS (Append-only timeline of every change to an order)
+-- Written by order-, payment- and promotion-service in the same transaction
+-- as the change; id breaks ties between events of one transaction
+CREATE TABLE order_events (
+  id BIGSERIAL PRIMARY KEY,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+
+  source VARCHAR(20) NOT NULL, -- order, payment, promotion
+  event_type VARCHAR(40) NOT NULL, -- item_added, status_changed, payment_recorded, ...
+  actor_id UUID REFERENCES users(id), -- NULL for guest/system changes
+  before_value JSONB,
+  after_value JSONB,
+
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_events_order ON order_events(order_id, created_at, id);
+
+-- 18. NOTE_PRESETS (Quick notes offered when ordering, per organization)
+-- Picking one just fills in the note text; orders store the text, not the preset
+CREATE TABLE note_presets (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  label VARCHAR(200) NOT NULL, -- e.g. "No cilantro", "Less spicy"
+  sort_order INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT uq_note_preset_label UNIQUE (organization_id, label)
+);
+
+CREATE INDEX idx_note_presets_org ON note_presets(organization_id, sort_order);
+
 -- ============================================================================
 -- VIEWS FOR FAST REPORTING
 -- ============================================================================
@@ -343,25 +855,87 @@ LEFT JOIN orders o ON t.id = o.table_id AND o.status IN ('OPEN', 'CONFIRMED')
 LEFT JOIN order_items oi ON o.id = oi.order_id
 GROUP BY t.id, o.id;
 
+-- View: Category tree, walked from the top level
+-- path runs from the top-level category down to the category itself;
+-- visible_path is false when the category or any ancestor is hidden
+CREATE VIEW v_category_tree AS
+WITH RECURSIVE tree AS (
+  SELECT c.id, c.organization_id, 0 AS depth, c.is_visible AS visible_path, ARRAY[c.id] AS path
+  FROM categories c
+  WHERE c.parent_id IS NULL
+  UNION ALL
+  SELECT c.id, c.organization_id, tree.depth + 1, tree.visible_path AND c.is_visible, tree.path || c.id
+  FROM categories c
+  JOIN tree ON c.parent_id = tree.id
+)
+SELECT id AS category_id, organization_id, depth, visible_path, path FROM tree;
+
+-- View: Kitchen station of every order item
+-- Mapping of the product's category or its nearest mapped ancestor first, then
+-- the branch's default station; NULL if neither exists
+CREATE VIEW v_order_item_stations AS
+SELECT 
+  oi.id AS item_id,
+  oi.order_id,
+  ks.id AS station_id,
+  ks.code AS station_code,
+  ks.name AS station_name
+FROM order_items oi
+JOIN orders o ON o.id = oi.order_id
+LEFT JOIN products p ON p.id::TEXT = oi.menu_item_id
+LEFT JOIN LATERAL (
+  WITH RECURSIVE chain AS (
+    SELECT c.id, c.parent_id, 0 AS depth FROM categories c WHERE c.id = p.category_id
+    UNION ALL
+    SELECT c.id, c.parent_id, chain.depth + 1
+    FROM categories c JOIN chain ON c.id = chain.parent_id
+    WHERE chain.depth < 10
+  )
+  SELECT ksc.station_id
+  FROM chain
+  JOIN kitchen_station_categories ksc ON ksc.branch_id = o.branch_id AND ksc.category_id = chain.id
+  ORDER BY chain.depth
+  LIMIT 1
+) mapped ON true
+LEFT JOIN kitchen_stations ks ON ks.id = COALESCE(
+  mapped.station_id,
+  (SELECT d.id FROM kitchen_stations d WHERE d.branch_id = o.branch_id AND d.is_default)
+);
+
 -- View: Kitchen display system (what needs to be cooked)
--- Real-time view of what kitchen needs to see
-CREATE VIEW kitchen_display AS
+-- Real-time view of what kitchen needs to see, routed to stations
+CREATE VIEW v_kitchen_display AS
 SELECT 
   o.id AS order_id,
+  o.organization_id,
+  o.branch_id,
   o.order_number,
+  o.order_code,
   t.table_number,
   oi.id AS item_id,
   oi.menu_item_name,
   oi.quantity,
+  oi.course,
   oi.item_status,
+  oi.notes AS item_notes,
+  o.notes AS order_notes,
+  s.station_id,
+  s.station_code,
+  s.station_name,
   oi.created_at,
-  EXTRACT(EPOCH FROM (NOW() - oi.created_at))/60 AS minutes_waiting
+  COALESCE(oi.fired_at, oi.created_at) AS sent_at,
+  oi.prepared_at,
+  EXTRACT(EPOCH FROM (NOW() - COALESCE(oi.fired_at, oi.created_at)))/60 AS minutes_waiting
 FROM orders o
-JOIN tables t ON o.table_id = t.id
+LEFT JOIN tables t ON o.table_id = t.id
 JOIN order_items oi ON o.id = oi.order_id
-WHERE o.status IN ('OPEN', 'CONFIRMED')
-  AND oi.item_status IN ('PENDING', 'COOKING')
-ORDER BY oi.created_at ASC;
+JOIN v_order_item_stations s ON s.item_id = oi.id
+WHERE (o.status IN ('OPEN', 'CONFIRMED')
+       -- takeaway and delivery are usually paid before cooking
+       OR (o.status = 'PAID' AND o.order_type <> 'DINE_IN' AND o.picked_up_at IS NULL))
+  AND oi.item_status IN ('PENDING', 'PREPARING', 'READY')
+  AND NOT oi.held -- held courses appear once fired
+ORDER BY sent_at ASC;
 
 -- View: Real-time sales report
 -- Total sales, by status, by hour, etc
@@ -393,13 +967,239 @@ SELECT
   EXTRACT(EPOCH FROM (NOW() - o.created_at))/60 AS minutes_open,
   COUNT(DISTINCT oi.id) AS item_count,
   SUM(CASE WHEN oi.item_status = 'SERVED' THEN 1 ELSE 0 END) AS served_items,
-  SUM(CASE WHEN oi.item_status IN ('PENDING', 'COOKING', 'READY') THEN 1 ELSE 0 END) AS pending_items
+  SUM(CASE WHEN oi.item_status IN ('PENDING', 'PREPARING', 'READY') THEN 1 ELSE 0 END) AS pending_items
 FROM orders o
 LEFT JOIN tables t ON o.table_id = t.id
 LEFT JOIN order_items oi ON o.id = oi.order_id
 WHERE o.status IN ('OPEN', 'CONFIRMED')
 GROUP BY o.id, t.table_number;
 
+-- ============================================================================
+-- FUNCTIONS
+-- ============================================================================
+
+-- Service charge and VAT on a net amount under a branch's settings:
+--   service_charge = net * service_charge_rate
+--   tax            = VAT on (net + service_charge), extracted when prices include tax
+-- Used by recalc_order_totals and for order adjustments, so both bill alike.
+CREATE OR REPLACE FUNCTION branch_charges(p_branch_id UUID, p_net NUMERIC)
+RETURNS TABLE (new_service_charge NUMERIC, new_tax NUMERIC, new_total NUMERIC) AS $$
+DECLARE
+  v_tax_rate NUMERIC(5, 2) := 7.00;
+  v_service_rate NUMERIC(5, 2) := 0.00;
+  v_inclusive BOOLEAN := true;
+  v_service NUMERIC(10, 2);
+  v_tax NUMERIC(10, 2);
+  v_total NUMERIC(10, 2);
+BEGIN
+  SELECT COALESCE(b.tax_rate, 7.00), COALESCE(b.service_charge_rate, 0.00), COALESCE(b.prices_include_tax, true)
+  INTO v_tax_rate, v_service_rate, v_inclusive
+  FROM branches b
+  WHERE b.id = p_branch_id;
+
+  IF NOT FOUND THEN
+    v_tax_rate := 7.00;
+    v_service_rate := 0.00;
+    v_inclusive := true;
+  END IF;
+
+  v_service := ROUND(p_net * v_service_rate / 100, 2);
+
+  IF v_inclusive THEN
+    v_tax := ROUND((p_net + v_service) * v_tax_rate / (100 + v_tax_rate), 2);
+    v_total := p_net + v_service;
+  ELSE
+    v_tax := ROUND((p_net + v_service) * v_tax_rate / 100, 2);
+    v_total := p_net + v_service + v_tax;
+  END IF;
+
+  RETURN QUERY SELECT v_service::NUMERIC, v_tax::NUMERIC, v_total::NUMERIC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Recalculate cached order totals from live line items and branch settings.
+-- Single totals engine shared by order-service and payment-service:
+--   net = subtotal - discount (never below 0), then branch_charges
+CREATE OR REPLACE FUNCTION recalc_order_totals(p_order_id UUID)
+RETURNS TABLE (new_subtotal NUMERIC, new_service_charge NUMERIC, new_tax NUMERIC, new_total NUMERIC) AS $$
+DECLARE
+  v_subtotal NUMERIC(10, 2);
+  v_discount NUMERIC(10, 2);
+  v_branch_id UUID;
+  v_net NUMERIC(10, 2);
+  v_service NUMERIC(10, 2);
+  v_tax NUMERIC(10, 2);
+  v_total NUMERIC(10, 2);
+BEGIN
+  SELECT o.discount_amount, o.branch_id
+  INTO v_discount, v_branch_id
+  FROM orders o
+  WHERE o.id = p_order_id
+  FOR UPDATE OF o;
+
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+
+  SELECT COALESCE(SUM(oi.item_total), 0)
+  INTO v_subtotal
+  FROM order_items oi
+  WHERE oi.order_id = p_order_id
+    AND oi.item_status <> 'CANCELLED';
+
+  v_net := GREATEST(v_subtotal - v_discount, 0);
+
+  SELECT c.new_service_charge, c.new_tax, c.new_total
+  INTO v_service, v_tax, v_total
+  FROM branch_charges(v_branch_id, v_net) c;
+
+  UPDATE orders
+  SET subtotal = v_subtotal,
+      service_charge = v_service,
+      tax = v_tax,
+      total_amount = v_total,
+      updated_at = NOW()
+  WHERE id = p_order_id;
+
+  RETURN QUERY SELECT v_subtotal::NUMERIC, v_service::NUMERIC, v_tax::NUMERIC, v_total::NUMERIC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Issue the next order number of a branch and render it with the branch format.
+-- Must run inside the order's transaction: the counter row stays locked until
+-- commit, so concurrent orders get consecutive numbers instead of colliding.
+-- A new counter starts after the highest number already used (today's, with
+-- daily reset) so switching settings never reissues a number.
+CREATE OR REPLACE FUNCTION generate_order_number(p_branch_id UUID)
+RETURNS TABLE (seq INT, code TEXT) AS $$
+DECLARE
+  v_format TEXT;
+  v_branch_code TEXT;
+  v_daily BOOLEAN;
+  v_timezone TEXT;
+  v_today DATE;
+  v_day_start TIMESTAMP;
+  v_period VARCHAR(8);
+  v_seq INT;
+  v_width INT;
+  v_code TEXT;
+BEGIN
+  SELECT b.order_number_format, b.branch_code, b.order_number_daily_reset, b.timezone
+  INTO v_format, v_branch_code, v_daily, v_timezone
+  FROM branches b
+  WHERE b.id = p_branch_id;
+
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+
+  -- Days, and {date}, follow the branch's clock, not the database's.
+  v_today := (NOW() AT TIME ZONE v_timezone)::DATE;
+  -- created_at is a plain TIMESTAMP from NOW() in the session TimeZone, so the
+  -- start of the branch's day is compared in that zone too.
+  v_day_start := (v_today::TIMESTAMP AT TIME ZONE v_timezone) AT TIME ZONE current_setting('TimeZone');
+  v_period := CASE WHEN v_daily THEN TO_CHAR(v_today, 'YYYYMMDD') ELSE 'ALL' END;
+
+  UPDATE order_number_counters c
+  SET last_number = c.last_number + 1, updated_at = NOW()
+  WHERE c.branch_id = p_branch_id AND c.period_key = v_period
+  RETURNING c.last_number INTO v_seq;
+
+  IF NOT FOUND THEN
+    INSERT INTO order_number_counters AS c (branch_id, period_key, last_number)
+    SELECT p_branch_id, v_period, COALESCE(MAX(o.order_number), 0) + 1
+    FROM orders o
+    WHERE o.branch_id = p_branch_id
+      AND (NOT v_daily OR o.created_at >= v_day_start)
+    ON CONFLICT (branch_id, period_key)
+      DO UPDATE SET last_number = c.last_number + 1, updated_at = NOW()
+    RETURNING c.last_number INTO v_seq;
+  END IF;
+
+  v_code := replace(replace(v_format, '{branch}', v_branch_code), '{date}', TO_CHAR(v_today, 'YYYYMMDD'));
+  v_width := COALESCE(substring(v_code FROM '\{seq:([0-9])\}')::INT, 0);
+  v_code := regexp_replace(v_code, '\{seq(:[0-9])?\}', LPAD(v_seq::TEXT, GREATEST(v_width, length(v_seq::TEXT)), '0'));
+
+  RETURN QUERY SELECT v_seq, v_code;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Bump the order version on every update, whichever service makes it, so a
+-- client holding an older copy fails its If-Match check instead of
+-- overwriting a bill someone else changed.
+CREATE OR REPLACE FUNCTION bump_order_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.version := OLD.version + 1;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_orders_version
+BEFORE UPDATE ON orders
+FOR EACH ROW EXECUTE FUNCTION bump_order_version();
+
+-- Function: published menu versions never change; publish a new one instead
+CREATE OR REPLACE FUNCTION forbid_menu_version_update()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'menu version % of organization % is immutable', OLD.version, OLD.organization_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_menu_versions_immutable
+BEFORE UPDATE ON menu_versions
+FOR EACH ROW EXECUTE FUNCTION forbid_menu_version_update();
+
+-- Function: is a menu open at a branch-local time?
+-- Windows past midnight count for the day they start on
+CREATE OR REPLACE FUNCTION menu_open_at(p_menu_id UUID, p_local TIMESTAMP)
+RETURNS BOOLEAN AS $$
+  SELECT EXISTS (
+    SELECT 1
+    FROM menus m
+    JOIN menu_windows w ON w.menu_id = m.id
+    WHERE m.id = p_menu_id
+      AND m.is_active
+      AND CASE
+        WHEN w.start_time = w.end_time THEN
+          EXTRACT(DOW FROM p_local)::SMALLINT = ANY(w.days)
+        WHEN w.start_time < w.end_time THEN
+          EXTRACT(DOW FROM p_local)::SMALLINT = ANY(w.days)
+          AND p_local::TIME >= w.start_time AND p_local::TIME < w.end_time
+        ELSE
+          (EXTRACT(DOW FROM p_local)::SMALLINT = ANY(w.days) AND p_local::TIME >= w.start_time)
+          OR (EXTRACT(DOW FROM p_local - INTERVAL '1 day')::SMALLINT = ANY(w.days) AND p_local::TIME < w.end_time)
+      END
+  );
+$$ LANGUAGE sql STABLE;
+
+-- Function: can the product be ordered in the branch right now, as far as menu
+-- schedules go? True when it is on no menu at all; a NULL branch uses the
+-- default timezone
+CREATE OR REPLACE FUNCTION product_on_menu(p_product_id UUID, p_branch_id UUID)
+RETURNS BOOLEAN AS $$
+  WITH product_menus AS (
+    SELECT mp.menu_id FROM menu_products mp WHERE mp.product_id = p_product_id
+    UNION
+    SELECT mc.menu_id
+    FROM products p
+    JOIN v_category_tree t ON t.category_id = p.category_id
+    JOIN menu_categories mc ON mc.category_id = ANY(t.path)
+    WHERE p.id = p_product_id
+  ),
+  branch_now AS (
+    SELECT NOW() AT TIME ZONE COALESCE(
+      (SELECT timezone FROM branches WHERE id = p_branch_id), 'Asia/Bangkok'
+    ) AS local_time
+  )
+  SELECT NOT EXISTS (SELECT 1 FROM product_menus)
+      OR EXISTS (
+        SELECT 1 FROM product_menus pm, branch_now
+        WHERE menu_open_at(pm.menu_id, branch_now.local_time)
+      );
+$$ LANGUAGE sql STABLE;
+
 -- ============================================================================
 -- PERFORMANCE TUNING
 -- ============================================================================
@@ -425,7 +1225,7 @@ REAL-TIME PERFORMANCE CONSIDERATIONS:
    - Single row lookup, instant
    
 3. KITCHEN DISPLAY
-   Query: SELECT from kitchen_display view (filtered by status)
+   Query: SELECT from v_kitchen_display view (filtered by status)
    - Uses indexes on item_status
    - O(n) where n = items to cook (usually <100)
 
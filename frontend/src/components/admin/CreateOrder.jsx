@@ -4,6 +4,8 @@ import { orderAPI } from '../../services/api';
 
 export default function CreateOrder() {
   const [tableId, setTableId] = useState('');
+  const [orderType, setOrderType] = useState('DINE_IN');
+  const [customer, setCustomer] = useState({ customer_name: '', customer_phone: '', delivery_address: '', promised_at: '' });
   const [items, setItems] = useState([]);
   const [itemName, setItemName] = useState('');
   const [price, setPrice] = useState('');
@@ -24,13 +26,19 @@ export default function CreateOrder() {
 
   const handleCreate = async () => {
     try {
+      const offPremise = orderType !== 'DINE_IN';
       const { data } = await orderAPI.create({
-        table_id: tableId || null,
+        order_type: orderType,
+        table_id: offPremise ? null : tableId || null,
+        customer_name: customer.customer_name || undefined,
+        customer_phone: customer.customer_phone || undefined,
+        delivery_address: orderType === 'DELIVERY' ? customer.delivery_address : undefined,
+        promised_at: offPremise && customer.promised_at ? new Date(customer.promised_at).toISOString() : undefined,
         items: items,
       });
       navigate(`/admin/order/${data.order_id}`);
     } catch (err) {
-      alert('Failed to create order');
+      alert(`Failed to create order: ${err.response?.data?.error || err.message}`);
     }
   };
 
@@ -41,16 +49,58 @@ export default function CreateOrder() {
       <h2>Create New Order</h2>
       
       <div style={{ marginBottom: '20px' }}>
-        <label>Table ID (optional): </label>
-        <input
-          type="text"
-          value={tableId}
-          onChange={(e) => setTableId(e.target.value)}
-          placeholder="e.g., A1"
-          style={{ padding: '5px', marginLeft: '10px' }}
-        />
+        <label>Order type: </label>
+        <select value={orderType} onChange={(e) => setOrderType(e.target.value)} style={{ padding: '5px', marginLeft: '10px' }}>
+          <option value="DINE_IN">Dine in</option>
+          <option value="TAKEAWAY">Takeaway</option>
+          <option value="DELIVERY">Delivery</option>
+        </select>
       </div>
 
+      {orderType === 'DINE_IN' ? (
+        <div style={{ marginBottom: '20px' }}>
+          <label>Table ID: </label>
+          <input
+            type="text"
+            value={tableId}
+            onChange={(e) => setTableId(e.target.value)}
+            placeholder="e.g., 5"
+            style={{ padding: '5px', marginLeft: '10px' }}
+          />
+        </div>
+      ) : (
+        <div style={{ marginBottom: '20px', display: 'flex', gap: '10px', flexWrap: 'wrap' }}>
+          <input
+            placeholder="Customer name"
+            value={customer.customer_name}
+            onChange={(e) => setCustomer({ ...customer, customer_name: e.target.value })}
+            style={{ padding: '5px' }}
+          />
+          <input
+            placeholder={orderType === 'DELIVERY' ? 'Phone' : 'Phone (optional)'}
+            value={customer.customer_phone}
+            onChange={(e) => setCustomer({ ...customer, customer_phone: e.target.value })}
+            style={{ padding: '5px' }}
+          />
+          <label>
+            Ready by{' '}
+            <input
+              type="datetime-local"
+              value={customer.promised_at}
+              onChange={(e) => setCustomer({ ...customer, promised_at: e.target.value })}
+            />
+          </label>
+          {orderType === 'DELIVERY' && (
+            <textarea
+              placeholder="Delivery address"
+              value={customer.delivery_address}
+              onChange={(e) => setCustomer({ ...customer, delivery_address: e.target.value })}
+              style={{ padding: '5px', width: '100%' }}
+            />
+          )}
+        </div>
+      )}
+
       <h3>Add Items</h3>
       <div style={{ marginBottom: '15px', display: 'flex', gap: '10px' }}>
         <input
@@ -56,7 +56,7 @@ export default function OrderDetail() {
     <div>
       <button onClick={() => navigate('/admin')} style={{ marginBottom: '20px' }}>← Back</button>
       
-      <h2>Order #{order.order_number}</h2>
+      <h2>Order #{order.order_code || order.order_number}</h2>
       <div style={{ marginBottom: '20px' }}>
         <p><strong>Table:</strong> {order.table_id || 'N/A'}</p>
         <p><strong>Status:</strong> {order.status}</p>
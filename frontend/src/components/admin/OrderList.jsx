@@ -2,44 +2,83 @@ import { useState, useEffect } from 'react';
 import { Link } from 'react-router-dom';
 import { orderAPI } from '../../services/api';
 
+const emptyFilters = { status: '', order_type: '', from: '', to: '', min_total: '', max_total: '', q: '' };
+
 export default function OrderList() {
   const [orders, setOrders] = useState([]);
-  const [filter, setFilter] = useState('');
+  const [filters, setFilters] = useState(emptyFilters);
+  const [nextCursor, setNextCursor] = useState(null);
 
   useEffect(() => {
     fetchOrders();
-  }, [filter]);
+  }, [filters.status, filters.order_type, filters.from, filters.to]);
+
+  const queryParams = () => {
+    const params = {};
+    Object.entries(filters).forEach(([key, value]) => {
+      if (value !== '') params[key] = value;
+    });
+    return params;
+  };
 
-  const fetchOrders = async () => {
+  const fetchOrders = async (cursor) => {
     try {
-      const { data } = await orderAPI.list({ status: filter });
-      setOrders(data.orders || []);
+      const params = queryParams();
+      if (cursor) params.cursor = cursor;
+      const { data } = await orderAPI.list(params);
+      setOrders((prev) => (cursor ? [...prev, ...(data.orders || [])] : data.orders || []));
+      setNextCursor(data.next_cursor || null);
     } catch (err) {
       console.error('Failed to fetch orders', err);
     }
   };
 
+  const setFilter = (key) => (e) => setFilters({ ...filters, [key]: e.target.value });
+
   return (
     <div>
       <h2>Orders</h2>
-      <div style={{ marginBottom: '15px' }}>
-        <label>Filter by status: </label>
-        <select value={filter} onChange={(e) => setFilter(e.target.value)} style={{ padding: '5px' }}>
-          <option value="">All</option>
+      <form
+        onSubmit={(e) => {
+          e.preventDefault();
+          fetchOrders();
+        }}
+        style={{ marginBottom: '15px', display: 'flex', gap: '10px', flexWrap: 'wrap', alignItems: 'center' }}
+      >
+        <input
+          placeholder="Search order #, customer, item"
+          value={filters.q}
+          onChange={setFilter('q')}
+          style={{ padding: '5px', minWidth: '220px' }}
+        />
+        <select value={filters.status} onChange={setFilter('status')} style={{ padding: '5px' }}>
+          <option value="">All statuses</option>
           <option value="PENDING">PENDING</option>
           <option value="PREPARING">PREPARING</option>
           <option value="READY">READY</option>
           <option value="COMPLETED">COMPLETED</option>
           <option value="CANCELLED">CANCELLED</option>
         </select>
-        <button onClick={fetchOrders} style={{ marginLeft: '10px' }}>Refresh</button>
-      </div>
+        <select value={filters.order_type} onChange={setFilter('order_type')} style={{ padding: '5px' }}>
+          <option value="">All types</option>
+          <option value="DINE_IN">Dine in</option>
+          <option value="TAKEAWAY">Takeaway</option>
+          <option value="DELIVERY">Delivery</option>
+        </select>
+        <label>From <input type="date" value={filters.from} onChange={setFilter('from')} /></label>
+        <label>To <input type="date" value={filters.to} onChange={setFilter('to')} /></label>
+        <input type="number" placeholder="Min ฿" value={filters.min_total} onChange={setFilter('min_total')} style={{ width: '80px' }} />
+        <input type="number" placeholder="Max ฿" value={filters.max_total} onChange={setFilter('max_total')} style={{ width: '80px' }} />
+        <button type="submit">Search</button>
+        <button type="button" onClick={() => setFilters(emptyFilters)}>Clear</button>
+      </form>
 
       <table style={{ width: '100%', borderCollapse: 'collapse' }}>
         <thead>
           <tr style={{ borderBottom: '2px solid #333' }}>
             <th style={{ padding: '10px', textAlign: 'left' }}>Order #</th>
             <th style={{ padding: '10px', textAlign: 'left' }}>Table</th>
+            <th style={{ padding: '10px', textAlign: 'left' }}>Customer</th>
             <th style={{ padding: '10px', textAlign: 'left' }}>Status</th>
             <th style={{ padding: '10px', textAlign: 'right' }}>Total</th>
             <th style={{ padding: '10px', textAlign: 'left' }}>Created</th>
@@ -49,8 +88,9 @@ export default function OrderList() {
         <tbody>
           {orders.map((order) => (
             <tr key={order.id} style={{ borderBottom: '1px solid #ddd' }}>
-              <td style={{ padding: '10px' }}>{order.order_number}</td>
-              <td style={{ padding: '10px' }}>{order.table_id || '-'}</td>
+              <td style={{ padding: '10px' }}>{order.order_code || order.order_number}</td>
+              <td style={{ padding: '10px' }}>{order.table_id || ({ TAKEAWAY: 'Takeaway', DELIVERY: 'Delivery' }[order.order_type] ?? '-')}</td>
+              <td style={{ padding: '10px' }}>{order.customer_name || '-'}</td>
               <td style={{ padding: '10px' }}>{order.status}</td>
               <td style={{ padding: '10px', textAlign: 'right' }}>฿{order.total_amount?.toFixed(2)}</td>
               <td style={{ padding: '10px' }}>{new Date(order.created_at).toLocaleString()}</td>
@@ -62,6 +102,9 @@ export default function OrderList() {
         </tbody>
       </table>
       {orders.length === 0 && <p>No orders found</p>}
+      {nextCursor && (
+        <button onClick={() => fetchOrders(nextCursor)} style={{ marginTop: '15px' }}>Load more</button>
+      )}
     </div>
   );
 }
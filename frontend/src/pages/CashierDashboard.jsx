@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
-import { orderAPI, sessionAPI } from '../services/api';
+import { orderAPI, paymentAPI, sessionAPI, tableAPI } from '../services/api';
 
 const styles = {
   page: { minHeight: '100vh', background: '#f1f5f9', display: 'flex', flexDirection: 'column' },
@@ -100,6 +100,7 @@ export default function CashierDashboard() {
   const [orders, setOrders] = useState([]);
   const [tableNumber, setTableNumber] = useState('');
   const [loading, setLoading] = useState(false);
+  const [qrImageUrl, setQrImageUrl] = useState('');
 
   const userName = localStorage.getItem('name') || 'Cashier';
   const branchName = localStorage.getItem('branch_name') || 'Branch';
@@ -111,6 +112,32 @@ export default function CashierDashboard() {
     return () => clearInterval(interval);
   }, []);
 
+  useEffect(() => {
+    if (!activeSession) return undefined;
+    let url = '';
+    sessionAPI.qrImage(activeSession.id, { size: 200 })
+      .then(({ data }) => {
+        url = URL.createObjectURL(data);
+        setQrImageUrl(url);
+      })
+      .catch(err => console.error('Failed to load QR code:', err));
+    return () => {
+      if (url) URL.revokeObjectURL(url);
+      setQrImageUrl('');
+    };
+  }, [activeSession?.id]);
+
+  const printTableTent = async (sessionId) => {
+    try {
+      const { data } = await sessionAPI.tableTent(sessionId, { size: 320, level: 'Q' });
+      const win = window.open('', '_blank');
+      win.document.write(data);
+      win.document.close();
+    } catch (err) {
+      alert('Failed to load table tent');
+    }
+  };
+
   const loadSessions = async () => {
     try {
       const { data } = await sessionAPI.list({ status: 'OPEN' });
@@ -161,10 +188,39 @@ export default function CashierDashboard() {
         setActiveSession(sessions.find(s => s.id !== sessionId) || null);
       }
     } catch (err) {
+      if (err.response?.data?.error === 'balance_outstanding') {
+        alert(`Cannot close: ฿${err.response.data.balance_due.toFixed(2)} still due`);
+        return;
+      }
       alert('Failed to close session');
     }
   };
 
+  const moveTable = async (session, kind) => {
+    const target = parseInt(window.prompt(kind === 'merge'
+      ? `Merge table ${session.table_number} into table number:`
+      : `Move table ${session.table_number} to table number:`));
+    if (!target || target <= 0) return;
+    const payload = { from_table_number: session.table_number, to_table_number: target };
+    try {
+      const { data } = kind === 'merge' ? await tableAPI.merge(payload) : await tableAPI.transfer(payload);
+      await loadSessions();
+      loadOrders();
+      setActiveSession(prev => (prev ? { ...prev, id: data.qr_session_id || prev.id, table_number: target, token: data.token || prev.token, menu_url: data.menu_url } : prev));
+    } catch (err) {
+      const code = err.response?.data?.error;
+      if (code === 'table_occupied') {
+        alert(`Table ${target} is occupied - use Merge instead`);
+        return;
+      }
+      if (code === 'split_in_progress') {
+        alert('This bill is being split - finish or cancel the split first');
+        return;
+      }
+      alert(kind === 'merge' ? 'Failed to merge tables' : 'Failed to move table');
+    }
+  };
+
   const updateOrderStatus = async (orderId, status) => {
     try {
       await orderAPI.updateStatus(orderId, status);
@@ -174,6 +230,20 @@ export default function CashierDashboard() {
     }
   };
 
+  // PAID is only reached by recording a payment.
+  const markPaid = async (orderId) => {
+    try {
+      await paymentAPI.checkout({ order_id: orderId, payment_method: 'CASH' });
+      loadOrders();
+    } catch (err) {
+      if (err.response?.data?.error === 'split_in_progress') {
+        alert('This bill is being split - collect the remaining shares');
+        return;
+      }
+      alert('Failed to record payment');
+    }
+  };
+
   const handleLogout = () => {
     localStorage.clear();
     navigate('/admin/login');
@@ -189,6 +259,7 @@ export default function CashierDashboard() {
 
   const getSessionLink = (session) => {
     if (!session) return '';
+    if (session.menu_url) return session.menu_url;
     const base = window.location.origin;
     const params = new URLSearchParams({ session: session.token, table: session.table_number });
     return `${base}/user?${params.toString()}`;
@@ -298,7 +369,7 @@ export default function CashierDashboard() {
                         </button>
                       )}
                       {(order.status === 'CONFIRMED' || order.status === 'READY') && (
-                        <button style={styles.btnPaid} onClick={() => updateOrderStatus(order.id, 'PAID')}>
+                        <button style={styles.btnPaid} onClick={() => markPaid(order.id)}>
                           💵 Mark Paid
                         </button>
                       )}
@@ -342,6 +413,9 @@ export default function CashierDashboard() {
               <>
                 <div style={styles.qrBox}>
                   <div style={styles.qrLabel}>Customer QR Link for Table {activeSession.table_number}</div>
+                  {qrImageUrl && (
+                    <img src={qrImageUrl} alt={`QR for table ${activeSession.table_number}`} style={{ width: '200px', height: '200px', display: 'block', margin: '0 auto 8px' }} />
+                  )}
                   <div style={styles.qrLink}>{getSessionLink(activeSession)}</div>
                   <button
                     style={{ ...styles.btnSecondary, marginTop: '12px' }}
@@ -349,8 +423,21 @@ export default function CashierDashboard() {
                   >
                     📋 Copy Link
                   </button>
+                  <button
+                    style={{ ...styles.btnSecondary, marginTop: '8px' }}
+                    onClick={() => printTableTent(activeSession.id)}
+                  >
+                    🖨️ Print Table Tent
+                  </button>
                 </div>
 
+                <button style={{ ...styles.btnSecondary, marginTop: '16px' }} onClick={() => moveTable(activeSession, 'transfer')}>
+                  ↔️ Move Table
+                </button>
+                <button style={styles.btnSecondary} onClick={() => moveTable(activeSession, 'merge')}>
+                  🔗 Merge Into Table
+                </button>
+
                 <button
                   style={{ ...styles.btnSecondary, background: '#fee2e2', color: '#dc2626', marginTop: '16px' }}
                   onClick={() => closeSession(activeSession.id)}
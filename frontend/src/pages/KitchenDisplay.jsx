@@ -1,7 +1,11 @@
 import { useEffect, useState } from 'react';
+import { useSearchParams } from 'react-router-dom';
+import { kitchenAPI, orderAPI } from '../services/api';
 import '../App.css';
 
 const KitchenDisplay = () => {
+  const [searchParams] = useSearchParams();
+  const station = searchParams.get('station') || '';
   const [orders, setOrders] = useState([]);
   const [ws, setWs] = useState(null);
   const [connected, setConnected] = useState(false);
@@ -12,7 +16,7 @@ const KitchenDisplay = () => {
     const role = localStorage.getItem('role');
 
     // Connect to WebSocket
-    const wsUrl = `ws://localhost:8080/ws?branch_id=${branchId}&organization_id=${orgId}&role=${role}`;
+    const wsUrl = `ws://localhost:8080/ws?branch_id=${branchId}&organization_id=${orgId}&role=${role}&station=${station}`;
     const websocket = new WebSocket(wsUrl);
 
     websocket.onopen = () => {
@@ -24,9 +28,9 @@ const KitchenDisplay = () => {
       const data = JSON.parse(event.data);
       console.log('WebSocket message:', data);
 
-      if (data.type === 'order_created') {
+      if (data.type === 'order_created' || data.type === 'course_fired') {
         fetchOrders();
-      } else if (data.type === 'order_status_updated') {
+      } else if (data.type === 'order_status_updated' || data.type === 'order_item_status_updated') {
         fetchOrders();
       }
     };
@@ -51,37 +55,57 @@ const KitchenDisplay = () => {
         websocket.close();
       }
     };
-  }, []);
+  }, [station]);
 
   const fetchOrders = async () => {
     try {
-      const token = localStorage.getItem('token');
-      const response = await fetch('http://localhost:8080/api/orders', {
-        headers: {
-          'Authorization': `Bearer ${token}`,
-        },
-      });
-      const data = await response.json();
-      const orders = Array.isArray(data?.orders) ? data.orders : [];
-      setOrders(orders.filter(order => order.status === 'OPEN' || order.status === 'CONFIRMED'));
+      const response = await kitchenAPI.tickets(station);
+      const tickets = Array.isArray(response.data?.tickets) ? response.data.tickets : [];
+      setOrders(tickets.map(ticket => ({ ...ticket, id: ticket.order_id })));
     } catch (error) {
-      console.error('Failed to fetch orders:', error);
+      console.error('Failed to fetch tickets:', error);
+    }
+  };
+
+  const bumpItem = async (order, item) => {
+    const next = { PENDING: 'PREPARING', PREPARING: 'READY', READY: 'SERVED' }[item.item_status];
+    if (!next) return;
+    try {
+      await orderAPI.updateItemStatus(order.order_id, item.item_id, next);
+      fetchOrders();
+    } catch (error) {
+      console.error('Failed to update item:', error);
+    }
+  };
+
+  const bumpTicket = async (order) => {
+    try {
+      await orderAPI.bump(order.order_id);
+      fetchOrders();
+    } catch (error) {
+      console.error('Failed to bump ticket:', error);
     }
   };
 
   const getStatusColor = (status) => {
     switch (status) {
-      case 'OPEN': return '#fbbf24';
-      case 'CONFIRMED': return '#10b981';
-      case 'PAID': return '#3b82f6';
+      case 'PENDING': return '#fbbf24';
+      case 'PREPARING': return '#f97316';
+      case 'READY': return '#10b981';
       default: return '#6b7280';
     }
   };
 
+  // A ticket takes the colour of its least advanced item
+  const getTicketStatus = (order) => {
+    const flow = ['PENDING', 'PREPARING', 'READY'];
+    return flow.find(status => order.items?.some(item => item.item_status === status)) || 'READY';
+  };
+
   return (
     <div style={{ padding: '20px', backgroundColor: '#1f2937', minHeight: '100vh', color: 'white' }}>
       <div style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', marginBottom: '30px' }}>
-        <h1 style={{ fontSize: '32px', fontWeight: 'bold' }}>🍳 Kitchen Display</h1>
+        <h1 style={{ fontSize: '32px', fontWeight: 'bold' }}>🍳 Kitchen Display{station && ` · ${station}`}</h1>
         <div style={{ display: 'flex', gap: '10px', alignItems: 'center' }}>
           <div style={{
             width: '12px',
@@ -109,14 +133,14 @@ const KitchenDisplay = () => {
                 backgroundColor: '#374151',
                 borderRadius: '12px',
                 padding: '20px',
-                borderLeft: `6px solid ${getStatusColor(order.status)}`,
+                borderLeft: `6px solid ${getStatusColor(getTicketStatus(order))}`,
                 boxShadow: '0 4px 6px rgba(0, 0, 0, 0.3)',
               }}
             >
               <div style={{ display: 'flex', justifyContent: 'space-between', marginBottom: '15px' }}>
                 <div>
                   <h2 style={{ fontSize: '28px', fontWeight: 'bold', margin: 0 }}>
-                    #{order.order_number}
+                    #{order.order_code || order.order_number}
                   </h2>
                   <span style={{
                     display: 'inline-block',
@@ -125,19 +149,22 @@ const KitchenDisplay = () => {
                     borderRadius: '9999px',
                     fontSize: '12px',
                     fontWeight: 'bold',
-                    backgroundColor: getStatusColor(order.status),
+                    backgroundColor: getStatusColor(getTicketStatus(order)),
                     color: 'white'
                   }}>
-                    {order.status}
+                    {order.table_number ? `Table ${order.table_number}` : 'Takeaway'}
                   </span>
                 </div>
                 <div style={{ textAlign: 'right' }}>
                   <div style={{ fontSize: '14px', color: '#9ca3af' }}>
-                    {new Date(order.created_at).toLocaleTimeString()}
-                  </div>
-                  <div style={{ fontSize: '20px', fontWeight: 'bold', marginTop: '4px' }}>
-                    ฿{order.total_amount.toFixed(2)}
+                    {new Date(order.items[0].sent_at || order.items[0].created_at).toLocaleTimeString()}
                   </div>
+                  <button
+                    onClick={() => bumpTicket(order)}
+                    style={{ marginTop: '8px', padding: '6px 14px', borderRadius: '8px', border: 'none', backgroundColor: '#10b981', color: 'white', fontWeight: 'bold', cursor: 'pointer' }}
+                  >
+                    Bump
+                  </button>
                 </div>
               </div>
 
@@ -156,8 +183,14 @@ const KitchenDisplay = () => {
                     >
                       <span style={{ fontSize: '16px' }}>
                         {item.quantity}x {item.menu_item_name}
+                        {item.course > 1 && (
+                          <span style={{ marginLeft: '8px', fontSize: '12px', color: '#9ca3af' }}>C{item.course}</span>
+                        )}
                       </span>
-                      <span style={{ fontSize: '14px', color: '#9ca3af' }}>
+                      <span
+                        onClick={() => bumpItem(order, item)}
+                        style={{ fontSize: '14px', color: getStatusColor(item.item_status), cursor: 'pointer' }}
+                      >
                         {item.item_status}
                       </span>
                     </div>
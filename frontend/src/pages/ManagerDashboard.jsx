@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
-import api, { orderAPI, productAPI } from '../services/api';
+import api, { categoryAPI, orderAPI, productAPI } from '../services/api';
 
 const styles = {
   page: { minHeight: '100vh', background: '#f1f5f9' },
@@ -91,11 +91,12 @@ export default function ManagerDashboard() {
   const [cashiers, setCashiers] = useState([]);
   const [orders, setOrders] = useState([]);
   const [products, setProducts] = useState([]);
+  const [categories, setCategories] = useState([]);
   
   // Forms
   const [cashierForm, setCashierForm] = useState({ username: '', name: '', password: '', branch_id: '' });
   const [productForm, setProductForm] = useState({
-    name: '', description: '', price: '', category: '', image_url: '',
+    name: '', description: '', price: '', category_id: '', image_url: '',
     is_available: true, sort_order: 0, options: []
   });
   const [editingProduct, setEditingProduct] = useState(null);
@@ -110,6 +111,7 @@ export default function ManagerDashboard() {
     loadOrders();
     if (activeTab === 'catalog') {
       loadProducts();
+      loadCategories();
     }
   }, [activeTab]);
 
@@ -173,6 +175,15 @@ export default function ManagerDashboard() {
     }
   };
 
+  const loadCategories = async () => {
+    try {
+      const { data } = await categoryAPI.list({ flat: true });
+      setCategories(Array.isArray(data?.categories) ? data.categories : []);
+    } catch (err) {
+      console.error('Failed to load categories:', err);
+    }
+  };
+
   const handleCreateProduct = async (e) => {
     e.preventDefault();
     try {
@@ -180,7 +191,7 @@ export default function ManagerDashboard() {
         name: productForm.name,
         description: productForm.description,
         price: parseFloat(productForm.price),
-        category: productForm.category,
+        category_id: productForm.category_id,
         image_url: productForm.image_url,
         is_available: productForm.is_available,
         sort_order: parseInt(productForm.sort_order) || 0,
@@ -193,7 +204,7 @@ export default function ManagerDashboard() {
         }))
       });
       setProductForm({
-        name: '', description: '', price: '', category: '', image_url: '',
+        name: '', description: '', price: '', category_id: '', image_url: '',
         is_available: true, sort_order: 0, options: []
       });
       setShowProductForm(false);
@@ -213,7 +224,7 @@ export default function ManagerDashboard() {
       if (productForm.name) updates.name = productForm.name;
       if (productForm.description !== undefined) updates.description = productForm.description;
       if (productForm.price) updates.price = parseFloat(productForm.price);
-      if (productForm.category !== undefined) updates.category = productForm.category;
+      if (productForm.category_id !== undefined) updates.category_id = productForm.category_id;
       if (productForm.image_url !== undefined) updates.image_url = productForm.image_url;
       if (productForm.is_available !== undefined) updates.is_available = productForm.is_available;
       if (productForm.sort_order !== undefined) updates.sort_order = parseInt(productForm.sort_order) || 0;
@@ -221,7 +232,7 @@ export default function ManagerDashboard() {
       await productAPI.update(editingProduct.id, updates);
       setEditingProduct(null);
       setProductForm({
-        name: '', description: '', price: '', category: '', image_url: '',
+        name: '', description: '', price: '', category_id: '', image_url: '',
         is_available: true, sort_order: 0, options: []
       });
       setShowProductForm(false);
@@ -259,7 +270,7 @@ export default function ManagerDashboard() {
       name: product.name,
       description: product.description || '',
       price: product.price.toString(),
-      category: product.category || '',
+      category_id: product.category_id || '',
       image_url: product.image_url || '',
       is_available: product.is_available,
       sort_order: product.sort_order || 0,
@@ -518,7 +529,7 @@ export default function ManagerDashboard() {
                   onClick={() => {
                     setEditingProduct(null);
                     setProductForm({
-                      name: '', description: '', price: '', category: '', image_url: '',
+                      name: '', description: '', price: '', category_id: '', image_url: '',
                       is_available: true, sort_order: 0, options: []
                     });
                     setShowProductForm(true);
@@ -642,12 +653,18 @@ export default function ManagerDashboard() {
                       onChange={(e) => setProductForm({ ...productForm, price: e.target.value })}
                       style={styles.input}
                     />
-                    <input
-                      placeholder="Category"
-                      value={productForm.category}
-                      onChange={(e) => setProductForm({ ...productForm, category: e.target.value })}
+                    <select
+                      value={productForm.category_id}
+                      onChange={(e) => setProductForm({ ...productForm, category_id: e.target.value })}
                       style={styles.input}
-                    />
+                    >
+                      <option value="">No category</option>
+                      {categories.map(c => (
+                        <option key={c.id} value={c.id}>
+                          {'\u00a0\u00a0'.repeat(c.depth)}{c.name}{c.is_visible ? '' : ' (hidden)'}
+                        </option>
+                      ))}
+                    </select>
                   </div>
                   <input
                     type="url"
@@ -751,7 +768,7 @@ export default function ManagerDashboard() {
                         setShowProductForm(false);
                         setEditingProduct(null);
                         setProductForm({
-                          name: '', description: '', price: '', category: '', image_url: '',
+                          name: '', description: '', price: '', category_id: '', image_url: '',
                           is_available: true, sort_order: 0, options: []
                         });
                       }}
@@ -8,7 +8,8 @@ export default function UserMenu() {
   const [products, setProducts] = useState([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState('');
-  const [selectedOptions, setSelectedOptions] = useState({}); // { productId: { optionGroup: optionName } }
+  const [selectedOptions, setSelectedOptions] = useState({}); // { productId: { optionGroup: [optionId] } }
+  const [notePresets, setNotePresets] = useState([]);
   const navigate = useNavigate();
   const [searchParams] = useSearchParams();
 
@@ -28,15 +29,19 @@ export default function UserMenu() {
       setError('');
       
       let organizationId = null;
+      let branchId = null;
       
       // If we have session token, get organization_id from session
       if (sessionToken) {
         try {
           const { data: session } = await sessionAPI.getByToken(sessionToken);
           organizationId = session.organization_id;
+          branchId = session.branch_id;
         } catch (err) {
           console.error('Failed to load session:', err);
-          setError('ไม่พบ session กรุณาตรวจสอบ QR Code');
+          setError(err.response?.data?.error === 'qr_session_expired'
+            ? 'หมดเวลาสั่งอาหารแล้ว กรุณาติดต่อพนักงาน'
+            : 'ไม่พบ session กรุณาตรวจสอบ QR Code');
           setLoading(false);
           return;
         }
@@ -50,11 +55,19 @@ export default function UserMenu() {
         // So we need to make products API public or accept org_id param
         params.organization_id = organizationId;
       }
+      if (branchId) {
+        // This branch's prices and availability
+        params.branch_id = branchId;
+      }
       params.available_only = 'true';
 
       const { data } = await productAPI.list(params);
       const list = Array.isArray(data?.products) ? data.products : [];
       setProducts(list.filter(p => p.is_available));
+
+      orderAPI.notePresets(organizationId ? { organization_id: organizationId } : {})
+        .then(({ data: presets }) => setNotePresets(presets.presets || []))
+        .catch(() => setNotePresets([]));
     } catch (err) {
       console.error('Failed to load products:', err);
       setError('โหลดเมนูไม่สำเร็จ กรุณาลองใหม่อีกครั้ง');
@@ -63,44 +76,57 @@ export default function UserMenu() {
     }
   };
 
-  const handleOptionChange = (productId, optionGroup, optionName) => {
-    setSelectedOptions(prev => ({
-      ...prev,
-      [productId]: {
-        ...prev[productId],
-        [optionGroup]: optionName
+  // SINGLE groups swap the choice (tap again to clear an optional one);
+  // MULTIPLE groups toggle options up to max_selections
+  const handleOptionChange = (productId, group, opt) => {
+    setSelectedOptions(prev => {
+      const current = prev[productId]?.[group.name] || [];
+      let next;
+      if (current.includes(opt.id)) {
+        next = group.multiple || group.min === 0 ? current.filter(id => id !== opt.id) : current;
+      } else if (!group.multiple) {
+        next = [opt.id];
+      } else if (group.max !== null && current.length >= group.max) {
+        return prev;
+      } else {
+        next = [...current, opt.id];
       }
-    }));
+      return {
+        ...prev,
+        [productId]: {
+          ...prev[productId],
+          [group.name]: next
+        }
+      };
+    });
+  };
+
+  // Chosen options of a product, in menu order
+  const getSelectedOptions = (product) => {
+    const chosen = selectedOptions[product.id] || {};
+    return getOptionGroups(product).flatMap(group =>
+      group.options.filter(opt => (chosen[group.name] || []).includes(opt.id))
+    );
   };
 
   const getProductPrice = (product) => {
-    let price = product.price;
-    const options = selectedOptions[product.id] || {};
-    
     // Add price modifiers from selected options
-    if (product.options && Array.isArray(product.options)) {
-      product.options.forEach(opt => {
-        if (options[opt.option_group] === opt.option_name) {
-          price += opt.price_modifier || 0;
-        }
-      });
-    }
-    
-    return price;
+    return getSelectedOptions(product).reduce((price, opt) => price + (opt.price_modifier || 0), product.price);
   };
 
   const addToCart = (product) => {
     const finalPrice = getProductPrice(product);
-    const options = selectedOptions[product.id] || {};
-    
-    // Check required options
-    if (product.options && Array.isArray(product.options)) {
-      const requiredGroups = [...new Set(product.options.filter(o => o.is_required).map(o => o.option_group))];
-      const selectedGroups = Object.keys(options);
-      const missingRequired = requiredGroups.filter(g => !selectedGroups.includes(g));
-      
-      if (missingRequired.length > 0) {
-        alert(`กรุณาเลือก: ${missingRequired.join(', ')}`);
+    const chosen = selectedOptions[product.id] || {};
+
+    // Same min/max rules the order service enforces
+    for (const group of getOptionGroups(product)) {
+      const count = (chosen[group.name] || []).length;
+      if (count < group.min) {
+        alert(group.min > 1 ? `กรุณาเลือก ${group.name} อย่างน้อย ${group.min} อย่าง` : `กรุณาเลือก: ${group.name}`);
+        return;
+      }
+      if (group.max !== null && count > group.max) {
+        alert(`เลือก ${group.name} ได้ไม่เกิน ${group.max} อย่าง`);
         return;
       }
     }
@@ -109,19 +135,19 @@ export default function UserMenu() {
       item_name: product.name,
       price: finalPrice,
       quantity: 1,
+      notes: '',
       menu_item_id: product.id,
-      options: Object.entries(options).map(([group, name]) => {
-        const opt = product.options?.find(o => o.option_group === group && o.option_name === name);
-        return {
-          option_group: group,
-          option_name: name,
-          price_modifier: opt?.price_modifier || 0
-        };
-      })
+      options: getSelectedOptions(product).map(opt => ({
+        option_id: opt.id,
+        option_group: opt.option_group,
+        option_name: opt.option_name,
+        price_modifier: opt.price_modifier || 0
+      }))
     };
 
     const existingIndex = cart.findIndex(c => 
       c.menu_item_id === product.id && 
+      !c.notes &&
       JSON.stringify(c.options) === JSON.stringify(cartItem.options)
     );
 
@@ -146,6 +172,15 @@ export default function UserMenu() {
     }).filter(item => item.quantity > 0));
   };
 
+  const updateNotes = (index, notes) => {
+    setCart(cart.map((item, idx) => (idx === index ? { ...item, notes: notes.slice(0, 200) } : item)));
+  };
+
+  const addPresetNote = (index, label) => {
+    const current = cart[index].notes;
+    updateNotes(index, current ? `${current}, ${label}` : label);
+  };
+
   const handleOrder = async () => {
     if (cart.length === 0) {
       alert('กรุณาเลือกอาหาร');
@@ -154,19 +189,46 @@ export default function UserMenu() {
     try {
       const { data } = await orderAPI.create({
         table_id: tableId || null,
-        items: cart,
+        items: cart.map(item => ({
+          product_id: item.menu_item_id,
+          option_ids: item.options.map(opt => opt.option_id),
+          quantity: item.quantity,
+          notes: item.notes || undefined,
+        })),
         qr_session_token: sessionToken || undefined,
       });
       navigate(`/user/order/${data.order_id}`);
     } catch (err) {
+      const code = err.response?.data?.error;
+      if (code === 'qr_session_expired' || code === 'last_order_passed') {
+        alert('หมดเวลาสั่งอาหารแล้ว กรุณาติดต่อพนักงาน');
+        return;
+      }
+      if (code === 'invalid_option_selection' || code === 'option_not_found') {
+        alert('ตัวเลือกของเมนูมีการเปลี่ยนแปลง กรุณาเลือกใหม่');
+        return;
+      }
       alert('สั่งอาหารไม่สำเร็จ');
     }
   };
 
   const total = cart.reduce((sum, item) => sum + (item.price * item.quantity), 0);
 
-  // Group options by option_group
+  // Option groups with their selection rules; products without option_groups
+  // fall back to grouping options by option_group, one choice each
   const getOptionGroups = (product) => {
+    if (Array.isArray(product.option_groups) && product.option_groups.length > 0) {
+      return product.option_groups.map(g => {
+        const multiple = g.selection_type === 'MULTIPLE';
+        return {
+          name: g.name,
+          options: g.options || [],
+          multiple,
+          min: g.min_selections || 0,
+          max: multiple ? (g.max_selections ?? null) : 1
+        };
+      });
+    }
     if (!product.options || !Array.isArray(product.options)) return [];
     const groups = {};
     product.options.forEach(opt => {
@@ -174,7 +236,9 @@ export default function UserMenu() {
         groups[opt.option_group] = {
           name: opt.option_group,
           options: [],
-          isRequired: opt.is_required
+          multiple: false,
+          min: opt.is_required ? 1 : 0,
+          max: 1
         };
       }
       groups[opt.option_group].options.push(opt);
@@ -272,15 +336,20 @@ export default function UserMenu() {
                     {optionGroups.map((group) => (
                       <div key={group.name} style={{ marginBottom: '12px' }}>
                         <div style={{ fontWeight: '600', marginBottom: '6px', fontSize: '14px' }}>
-                          {group.name} {group.isRequired && <span style={{ color: '#dc2626' }}>*</span>}
+                          {group.name} {group.min > 0 && <span style={{ color: '#dc2626' }}>*</span>}
+                          {group.multiple && (
+                            <span style={{ marginLeft: '6px', fontWeight: '400', color: '#6b7280', fontSize: '12px' }}>
+                              {group.max !== null ? `เลือกได้ ${group.min > 0 ? `${group.min}-` : 'ไม่เกิน '}${group.max} อย่าง` : 'เลือกได้หลายอย่าง'}
+                            </span>
+                          )}
                         </div>
                         <div style={{ display: 'flex', flexWrap: 'wrap', gap: '8px' }}>
                           {group.options.map((opt) => {
-                            const isSelected = currentOptions[group.name] === opt.option_name;
+                            const isSelected = (currentOptions[group.name] || []).includes(opt.id);
                             return (
                               <button
                                 key={opt.id}
-                                onClick={() => handleOptionChange(product.id, group.name, opt.option_name)}
+                                onClick={() => handleOptionChange(product.id, group, opt)}
                                 style={{
                                   padding: '6px 12px',
                                   backgroundColor: isSelected ? '#10b981' : '#f3f4f6',
@@ -331,6 +400,26 @@ export default function UserMenu() {
                     ))}
                   </div>
                 )}
+                <input
+                  value={item.notes}
+                  onChange={(e) => updateNotes(idx, e.target.value)}
+                  placeholder="หมายเหตุถึงครัว เช่น ไม่ใส่ผักชี"
+                  maxLength={200}
+                  style={{ width: '100%', marginTop: '6px', padding: '4px', fontSize: '12px' }}
+                />
+                {notePresets.length > 0 && (
+                  <div style={{ display: 'flex', gap: '4px', flexWrap: 'wrap', marginTop: '4px' }}>
+                    {notePresets.map((preset) => (
+                      <button
+                        key={preset.id}
+                        onClick={() => addPresetNote(idx, preset.label)}
+                        style={{ padding: '2px 6px', fontSize: '11px', border: '1px solid #ddd', borderRadius: '10px', cursor: 'pointer' }}
+                      >
+                        {preset.label}
+                      </button>
+                    ))}
+                  </div>
+                )}
               </div>
               <div style={{ display: 'flex', alignItems: 'center', gap: '10px' }}>
                 <button 
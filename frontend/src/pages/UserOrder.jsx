@@ -41,7 +41,7 @@ export default function UserOrder() {
     <div style={{ maxWidth: '600px', margin: '0 auto', padding: '20px' }}>
       <button onClick={() => navigate('/user')} style={{ marginBottom: '20px' }}>← กลับไปเมนู</button>
       
-      <h1>รายการสั่งอาหาร #{order.order_number}</h1>
+      <h1>รายการสั่งอาหาร #{order.order_code || order.order_number}</h1>
       
       <div style={{ 
         padding: '20px', 
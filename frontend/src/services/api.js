@@ -29,22 +29,60 @@ export const authAPI = {
   login: (username, password) => api.post('/api/auth/login', { username, password }),
   validate: () => api.post('/api/auth/validate'),
   refresh: () => api.post('/api/auth/refresh'),
+  setApprovalPin: (pin) => api.put('/api/users/me/pin', { pin }),
 };
 
+// Pass the order's version to refuse the change (412) if the order moved on.
+const ifMatch = (version) => (version ? { headers: { 'If-Match': `"${version}"` } } : {});
+
 export const orderAPI = {
   list: (params) => api.get('/api/orders', { params }),
   get: (id) => api.get(`/api/orders/${id}`),
   create: (data) => api.post('/api/orders', data),
-  addItem: (id, data) => api.post(`/api/orders/${id}/items`, data),
-  removeItem: (orderId, itemId) => api.delete(`/api/orders/${orderId}/items/${itemId}`),
-  updateStatus: (id, status) => api.put(`/api/orders/${id}/status`, { status }),
+  addItem: (id, data, version) => api.post(`/api/orders/${id}/items`, data, ifMatch(version)),
+  removeItem: (orderId, itemId, version) => api.delete(`/api/orders/${orderId}/items/${itemId}`, ifMatch(version)),
+  voidItem: (orderId, itemId, data, version) => api.post(`/api/orders/${orderId}/items/${itemId}/void`, data, ifMatch(version)),
+  compItem: (orderId, itemId, data, version) => api.post(`/api/orders/${orderId}/items/${itemId}/comp`, data, ifMatch(version)),
+  voidsReport: (params) => api.get('/api/reports/voids', { params }),
+  updateStatus: (id, status, reason, version) => api.put(`/api/orders/${id}/status`, { status, reason }, ifMatch(version)),
+  statusHistory: (id) => api.get(`/api/orders/${id}/status-history`),
+  timeline: (id) => api.get(`/api/orders/${id}/timeline`),
+  adjustments: (id) => api.get(`/api/orders/${id}/adjustments`),
+  createAdjustment: (id, data) => api.post(`/api/orders/${id}/adjustments`, data),
+  cancelAdjustment: (id, adjustmentId) => api.post(`/api/orders/${id}/adjustments/${adjustmentId}/cancel`),
+  pickupQueue: (params) => api.get('/api/orders/pickup-queue', { params }),
+  collect: (id, version) => api.post(`/api/orders/${id}/collect`, null, ifMatch(version)),
+  ticket: (id, station) => api.get(`/api/orders/${id}/ticket`, { params: station ? { station } : {}, responseType: 'text' }),
+  notePresets: (params) => api.get('/api/orders/note-presets', { params }),
+  createNotePreset: (data) => api.post('/api/orders/note-presets', data),
+  deleteNotePreset: (id) => api.delete(`/api/orders/note-presets/${id}`),
+  updateItemStatus: (orderId, itemId, status) => api.put(`/api/orders/${orderId}/items/${itemId}/status`, { status }),
+  bump: (id, status) => api.post(`/api/orders/${id}/bump`, status ? { status } : {}),
+  fire: (id, course) => api.post(`/api/orders/${id}/fire`, course ? { course } : {}),
+};
+
+export const kitchenAPI = {
+  tickets: (station) => api.get('/api/kitchen/tickets', { params: station ? { station } : {} }),
+  listStations: () => api.get('/api/kitchen/stations'),
+  createStation: (data) => api.post('/api/kitchen/stations', data),
+  updateStation: (id, data) => api.put(`/api/kitchen/stations/${id}`, data),
+  deleteStation: (id) => api.delete(`/api/kitchen/stations/${id}`),
 };
 
 export const sessionAPI = {
   list: (params) => api.get('/api/qr-sessions', { params }),
   create: (tableNumber) => api.post('/api/qr-sessions', { table_number: tableNumber }),
   getByToken: (token) => api.get(`/api/qr-sessions/token/${token}`),
-  close: (id) => api.put(`/api/qr-sessions/${id}/close`),
+  close: (id, override) => api.put(`/api/qr-sessions/${id}/close`, override),
+  bill: (id) => api.get(`/api/qr-sessions/${id}/bill`),
+  qrImage: (id, params) => api.get(`/api/qr-sessions/${id}/qr.png`, { params, responseType: 'blob' }),
+  qrSvg: (id, params) => api.get(`/api/qr-sessions/${id}/qr.svg`, { params, responseType: 'text' }),
+  tableTent: (id, params) => api.get(`/api/qr-sessions/${id}/tent`, { params, responseType: 'text' }),
+};
+
+export const tableAPI = {
+  transfer: (data) => api.post('/api/tables/transfer', data),
+  merge: (data) => api.post('/api/tables/merge', data),
 };
 
 export const promotionAPI = {
@@ -53,8 +91,15 @@ export const promotionAPI = {
 };
 
 export const paymentAPI = {
-  checkout: (data) => api.post('/api/payments/checkout', data),
+  checkout: (data, version) => api.post('/api/payments/checkout', data, ifMatch(version)),
   get: (id) => api.get(`/api/payments/${id}`),
+  createSplit: (data) => api.post('/api/payments/splits', data),
+  getSplit: (id) => api.get(`/api/payments/splits/${id}`),
+  cancelSplit: (id) => api.delete(`/api/payments/splits/${id}`),
+  payShare: (splitId, shareId, data) => api.post(`/api/payments/splits/${splitId}/shares/${shareId}/pay`, data),
+  payAdjustment: (adjustmentId, data) => api.post(`/api/payments/adjustments/${adjustmentId}/pay`, data),
+  refunds: (params) => api.get('/api/payments/refunds', { params }),
+  completeRefund: (id, data) => api.post(`/api/payments/refunds/${id}/complete`, data),
 };
 
 export const productAPI = {
@@ -63,6 +108,43 @@ export const productAPI = {
   create: (data) => api.post('/api/products', data),
   update: (id, data) => api.put(`/api/products/${id}`, data),
   delete: (id) => api.delete(`/api/products/${id}`),
+  listOptionGroups: (id) => api.get(`/api/products/${id}/option-groups`),
+  createOptionGroup: (id, data) => api.post(`/api/products/${id}/option-groups`, data),
+  updateOptionGroup: (id, groupId, data) => api.put(`/api/products/${id}/option-groups/${groupId}`, data),
+  deleteOptionGroup: (id, groupId) => api.delete(`/api/products/${id}/option-groups/${groupId}`),
+  branchOverrides: (id) => api.get(`/api/products/${id}/branch-overrides`),
+  setBranchOverride: (id, branchId, data) => api.put(`/api/products/${id}/branch-overrides/${branchId}`, data),
+  clearBranchOverride: (id, branchId) => api.delete(`/api/products/${id}/branch-overrides/${branchId}`),
+  // format: 'json' | 'csv'; CSV bodies are sent as text
+  exportCatalog: (format = 'json') => api.get('/api/products/export', { params: { format }, responseType: format === 'csv' ? 'text' : 'json' }),
+  importCatalog: (data, { format = 'json', dryRun = false } = {}) =>
+    api.post('/api/products/import', data, {
+      params: { format, dry_run: dryRun },
+      headers: format === 'csv' ? { 'Content-Type': 'text/csv' } : undefined,
+    }),
+};
+
+export const categoryAPI = {
+  list: (params) => api.get('/api/categories', { params }),
+  get: (id) => api.get(`/api/categories/${id}`),
+  create: (data) => api.post('/api/categories', data),
+  update: (id, data) => api.put(`/api/categories/${id}`, data),
+  delete: (id) => api.delete(`/api/categories/${id}`),
+};
+
+export const menuAPI = {
+  list: (params) => api.get('/api/menus', { params }),
+  get: (id, params) => api.get(`/api/menus/${id}`, { params }),
+  create: (data) => api.post('/api/menus', data),
+  update: (id, data) => api.put(`/api/menus/${id}`, data),
+  delete: (id) => api.delete(`/api/menus/${id}`),
+};
+
+export const menuVersionAPI = {
+  list: () => api.get('/api/menu-versions'),
+  get: (version) => api.get(`/api/menu-versions/${version}`),
+  publish: (note) => api.post('/api/menu-versions', { note }),
+  rollback: (version, note) => api.post(`/api/menu-versions/${version}/rollback`, { note }),
 };
 
 export default api;
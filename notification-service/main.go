@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -22,12 +23,16 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+const channelPickup = "pickup"
+
 type Client struct {
 	conn       *websocket.Conn
 	send       chan []byte
 	branchID   string
 	orgID      string
 	role       string
+	station    string // kitchen screens only; empty receives every station
+	channel    string // "pickup" for customer-facing now-serving displays
 	disconnect chan bool
 }
 
@@ -44,6 +49,7 @@ type Event struct {
 	Data      map[string]interface{} `json:"data"`
 	BranchID  string                 `json:"branch_id,omitempty"`
 	OrgID     string                 `json:"organization_id,omitempty"`
+	Stations  []string               `json:"stations,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
 }
 
@@ -99,6 +105,16 @@ func (h *Hub) run() {
 }
 
 func shouldReceiveEvent(client *Client, event *Event) bool {
+	// Pickup displays face customers: only their branch's queue, nothing else
+	if client.channel == channelPickup {
+		return event.Type == "pickup_queue_updated" && client.branchID != "" && event.BranchID == client.branchID
+	}
+
+	// Station screens skip kitchen events routed to other stations
+	if client.station != "" && len(event.Stations) > 0 && !containsStation(event.Stations, client.station) {
+		return false
+	}
+
 	// ADMIN sees everything
 	if client.role == "ADMIN" {
 		return true
@@ -117,6 +133,15 @@ func shouldReceiveEvent(client *Client, event *Event) bool {
 	return false
 }
 
+func containsStation(stations []string, station string) bool {
+	for _, s := range stations {
+		if s == station {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		c.disconnect <- true
@@ -230,6 +255,8 @@ func serveWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	branchID := r.URL.Query().Get("branch_id")
 	orgID := r.URL.Query().Get("organization_id")
 	role := r.URL.Query().Get("role")
+	station := strings.ToLower(r.URL.Query().Get("station"))
+	channel := strings.ToLower(r.URL.Query().Get("channel"))
 
 	if role == "" {
 		role = "GUEST"
@@ -247,6 +274,8 @@ func serveWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		branchID:   branchID,
 		orgID:      orgID,
 		role:       role,
+		station:    station,
+		channel:    channel,
 		disconnect: make(chan bool),
 	}
 
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	adjustmentCharge = "CHARGE"
+	adjustmentRefund = "REFUND"
+	adjustmentEven   = "EVEN"
+
+	adjustmentPending   = "PENDING"
+	adjustmentSettled   = "SETTLED"
+	adjustmentCancelled = "CANCELLED"
+
+	adjustmentLineAdd    = "ADD"
+	adjustmentLineRefund = "REFUND"
+
+	maxAdjustmentReasonLength = 500
+)
+
+const (
+	eventAdjustmentCreated   = "adjustment_created"
+	eventAdjustmentCancelled = "adjustment_cancelled"
+)
+
+// OrderAdjustment is a correction to a PAID order. The paid bill itself is
+// never changed; the adjustment carries the difference, which payment-service
+// collects (CHARGE) or pays back (REFUND).
+type OrderAdjustment struct {
+	ID               string           `json:"id"`
+	OrderID          string           `json:"order_id"`
+	AdjustmentNumber int              `json:"adjustment_number"`
+	AdjustmentType   string           `json:"adjustment_type"`
+	Status           string           `json:"status"`
+	Reason           string           `json:"reason"`
+	Subtotal         float64          `json:"subtotal"`
+	ServiceCharge    float64          `json:"service_charge"`
+	Tax              float64          `json:"tax"`
+	TotalAmount      float64          `json:"total_amount"`
+	RefundID         *string          `json:"refund_id"`
+	RefundStatus     *string          `json:"refund_status"`
+	Items            []AdjustmentLine `json:"items"`
+	CreatedBy        *string          `json:"created_by"`
+	CreatedAt        time.Time        `json:"created_at"`
+	SettledAt        *time.Time       `json:"settled_at"`
+	CancelledAt      *time.Time       `json:"cancelled_at"`
+}
+
+type AdjustmentLine struct {
+	ID           string              `json:"id"`
+	Kind         string              `json:"kind"`
+	OrderItemID  *string             `json:"order_item_id"`
+	MenuItemID   string              `json:"menu_item_id"`
+	MenuItemName string              `json:"menu_item_name"`
+	Quantity     int                 `json:"quantity"`
+	UnitPrice    float64             `json:"unit_price"`
+	ItemTotal    float64             `json:"item_total"`
+	Modifiers    []OrderItemModifier `json:"modifiers,omitempty"`
+	Notes        *string             `json:"notes"`
+}
+
+type CreateAdjustmentRequest struct {
+	Reason      string              `json:"reason"`
+	AddItems    []CreateOrderItem   `json:"add_items"`
+	RefundItems []RefundItemRequest `json:"refund_items"`
+}
+
+type RefundItemRequest struct {
+	ItemID   string `json:"item_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// adjustmentLine is a priced line waiting to be inserted.
+type adjustmentLine struct {
+	kind        string
+	orderItemID string
+	line        *pricedLine
+	modifiers   []byte
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// loadOrderAdjustments returns the order's adjustments with their lines,
+// oldest first; a non-empty adjustmentID narrows it to that one.
+func loadOrderAdjustments(q queryer, orderID, adjustmentID string) ([]OrderAdjustment, error) {
+	query := `
+		SELECT a.id, a.order_id, a.adjustment_number, a.adjustment_type, a.status, a.reason,
+		       a.subtotal, a.service_charge, a.tax, a.total_amount, r.id, r.status,
+		       a.created_by, a.created_at, a.settled_at, a.cancelled_at
+		FROM order_adjustments a
+		LEFT JOIN refunds r ON r.adjustment_id = a.id
+		WHERE a.order_id = $1`
+	args := []any{orderID}
+	if adjustmentID != "" {
+		query += ` AND a.id = $2`
+		args = append(args, adjustmentID)
+	}
+	query += ` ORDER BY a.adjustment_number`
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	adjustments := []OrderAdjustment{}
+	index := map[string]int{}
+	for rows.Next() {
+		a := OrderAdjustment{Items: []AdjustmentLine{}}
+		if err := rows.Scan(&a.ID, &a.OrderID, &a.AdjustmentNumber, &a.AdjustmentType, &a.Status, &a.Reason,
+			&a.Subtotal, &a.ServiceCharge, &a.Tax, &a.TotalAmount, &a.RefundID, &a.RefundStatus,
+			&a.CreatedBy, &a.CreatedAt, &a.SettledAt, &a.CancelledAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[a.ID] = len(adjustments)
+		adjustments = append(adjustments, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(adjustments) == 0 {
+		return adjustments, nil
+	}
+
+	rows, err = q.Query(`
+		SELECT ai.id, ai.adjustment_id, ai.kind, ai.order_item_id, ai.menu_item_id, ai.menu_item_name,
+		       ai.quantity, ai.unit_price, ai.item_total, ai.modifiers, ai.notes
+		FROM order_adjustment_items ai
+		JOIN order_adjustments a ON a.id = ai.adjustment_id
+		WHERE a.order_id = $1
+		ORDER BY ai.kind, ai.menu_item_name
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l AdjustmentLine
+		var adjID string
+		var modifiers []byte
+		if err := rows.Scan(&l.ID, &adjID, &l.Kind, &l.OrderItemID, &l.MenuItemID, &l.MenuItemName,
+			&l.Quantity, &l.UnitPrice, &l.ItemTotal, &modifiers, &l.Notes); err != nil {
+			return nil, err
+		}
+		i, ok := index[adjID]
+		if !ok {
+			continue
+		}
+		if len(modifiers) > 0 {
+			_ = json.Unmarshal(modifiers, &l.Modifiers)
+		}
+		adjustments[i].Items = append(adjustments[i].Items, l)
+	}
+	return adjustments, rows.Err()
+}
+
+// refundLine prices a refund of quantity units of a line on the paid bill.
+// The credit is the line's share after the bill's discount, so refunding
+// every line never returns more than was paid.
+func refundLine(tx *sql.Tx, orderID string, index int, req RefundItemRequest, discountRatio float64, requested map[string]int) (*adjustmentLine, error) {
+	if req.Quantity <= 0 {
+		return nil, &orderLineError{Code: "invalid_quantity", ItemIndex: index}
+	}
+
+	var line pricedLine
+	var itemTotal float64
+	var modifiers []byte
+	var status string
+	err := tx.QueryRow(`
+		SELECT menu_item_id, menu_item_name, quantity, unit_price, item_total, modifiers, item_status
+		FROM order_items
+		WHERE id = $1 AND order_id = $2
+	`, req.ItemID, orderID).Scan(&line.ProductID, &line.ProductName, &line.Quantity, &line.UnitPrice, &itemTotal, &modifiers, &status)
+	if err == sql.ErrNoRows {
+		return nil, &orderLineError{Code: "item_not_found", ItemIndex: index}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == itemCancelled {
+		return nil, &orderLineError{Code: "item_not_refundable", ItemIndex: index}
+	}
+
+	var refunded int
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(ai.quantity), 0)
+		FROM order_adjustment_items ai
+		JOIN order_adjustments a ON a.id = ai.adjustment_id
+		WHERE ai.order_item_id = $1 AND ai.kind = 'REFUND' AND a.status <> 'CANCELLED'
+	`, req.ItemID).Scan(&refunded)
+	if err != nil {
+		return nil, err
+	}
+	refunded += requested[req.ItemID]
+	if refunded+req.Quantity > line.Quantity {
+		return nil, &orderLineError{Code: "refund_exceeds_quantity", ItemIndex: index}
+	}
+	requested[req.ItemID] += req.Quantity
+
+	share := itemTotal * float64(req.Quantity) / float64(line.Quantity)
+	line.ItemTotal = roundMoney(share * (1 - discountRatio))
+	line.Quantity = req.Quantity
+	return &adjustmentLine{kind: adjustmentLineRefund, orderItemID: req.ItemID, line: &line, modifiers: modifiers}, nil
+}
+
+// createOrderAdjustment corrects a PAID order: add_items were served but not
+// billed, refund_items were billed but should not have been. The net
+// difference goes through the branch's service charge and VAT and becomes a
+// CHARGE to collect or a REFUND request in payment-service.
+func createOrderAdjustment(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var req CreateAdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reason_required"})
+		return
+	}
+	if len(req.Reason) > maxAdjustmentReasonLength {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "reason_too_long", "max_length": maxAdjustmentReasonLength})
+		return
+	}
+	if len(req.AddItems) == 0 && len(req.RefundItems) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no_items"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the order serializes adjustments, so refunded quantities and
+	// adjustment numbers cannot race.
+	var status string
+	var orderOrgID, orderBranchID sql.NullString
+	var subtotal, discount float64
+	err = tx.QueryRow(`
+		SELECT status, organization_id, branch_id, subtotal, discount_amount
+		FROM orders WHERE id = $1
+		FOR UPDATE
+	`, orderID).Scan(&status, &orderOrgID, &orderBranchID, &subtotal, &discount)
+	if err != nil {
+		log.Printf("Failed to lock order: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if status != statusPaid {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "order_not_paid", "status": status})
+		return
+	}
+	discountRatio := 0.0
+	if subtotal > 0 {
+		discountRatio = math.Min(discount/subtotal, 1)
+	}
+
+	menu, err := loadPublishedMenu(tx, orderOrgID.String)
+	if err != nil {
+		log.Printf("Failed to load published menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if menu != nil {
+		productIDs := make([]string, len(req.AddItems))
+		for i, item := range req.AddItems {
+			productIDs[i] = item.ProductID
+		}
+		if err := menu.preloadAvailability(tx, productIDs, orderBranchID.String); err != nil {
+			log.Printf("Failed to load product availability: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	var lines []adjustmentLine
+	var added, credited float64
+	for i, item := range req.AddItems {
+		notes, ok := cleanNote(item.Notes, maxItemNoteLength)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "note_too_long", "item_index": i, "max_length": maxItemNoteLength})
+			return
+		}
+		line, err := priceOrderLine(tx, orderOrgID.String, orderBranchID.String, menu, i, item.ProductID, item.OptionIDs, item.Quantity)
+		if lineErr, ok := err.(*orderLineError); ok {
+			writeJSON(w, http.StatusBadRequest, lineErr)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to price item: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		line.Notes = notes
+		modifiers, err := line.modifiersJSON()
+		if err != nil {
+			log.Printf("Failed to encode modifiers: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		lines = append(lines, adjustmentLine{kind: adjustmentLineAdd, line: line, modifiers: modifiers})
+		added += line.ItemTotal
+	}
+
+	requested := map[string]int{}
+	for i, item := range req.RefundItems {
+		line, err := refundLine(tx, orderID, i, item, discountRatio, requested)
+		if lineErr, ok := err.(*orderLineError); ok {
+			code := http.StatusBadRequest
+			if lineErr.Code == "refund_exceeds_quantity" || lineErr.Code == "item_not_refundable" {
+				code = http.StatusConflict
+			}
+			writeJSON(w, code, map[string]any{"error": lineErr.Code, "refund_index": i, "item_id": item.ItemID})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to price refund: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		lines = append(lines, *line)
+		credited += line.line.ItemTotal
+	}
+
+	adj := OrderAdjustment{OrderID: orderID, Reason: req.Reason, Status: adjustmentPending}
+	net := roundMoney(added - credited)
+	switch {
+	case net > 0:
+		adj.AdjustmentType = adjustmentCharge
+	case net < 0:
+		adj.AdjustmentType = adjustmentRefund
+	default:
+		adj.AdjustmentType = adjustmentEven
+		adj.Status = adjustmentSettled
+	}
+	adj.Subtotal = math.Abs(net)
+	err = tx.QueryRow(`SELECT new_service_charge, new_tax, new_total FROM branch_charges($1, $2)`,
+		nullable(orderBranchID.String), adj.Subtotal).Scan(&adj.ServiceCharge, &adj.Tax, &adj.TotalAmount)
+	if err != nil {
+		log.Printf("Failed to compute adjustment charges: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO order_adjustments (order_id, adjustment_number, adjustment_type, status, reason,
+		                               subtotal, service_charge, tax, total_amount, created_by, created_at, settled_at)
+		SELECT $1, COALESCE(MAX(adjustment_number), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(),
+		       CASE WHEN $3 = 'SETTLED' THEN NOW() END
+		FROM order_adjustments WHERE order_id = $1
+		RETURNING id, adjustment_number
+	`, orderID, adj.AdjustmentType, adj.Status, adj.Reason, adj.Subtotal, adj.ServiceCharge, adj.Tax, adj.TotalAmount,
+		nullable(userID)).Scan(&adj.ID, &adj.AdjustmentNumber)
+	if err != nil {
+		log.Printf("Failed to create adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	for _, l := range lines {
+		_, err := tx.Exec(`
+			INSERT INTO order_adjustment_items (adjustment_id, kind, order_item_id, menu_item_id, menu_item_name,
+			                                    quantity, unit_price, item_total, modifiers, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, adj.ID, l.kind, nullable(l.orderItemID), l.line.ProductID, l.line.ProductName,
+			l.line.Quantity, l.line.UnitPrice, l.line.ItemTotal, l.modifiers, nullable(l.line.Notes))
+		if err != nil {
+			log.Printf("Failed to add adjustment line: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	if adj.AdjustmentType == adjustmentRefund {
+		_, err := tx.Exec(`
+			INSERT INTO refunds (adjustment_id, order_id, amount, status, requested_by, created_at)
+			VALUES ($1, $2, $3, 'REQUESTED', $4, NOW())
+		`, adj.ID, orderID, adj.TotalAmount, nullable(userID))
+		if err != nil {
+			log.Printf("Failed to request refund: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	err = recordOrderEvent(tx, orderID, eventAdjustmentCreated, userID, nil, map[string]any{
+		"adjustment_id":     adj.ID,
+		"adjustment_number": adj.AdjustmentNumber,
+		"adjustment_type":   adj.AdjustmentType,
+		"status":            adj.Status,
+		"reason":            adj.Reason,
+		"total_amount":      adj.TotalAmount,
+		"added":             len(req.AddItems),
+		"refunded":          len(req.RefundItems),
+	})
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	adjustments, err := loadOrderAdjustments(tx, orderID, adj.ID)
+	if err != nil || len(adjustments) == 0 {
+		log.Printf("Failed to load adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("order_adjusted", map[string]interface{}{
+		"order_id":        orderID,
+		"adjustment_id":   adj.ID,
+		"adjustment_type": adj.AdjustmentType,
+		"status":          adj.Status,
+		"total_amount":    adj.TotalAmount,
+		"created_by":      userID,
+	}, branchID, orgID)
+
+	writeJSON(w, http.StatusCreated, adjustments[0])
+}
+
+func listOrderAdjustments(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireStaff(w, r) {
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	adjustments, err := loadOrderAdjustments(db, orderID, "")
+	if err != nil {
+		log.Printf("Failed to get adjustments: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"order_id": orderID, "adjustments": adjustments})
+}
+
+// cancelOrderAdjustment withdraws an adjustment that has not been settled,
+// together with its refund request.
+func cancelOrderAdjustment(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	orderID, adjustmentID := vars["id"], vars["adjustmentId"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`
+		SELECT status FROM order_adjustments WHERE id = $1 AND order_id = $2 FOR UPDATE
+	`, adjustmentID, orderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "adjustment_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if status != adjustmentPending {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "adjustment_not_pending", "status": status})
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE order_adjustments SET status = 'CANCELLED', cancelled_at = NOW() WHERE id = $1
+	`, adjustmentID); err != nil {
+		log.Printf("Failed to cancel adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if _, err := tx.Exec(`
+		UPDATE refunds SET status = 'CANCELLED' WHERE adjustment_id = $1 AND status = 'REQUESTED'
+	`, adjustmentID); err != nil {
+		log.Printf("Failed to cancel refund: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	err = recordOrderEvent(tx, orderID, eventAdjustmentCancelled, userID,
+		map[string]string{"adjustment_id": adjustmentID, "status": status},
+		map[string]string{"adjustment_id": adjustmentID, "status": adjustmentCancelled})
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": adjustmentCancelled})
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Products are set up once per organization; a branch can override the price,
+// the availability and option price modifiers of any of them. Unset fields
+// inherit the product's own values.
+
+// BranchOverride is one branch's changes to one product.
+type BranchOverride struct {
+	BranchID        string                   `json:"branch_id"`
+	BranchName      string                   `json:"branch_name"`
+	ProductID       string                   `json:"product_id"`
+	Price           *float64                 `json:"price"`
+	IsAvailable     *bool                    `json:"is_available"`
+	OptionModifiers []OptionModifierOverride `json:"option_modifiers"`
+	UpdatedBy       *string                  `json:"updated_by"`
+	UpdatedAt       *time.Time               `json:"updated_at"`
+}
+
+// OptionModifierOverride replaces an option's price modifier in a branch.
+// Options are named by group and option name, as they are linked to groups.
+type OptionModifierOverride struct {
+	OptionGroup   string  `json:"option_group"`
+	OptionName    string  `json:"option_name"`
+	PriceModifier float64 `json:"price_modifier"`
+}
+
+// BranchOverrideRequest replaces a branch's override of a product; null price
+// or availability inherits the product's value.
+type BranchOverrideRequest struct {
+	Price           *float64                 `json:"price"`
+	IsAvailable     *bool                    `json:"is_available"`
+	OptionModifiers []OptionModifierOverride `json:"option_modifiers"`
+}
+
+func optionKey(group, name string) string {
+	return group + "\x00" + name
+}
+
+// branchOptionModifiers returns the branch's option price modifiers of a
+// product, keyed by optionKey.
+func branchOptionModifiers(q queryer, branchID, productID string) (map[string]float64, error) {
+	modifiers := map[string]float64{}
+	if branchID == "" {
+		return modifiers, nil
+	}
+	rows, err := q.Query(`
+		SELECT option_group, option_name, price_modifier
+		FROM branch_option_overrides
+		WHERE branch_id::TEXT = $1 AND product_id = $2
+	`, branchID, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var group, name string
+		var modifier float64
+		if err := rows.Scan(&group, &name, &modifier); err != nil {
+			return nil, err
+		}
+		modifiers[optionKey(group, name)] = modifier
+	}
+	return modifiers, rows.Err()
+}
+
+// loadBranchOptionGroups is loadOptionGroups with the branch's option price
+// modifiers applied. An empty branchID gives the organization-wide prices.
+func loadBranchOptionGroups(q queryer, productID, branchID string) ([]OptionGroup, error) {
+	groups, err := loadOptionGroups(q, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyBranchOptionModifiers(q, groups, productID, branchID); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// applyBranchOptionModifiers sets the branch's option price modifiers on a
+// product's groups in place.
+func applyBranchOptionModifiers(q queryer, groups []OptionGroup, productID, branchID string) error {
+	modifiers, err := branchOptionModifiers(q, branchID, productID)
+	if err != nil {
+		return err
+	}
+	for i := range groups {
+		for j := range groups[i].Options {
+			opt := &groups[i].Options[j]
+			if modifier, ok := modifiers[optionKey(opt.OptionGroup, opt.OptionName)]; ok {
+				opt.PriceModifier = modifier
+			}
+		}
+	}
+	return nil
+}
+
+// branchInOrg reports whether the branch belongs to the organization.
+func branchInOrg(q queryer, orgID, branchID string) (bool, error) {
+	var exists bool
+	err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM branches WHERE id::TEXT = $1 AND organization_id::TEXT = $2)
+	`, branchID, orgID).Scan(&exists)
+	return exists, err
+}
+
+// listBranchOverrides returns every branch's override of a product; branches
+// without one are left out.
+func listBranchOverrides(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	productID := mux.Vars(r)["id"]
+	if !requireProductInOrg(db, w, r, productID) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT b.id, b.name, bo.price, bo.is_available, bo.updated_by, bo.updated_at,
+		       oo.option_group, oo.option_name, oo.price_modifier
+		FROM branches b
+		LEFT JOIN branch_product_overrides bo ON bo.branch_id = b.id AND bo.product_id = $1
+		LEFT JOIN branch_option_overrides oo ON oo.branch_id = b.id AND oo.product_id = $1
+		WHERE b.organization_id = (SELECT organization_id FROM products WHERE id = $1)
+		  AND (bo.branch_id IS NOT NULL OR oo.branch_id IS NOT NULL)
+		ORDER BY b.name, oo.option_group, oo.option_name
+	`, productID)
+	if err != nil {
+		log.Printf("Failed to list branch overrides: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	overrides := []*BranchOverride{}
+	byBranch := map[string]*BranchOverride{}
+	for rows.Next() {
+		var o BranchOverride
+		var group, name sql.NullString
+		var modifier sql.NullFloat64
+		if err := rows.Scan(&o.BranchID, &o.BranchName, &o.Price, &o.IsAvailable, &o.UpdatedBy, &o.UpdatedAt,
+			&group, &name, &modifier); err != nil {
+			log.Printf("Scan branch override failed: %v", err)
+			continue
+		}
+		override, ok := byBranch[o.BranchID]
+		if !ok {
+			o.ProductID = productID
+			o.OptionModifiers = []OptionModifierOverride{}
+			override = &o
+			byBranch[o.BranchID] = override
+			overrides = append(overrides, override)
+		}
+		if group.Valid {
+			override.OptionModifiers = append(override.OptionModifiers, OptionModifierOverride{
+				OptionGroup:   group.String,
+				OptionName:    name.String,
+				PriceModifier: modifier.Float64,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"overrides": overrides})
+}
+
+// putBranchOverride replaces a branch's override of a product. An override
+// that changes nothing is removed.
+func putBranchOverride(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, branchID := vars["id"], vars["branchId"]
+	if !requireProductInOrg(db, w, r, productID) {
+		return
+	}
+	_, orgID, userID := tenantContext(r)
+
+	var req BranchOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if req.Price != nil && *req.Price <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_price"})
+		return
+	}
+
+	ok, err := branchInOrg(db, orgID, branchID)
+	if err != nil {
+		log.Printf("Failed to check branch: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "branch_not_found"})
+		return
+	}
+
+	groups, err := loadOptionGroups(db, productID)
+	if err != nil {
+		log.Printf("Failed to load option groups: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	known := map[string]bool{}
+	for _, g := range groups {
+		for _, opt := range g.Options {
+			known[optionKey(opt.OptionGroup, opt.OptionName)] = true
+		}
+	}
+	seen := map[string]bool{}
+	for i := range req.OptionModifiers {
+		m := &req.OptionModifiers[i]
+		m.OptionGroup = strings.TrimSpace(m.OptionGroup)
+		m.OptionName = strings.TrimSpace(m.OptionName)
+		key := optionKey(m.OptionGroup, m.OptionName)
+		if !known[key] || seen[key] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "option_not_found", "option_group": m.OptionGroup, "option_name": m.OptionName,
+			})
+			return
+		}
+		seen[key] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.Price == nil && req.IsAvailable == nil {
+		_, err = tx.Exec(`DELETE FROM branch_product_overrides WHERE branch_id = $1 AND product_id = $2`, branchID, productID)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO branch_product_overrides (branch_id, product_id, price, is_available, updated_by, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (branch_id, product_id) DO UPDATE
+			SET price = EXCLUDED.price, is_available = EXCLUDED.is_available,
+			    updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		`, branchID, productID, req.Price, req.IsAvailable, nullable(userID))
+	}
+	if err != nil {
+		log.Printf("Failed to save branch override: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM branch_option_overrides WHERE branch_id = $1 AND product_id = $2`, branchID, productID); err != nil {
+		log.Printf("Failed to replace option overrides: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	for _, m := range req.OptionModifiers {
+		_, err := tx.Exec(`
+			INSERT INTO branch_option_overrides (branch_id, product_id, option_group, option_name, price_modifier, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`, branchID, productID, m.OptionGroup, m.OptionName, m.PriceModifier)
+		if err != nil {
+			log.Printf("Failed to save option override: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	pending, err := menuPublished(tx, orgID)
+	if err != nil {
+		log.Printf("Failed to check published menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishOverrideEvent(productID, branchID, orgID, req.Price, req.IsAvailable, pending)
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "updated", "pending_publish": pending})
+}
+
+// publishOverrideEvent tells branch menus (QR and POS) to refresh. Availability
+// applies at once; with a published menu the prices only go live with the
+// next publish, which sends menu_published, so they are left out here.
+func publishOverrideEvent(productID, branchID, orgID string, price *float64, isAvailable *bool, pendingPublish bool) {
+	event := map[string]interface{}{
+		"product_id":      productID,
+		"is_available":    isAvailable,
+		"pending_publish": pendingPublish,
+	}
+	if !pendingPublish {
+		event["price"] = price
+	}
+	publishEvent("product_overridden", event, branchID, orgID)
+}
+
+// deleteBranchOverride puts the product back to its organization-wide
+// settings in the branch.
+func deleteBranchOverride(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, branchID := vars["id"], vars["branchId"]
+	if !requireProductInOrg(db, w, r, productID) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+
+	ok, err := branchInOrg(db, orgID, branchID)
+	if err != nil {
+		log.Printf("Failed to check branch: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "branch_not_found"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM branch_product_overrides WHERE branch_id = $1 AND product_id = $2`, branchID, productID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM branch_option_overrides WHERE branch_id = $1 AND product_id = $2`, branchID, productID)
+	}
+	if err != nil {
+		log.Printf("Failed to delete branch override: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	pending, err := menuPublished(tx, orgID)
+	if err != nil {
+		log.Printf("Failed to check published menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishOverrideEvent(productID, branchID, orgID, nil, nil, pending)
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "deleted", "pending_publish": pending})
+}
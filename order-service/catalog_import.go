@@ -0,0 +1,866 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Catalog import and export move an organization's products, their option
+// groups and categories in and out as JSON or CSV. Products are matched by SKU:
+// known SKUs are updated, new ones created, and products missing from the file
+// are left alone. Categories are named by path ("Drinks > Coffee") and created
+// when missing.
+//
+// CSV has one row per option; the product columns repeat on each row of a SKU
+// and the group columns on each row of a group. A product without options is
+// a single row with the option columns empty. Only sku, name and price are
+// required; leaving out another column keeps that value on existing products,
+// and leaving out option_group keeps their option groups.
+
+const (
+	maxSKULength         = 64
+	maxImportProducts    = 2000
+	maxImportBytes       = 5 << 20
+	categoryPathSep      = ">"
+	maxOptionNameLength  = 100
+	maxProductNameLength = 255
+)
+
+var catalogCSVHeader = []string{
+	"sku", "name", "description", "price", "category", "image_url", "is_available", "sort_order",
+	"option_group", "selection_type", "min_selections", "max_selections", "option_name", "price_modifier",
+}
+
+// catalogFile is the JSON import/export document.
+type catalogFile struct {
+	Categories []catalogCategory `json:"categories"`
+	Products   []catalogProduct  `json:"products"`
+}
+
+// catalogCategory sets up a category; missing parents on its path are created
+// with defaults.
+type catalogCategory struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	ImageURL    string `json:"image_url"`
+	IsVisible   *bool  `json:"is_visible"` // default true
+	SortOrder   int    `json:"sort_order"`
+}
+
+type catalogProduct struct {
+	SKU          string               `json:"sku"`
+	Name         string               `json:"name"`
+	Description  string               `json:"description"`
+	Price        float64              `json:"price"`
+	Category     string               `json:"category"` // path, "" = none
+	ImageURL     string               `json:"image_url"`
+	IsAvailable  *bool                `json:"is_available"` // default true
+	SortOrder    int                  `json:"sort_order"`
+	OptionGroups []OptionGroupRequest `json:"option_groups"` // null keeps the current groups
+
+	row  int
+	keep map[string]bool // CSV columns missing from the file; existing products keep those values
+}
+
+// importRowError points at the CSV line or the 1-based position in products
+// (or categories) of a rejected entry.
+type importRowError struct {
+	Row   int    `json:"row"`
+	SKU   string `json:"sku,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+	Field string `json:"field,omitempty"`
+}
+
+// importRowResult is what the import did, or would do, with one product.
+type importRowResult struct {
+	Row       int      `json:"row"`
+	SKU       string   `json:"sku"`
+	Action    string   `json:"action"` // created, updated, unchanged
+	ProductID string   `json:"product_id,omitempty"`
+	Changes   []string `json:"changes,omitempty"`
+}
+
+type importSummary struct {
+	DryRun            bool              `json:"dry_run"`
+	Created           int               `json:"created"`
+	Updated           int               `json:"updated"`
+	Unchanged         int               `json:"unchanged"`
+	CategoriesCreated int               `json:"categories_created"`
+	CategoriesUpdated int               `json:"categories_updated"`
+	Rows              []importRowResult `json:"rows"`
+	Errors            []importRowError  `json:"errors"`
+}
+
+// skuTaken reports whether another product of the organization has the SKU.
+func skuTaken(q queryer, orgID, sku, exceptID string) (bool, error) {
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM products WHERE organization_id = $1 AND sku = $2 AND id::TEXT <> $3)
+	`, orgID, sku, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// catalogFormat picks csv or json from ?format=, then the Content-Type.
+func catalogFormat(r *http.Request) string {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		return format
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "text/csv" {
+		return "csv"
+	}
+	return "json"
+}
+
+// splitCategoryPath turns "Drinks > Coffee" into its names, top level first.
+func splitCategoryPath(path string) ([]string, bool) {
+	names := []string{}
+	for _, part := range strings.Split(path, categoryPathSep) {
+		name, code := cleanCategoryName(part)
+		if code != "" {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, len(names) <= maxCategoryLevels
+}
+
+// normalize checks a product entry and returns the field and error code of
+// the first problem.
+func (p *catalogProduct) normalize() (string, string) {
+	p.SKU = strings.TrimSpace(p.SKU)
+	p.Name = strings.TrimSpace(p.Name)
+	p.Description = strings.TrimSpace(p.Description)
+	p.ImageURL = strings.TrimSpace(p.ImageURL)
+	p.Category = strings.TrimSpace(p.Category)
+	if p.SKU == "" {
+		return "sku", "sku_required"
+	}
+	if len(p.SKU) > maxSKULength {
+		return "sku", "sku_too_long"
+	}
+	if p.Name == "" {
+		return "name", "name_required"
+	}
+	if len(p.Name) > maxProductNameLength {
+		return "name", "name_too_long"
+	}
+	if p.Price <= 0 || math.IsInf(p.Price, 0) || math.IsNaN(p.Price) {
+		return "price", "invalid_price"
+	}
+	p.Price = math.Round(p.Price*100) / 100
+	if p.Category != "" {
+		if _, ok := splitCategoryPath(p.Category); !ok {
+			return "category", "invalid_category"
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := range p.OptionGroups {
+		g := &p.OptionGroups[i]
+		if code := g.validate(); code != "" {
+			return "option_groups", code
+		}
+		if len(g.Name) > maxOptionNameLength {
+			return "option_groups", "name_too_long"
+		}
+		if seen[g.Name] {
+			return "option_groups", "duplicate_option_group"
+		}
+		seen[g.Name] = true
+		// The file order is the menu order.
+		g.SortOrder = i
+		names := map[string]bool{}
+		for j := range g.Options {
+			opt := &g.Options[j]
+			opt.OptionGroup = g.Name
+			opt.OptionName = strings.TrimSpace(opt.OptionName)
+			opt.IsRequired = g.MinSelections > 0
+			opt.SortOrder = j
+			opt.PriceModifier = math.Round(opt.PriceModifier*100) / 100
+			if len(opt.OptionName) > maxOptionNameLength {
+				return "option_groups", "option_name_too_long"
+			}
+			if names[opt.OptionName] {
+				return "option_groups", "duplicate_option"
+			}
+			names[opt.OptionName] = true
+		}
+	}
+	return "", ""
+}
+
+// optionGroupRequests turns loaded groups back into the form they are
+// imported in, for export and change detection.
+func optionGroupRequests(groups []OptionGroup) []OptionGroupRequest {
+	requests := []OptionGroupRequest{}
+	for _, g := range groups {
+		req := OptionGroupRequest{
+			Name:          g.Name,
+			SelectionType: g.SelectionType,
+			MinSelections: g.MinSelections,
+			MaxSelections: g.MaxSelections,
+			SortOrder:     g.SortOrder,
+			Options:       []CreateProductOptionRequest{},
+		}
+		for _, opt := range g.Options {
+			req.Options = append(req.Options, CreateProductOptionRequest{
+				OptionGroup:   g.Name,
+				OptionName:    opt.OptionName,
+				PriceModifier: opt.PriceModifier,
+				IsRequired:    g.MinSelections > 0,
+				SortOrder:     opt.SortOrder,
+			})
+		}
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+// optionGroupsSignature describes groups by their rules and options in order,
+// ignoring IDs and sort values, so two sets can be compared.
+func optionGroupsSignature(groups []OptionGroupRequest) string {
+	var b strings.Builder
+	for _, g := range groups {
+		max := "-"
+		if g.MaxSelections != nil {
+			max = strconv.Itoa(*g.MaxSelections)
+		}
+		fmt.Fprintf(&b, "%q:%s:%d:%s[", g.Name, g.SelectionType, g.MinSelections, max)
+		for _, opt := range g.Options {
+			fmt.Fprintf(&b, "%q=%.2f,", opt.OptionName, opt.PriceModifier)
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// parseCatalogCSV reads the CSV layout described at the top of this file. Rows
+// that cannot be read are reported and their SKU skipped.
+func parseCatalogCSV(body io.Reader) ([]catalogProduct, []importRowError, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"sku", "name", "price"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+	keep := map[string]bool{}
+	for _, optional := range []string{"description", "category", "image_url", "is_available", "sort_order"} {
+		if _, ok := columns[optional]; !ok {
+			keep[optional] = true
+		}
+	}
+	_, hasOptions := columns["option_group"]
+
+	var products []catalogProduct
+	var errs []importRowError
+	bySKU := map[string]int{}
+	failed := map[string]bool{}
+	// product fields as first seen per SKU, to catch rows that disagree
+	firstRow := map[string][]string{}
+	groupIndex := map[string]map[string]int{}
+
+	productColumns := []string{"name", "description", "price", "category", "image_url", "is_available", "sort_order"}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		fail := func(sku, field, code string) {
+			errs = append(errs, importRowError{Row: line, SKU: sku, Field: field, Error: code})
+			failed[sku] = true
+		}
+
+		sku := get("sku")
+		if sku == "" {
+			if strings.Join(record, "") != "" {
+				errs = append(errs, importRowError{Row: line, Field: "sku", Error: "sku_required"})
+			}
+			continue
+		}
+		if failed[sku] {
+			continue
+		}
+
+		values := make([]string, len(productColumns))
+		for i, column := range productColumns {
+			values[i] = get(column)
+		}
+		index, seen := bySKU[sku]
+		if !seen {
+			p := catalogProduct{SKU: sku, Name: get("name"), Description: get("description"), Category: get("category"),
+				ImageURL: get("image_url"), row: line, keep: keep}
+			if hasOptions {
+				p.OptionGroups = []OptionGroupRequest{}
+			}
+			if p.Price, err = strconv.ParseFloat(get("price"), 64); err != nil {
+				fail(sku, "price", "invalid_price")
+				continue
+			}
+			if v := get("is_available"); v != "" {
+				available, err := strconv.ParseBool(v)
+				if err != nil {
+					fail(sku, "is_available", "invalid_is_available")
+					continue
+				}
+				p.IsAvailable = &available
+			}
+			if v := get("sort_order"); v != "" {
+				if p.SortOrder, err = strconv.Atoi(v); err != nil {
+					fail(sku, "sort_order", "invalid_sort_order")
+					continue
+				}
+			}
+			index = len(products)
+			products = append(products, p)
+			bySKU[sku] = index
+			firstRow[sku] = values
+			groupIndex[sku] = map[string]int{}
+		} else {
+			for i, column := range productColumns {
+				if values[i] != "" && values[i] != firstRow[sku][i] {
+					fail(sku, column, "inconsistent_product_rows")
+					break
+				}
+			}
+			if failed[sku] {
+				continue
+			}
+		}
+		p := &products[index]
+
+		groupName := get("option_group")
+		optionName := get("option_name")
+		if groupName == "" {
+			if optionName != "" {
+				fail(sku, "option_group", "option_group_required")
+			}
+			continue
+		}
+		gi, ok := groupIndex[sku][groupName]
+		if !ok {
+			g := OptionGroupRequest{Name: groupName, SelectionType: get("selection_type"), Options: []CreateProductOptionRequest{}}
+			if v := get("min_selections"); v != "" {
+				if g.MinSelections, err = strconv.Atoi(v); err != nil {
+					fail(sku, "min_selections", "invalid_min_selections")
+					continue
+				}
+			}
+			if v := get("max_selections"); v != "" {
+				max, err := strconv.Atoi(v)
+				if err != nil {
+					fail(sku, "max_selections", "invalid_max_selections")
+					continue
+				}
+				g.MaxSelections = &max
+			}
+			gi = len(p.OptionGroups)
+			p.OptionGroups = append(p.OptionGroups, g)
+			groupIndex[sku][groupName] = gi
+		}
+		if optionName == "" {
+			continue
+		}
+		opt := CreateProductOptionRequest{OptionName: optionName}
+		if v := get("price_modifier"); v != "" {
+			if opt.PriceModifier, err = strconv.ParseFloat(v, 64); err != nil {
+				fail(sku, "price_modifier", "invalid_price_modifier")
+				continue
+			}
+		}
+		p.OptionGroups[gi].Options = append(p.OptionGroups[gi].Options, opt)
+	}
+
+	valid := products[:0]
+	for _, p := range products {
+		if !failed[p.SKU] {
+			valid = append(valid, p)
+		}
+	}
+	return valid, errs, nil
+}
+
+// ensureCategoryPath returns the category at the end of a path, creating the
+// missing levels. created counts the new categories.
+func ensureCategoryPath(tx *sql.Tx, orgID string, names []string, created *int) (string, error) {
+	parentID := ""
+	for _, name := range names {
+		var id string
+		err := tx.QueryRow(`
+			SELECT id FROM categories
+			WHERE organization_id = $1 AND COALESCE(parent_id::TEXT, '') = $2 AND LOWER(name) = LOWER($3)
+		`, orgID, parentID, name).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRow(`
+				INSERT INTO categories (organization_id, parent_id, name, created_at, updated_at)
+				VALUES ($1, $2, $3, NOW(), NOW())
+				RETURNING id
+			`, orgID, nullable(parentID), name).Scan(&id)
+			*created++
+		}
+		if err != nil {
+			return "", err
+		}
+		parentID = id
+	}
+	return parentID, nil
+}
+
+// importCategory applies a JSON category entry and reports whether an
+// existing category changed.
+func importCategory(tx *sql.Tx, orgID string, c catalogCategory, names []string, created *int) (bool, error) {
+	before := *created
+	id, err := ensureCategoryPath(tx, orgID, names, created)
+	if err != nil {
+		return false, err
+	}
+	visible := c.IsVisible == nil || *c.IsVisible
+	res, err := tx.Exec(`
+		UPDATE categories
+		SET description = $1, image_url = $2, is_visible = $3, sort_order = $4, updated_at = NOW()
+		WHERE id = $5
+		  AND (COALESCE(description, '') <> COALESCE($1, '') OR COALESCE(image_url, '') <> COALESCE($2, '')
+		       OR is_visible <> $3 OR sort_order <> $4)
+	`, nullable(strings.TrimSpace(c.Description)), nullable(strings.TrimSpace(c.ImageURL)), visible, c.SortOrder, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0 && *created == before, nil
+}
+
+// replaceOptionGroups swaps a product's groups and options for new ones.
+// Branch modifier overrides survive for options that keep their group and name.
+func replaceOptionGroups(tx *sql.Tx, productID string, groups []OptionGroupRequest) error {
+	if _, err := tx.Exec(`DELETE FROM product_option_groups WHERE product_id = $1`, productID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM product_options WHERE product_id = $1`, productID); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if _, err := insertOptionGroup(tx, productID, g); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`
+		DELETE FROM branch_option_overrides bo
+		WHERE bo.product_id = $1
+		  AND NOT EXISTS (
+		    SELECT 1 FROM product_options o
+		    WHERE o.product_id = bo.product_id AND o.option_group = bo.option_group AND o.option_name = bo.option_name
+		  )
+	`, productID)
+	return err
+}
+
+// importProduct upserts one product by SKU.
+func importProduct(tx *sql.Tx, orgID string, p catalogProduct, categoryID string) (importRowResult, error) {
+	result := importRowResult{Row: p.row, SKU: p.SKU}
+	available := p.IsAvailable == nil || *p.IsAvailable
+
+	var current struct {
+		id, name, description, categoryID, imageURL string
+		price                                       float64
+		available                                   bool
+		sortOrder                                   int
+	}
+	err := tx.QueryRow(`
+		SELECT id, name, COALESCE(description, ''), price, COALESCE(category_id::TEXT, ''), COALESCE(image_url, ''),
+		       is_available, COALESCE(sort_order, 0)
+		FROM products
+		WHERE organization_id = $1 AND sku = $2
+		FOR UPDATE
+	`, orgID, p.SKU).Scan(&current.id, &current.name, &current.description, &current.price, &current.categoryID,
+		&current.imageURL, &current.available, &current.sortOrder)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`
+			INSERT INTO products (organization_id, sku, name, description, price, category_id,
+			                      image_url, is_available, sort_order, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
+			RETURNING id
+		`, orgID, p.SKU, p.Name, nullable(p.Description), p.Price, nullable(categoryID),
+			nullable(p.ImageURL), available, p.SortOrder).Scan(&result.ProductID)
+		if err != nil {
+			return result, err
+		}
+		for _, g := range p.OptionGroups {
+			if _, err := insertOptionGroup(tx, result.ProductID, g); err != nil {
+				return result, err
+			}
+		}
+		result.Action = "created"
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	result.ProductID = current.id
+
+	if p.keep["description"] {
+		p.Description = current.description
+	}
+	if p.keep["category"] {
+		categoryID = current.categoryID
+	}
+	if p.keep["image_url"] {
+		p.ImageURL = current.imageURL
+	}
+	if p.keep["is_available"] {
+		available = current.available
+	}
+	if p.keep["sort_order"] {
+		p.SortOrder = current.sortOrder
+	}
+
+	changed := func(field string, differs bool) {
+		if differs {
+			result.Changes = append(result.Changes, field)
+		}
+	}
+	changed("name", current.name != p.Name)
+	changed("description", current.description != p.Description)
+	changed("price", math.Round(current.price*100) != math.Round(p.Price*100))
+	changed("category", current.categoryID != categoryID)
+	changed("image_url", current.imageURL != p.ImageURL)
+	changed("is_available", current.available != available)
+	changed("sort_order", current.sortOrder != p.SortOrder)
+
+	groupsChanged := false
+	if p.OptionGroups != nil {
+		groups, err := loadOptionGroups(tx, current.id)
+		if err != nil {
+			return result, err
+		}
+		groupsChanged = optionGroupsSignature(optionGroupRequests(groups)) != optionGroupsSignature(p.OptionGroups)
+		changed("option_groups", groupsChanged)
+	}
+
+	if len(result.Changes) == 0 {
+		result.Action = "unchanged"
+		return result, nil
+	}
+
+	_, err = tx.Exec(`
+		UPDATE products
+		SET name = $1, description = $2, price = $3, category_id = $4, image_url = $5,
+		    is_available = $6, sort_order = $7, updated_at = NOW()
+		WHERE id = $8
+	`, p.Name, nullable(p.Description), p.Price, nullable(categoryID), nullable(p.ImageURL), available, p.SortOrder, current.id)
+	if err != nil {
+		return result, err
+	}
+	if groupsChanged {
+		if err := replaceOptionGroups(tx, current.id, p.OptionGroups); err != nil {
+			return result, err
+		}
+	}
+	result.Action = "updated"
+	return result, nil
+}
+
+// importCatalog upserts products by SKU from a JSON or CSV body. Any rejected
+// row fails the whole import. With ?dry_run=true it writes nothing and reports
+// the changes the valid rows would make along with the rejected ones.
+func importCatalog(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var file catalogFile
+	var errs []importRowError
+	switch catalogFormat(r) {
+	case "json":
+		if err := json.NewDecoder(body).Decode(&file); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+		for i := range file.Products {
+			file.Products[i].row = i + 1
+		}
+	case "csv":
+		products, rowErrs, err := parseCatalogCSV(body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_csv", "detail": err.Error()})
+			return
+		}
+		file.Products, errs = products, rowErrs
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_format"})
+		return
+	}
+	if len(file.Products)+len(errs) > maxImportProducts {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{"error": "too_many_products", "max": maxImportProducts})
+		return
+	}
+
+	categoryPaths := make([][]string, len(file.Categories))
+	for i, c := range file.Categories {
+		names, ok := splitCategoryPath(c.Path)
+		if !ok {
+			errs = append(errs, importRowError{Row: i + 1, Path: c.Path, Field: "path", Error: "invalid_category"})
+		}
+		categoryPaths[i] = names
+	}
+	seenSKU := map[string]bool{}
+	rejected := map[int]bool{}
+	for i := range file.Products {
+		p := &file.Products[i]
+		if field, code := p.normalize(); code != "" {
+			errs = append(errs, importRowError{Row: p.row, SKU: p.SKU, Field: field, Error: code})
+			rejected[i] = true
+			continue
+		}
+		if seenSKU[p.SKU] {
+			errs = append(errs, importRowError{Row: p.row, SKU: p.SKU, Field: "sku", Error: "duplicate_sku"})
+			rejected[i] = true
+		}
+		seenSKU[p.SKU] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	// Dry runs and rejected imports end here, undone.
+	defer tx.Rollback()
+
+	// One import per organization at a time.
+	if _, err := tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		log.Printf("Failed to lock organization: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	summary := importSummary{DryRun: dryRun, Rows: []importRowResult{}, Errors: []importRowError{}}
+	for i, c := range file.Categories {
+		if categoryPaths[i] == nil {
+			continue
+		}
+		updated, err := importCategory(tx, orgID, c, categoryPaths[i], &summary.CategoriesCreated)
+		if err != nil {
+			log.Printf("Failed to import category: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if updated {
+			summary.CategoriesUpdated++
+		}
+	}
+
+	for i, p := range file.Products {
+		if rejected[i] {
+			continue
+		}
+		categoryID := ""
+		if p.Category != "" {
+			names, _ := splitCategoryPath(p.Category)
+			if categoryID, err = ensureCategoryPath(tx, orgID, names, &summary.CategoriesCreated); err != nil {
+				log.Printf("Failed to import category: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		}
+		result, err := importProduct(tx, orgID, p, categoryID)
+		if err != nil {
+			log.Printf("Failed to import product %s: %v", p.SKU, err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		switch result.Action {
+		case "created":
+			summary.Created++
+		case "updated":
+			summary.Updated++
+		default:
+			summary.Unchanged++
+		}
+		summary.Rows = append(summary.Rows, result)
+	}
+	summary.Errors = append(summary.Errors, errs...)
+
+	if dryRun {
+		writeJSON(w, http.StatusOK, summary)
+		return
+	}
+	if len(summary.Errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": "invalid_rows", "errors": summary.Errors})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("catalog_imported", map[string]interface{}{
+		"created":            summary.Created,
+		"updated":            summary.Updated,
+		"categories_created": summary.CategoriesCreated,
+	}, "", orgID)
+
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// exportCatalog writes the organization's draft catalog as JSON or CSV in the
+// layout importCatalog reads.
+func exportCatalog(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+	format := catalogFormat(r)
+	if format != "json" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_format"})
+		return
+	}
+
+	tree, err := loadCategories(db, orgID, "", false)
+	if err != nil {
+		log.Printf("Failed to load categories: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	file := catalogFile{Categories: []catalogCategory{}, Products: []catalogProduct{}}
+	paths := map[string]string{}
+	for _, c := range flattenCategories(tree) {
+		path := c.Name
+		if parent, ok := paths[derefString(c.ParentID)]; ok {
+			path = parent + " " + categoryPathSep + " " + c.Name
+		}
+		paths[c.ID] = path
+		visible := c.IsVisible
+		file.Categories = append(file.Categories, catalogCategory{
+			Path:        path,
+			Description: derefString(c.Description),
+			ImageURL:    derefString(c.ImageURL),
+			IsVisible:   &visible,
+			SortOrder:   c.SortOrder,
+		})
+	}
+
+	rows, err := db.Query(`
+		SELECT p.id, COALESCE(p.sku, ''), p.name, COALESCE(p.description, ''), p.price, COALESCE(p.category_id::TEXT, ''),
+		       COALESCE(p.image_url, ''), p.is_available, COALESCE(p.sort_order, 0)
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		WHERE p.organization_id = $1
+		ORDER BY c.sort_order NULLS LAST, p.sort_order, p.name
+	`, orgID)
+	if err != nil {
+		log.Printf("Failed to export products: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id, categoryID string
+		var p catalogProduct
+		var available bool
+		if err := rows.Scan(&id, &p.SKU, &p.Name, &p.Description, &p.Price, &categoryID, &p.ImageURL, &available, &p.SortOrder); err != nil {
+			rows.Close()
+			log.Printf("Failed to scan product: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		p.Category = paths[categoryID]
+		p.IsAvailable = &available
+		ids = append(ids, id)
+		file.Products = append(file.Products, p)
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		groups, err := loadOptionGroups(db, id)
+		if err != nil {
+			log.Printf("Failed to load option groups: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		file.Products[i].OptionGroups = optionGroupRequests(groups)
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Disposition", `attachment; filename="catalog.json"`)
+		writeJSON(w, http.StatusOK, file)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="catalog.csv"`)
+	w.WriteHeader(http.StatusOK)
+	out := csv.NewWriter(w)
+	out.Write(catalogCSVHeader)
+	for _, p := range file.Products {
+		product := []string{
+			p.SKU, p.Name, p.Description, strconv.FormatFloat(p.Price, 'f', 2, 64), p.Category, p.ImageURL,
+			strconv.FormatBool(*p.IsAvailable), strconv.Itoa(p.SortOrder),
+		}
+		if len(p.OptionGroups) == 0 {
+			out.Write(append(product, "", "", "", "", "", ""))
+			continue
+		}
+		for _, g := range p.OptionGroups {
+			group := []string{g.Name, g.SelectionType, strconv.Itoa(g.MinSelections), ""}
+			if g.MaxSelections != nil {
+				group[3] = strconv.Itoa(*g.MaxSelections)
+			}
+			if len(g.Options) == 0 {
+				out.Write(append(append(append([]string{}, product...), group...), "", ""))
+				continue
+			}
+			for _, opt := range g.Options {
+				row := append(append([]string{}, product...), group...)
+				out.Write(append(row, opt.OptionName, strconv.FormatFloat(opt.PriceModifier, 'f', 2, 64)))
+			}
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Failed to write catalog CSV: %v", err)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Top level, subcategory, sub-subcategory.
+	maxCategoryLevels     = 3
+	maxCategoryNameLength = 100
+)
+
+// Category is a node of an organization's menu tree. Products reference it
+// by ID; kitchen stations route it and, unless mapped themselves, its
+// subcategories.
+type Category struct {
+	ID             string      `json:"id"`
+	OrganizationID string      `json:"organization_id"`
+	ParentID       *string     `json:"parent_id"`
+	Name           string      `json:"name"`
+	Description    *string     `json:"description"`
+	ImageURL       *string     `json:"image_url"`
+	IsVisible      bool        `json:"is_visible"`
+	SortOrder      int         `json:"sort_order"`
+	Depth          int         `json:"depth"`
+	ProductCount   int         `json:"product_count"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	Children       []*Category `json:"children,omitempty"`
+}
+
+type CreateCategoryRequest struct {
+	ParentID    *string `json:"parent_id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	ImageURL    string  `json:"image_url"`
+	IsVisible   *bool   `json:"is_visible"` // default true
+	SortOrder   int     `json:"sort_order"`
+}
+
+// UpdateCategoryRequest changes only the fields it carries; a parent_id of ""
+// moves the category to the top level.
+type UpdateCategoryRequest struct {
+	ParentID    *string `json:"parent_id"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	ImageURL    *string `json:"image_url"`
+	IsVisible   *bool   `json:"is_visible"`
+	SortOrder   *int    `json:"sort_order"`
+}
+
+// cleanCategoryName trims a name and returns an error code when it is unusable.
+func cleanCategoryName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", "name_required"
+	}
+	if len(name) > maxCategoryNameLength {
+		return "", "name_too_long"
+	}
+	return name, ""
+}
+
+// categoryInOrg reports whether id names a category of the organization.
+// Malformed IDs are simply not found.
+func categoryInOrg(q queryer, orgID, id string) (bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return false, nil
+	}
+	var exists bool
+	err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1 AND organization_id = $2)`, id, orgID).Scan(&exists)
+	return exists, err
+}
+
+// siblingNameTaken reports whether another category under the same parent
+// already uses the name, ignoring case.
+func siblingNameTaken(q queryer, orgID string, parentID *string, name, exceptID string) (bool, error) {
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM categories
+			WHERE organization_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+			  AND LOWER(name) = LOWER($3) AND id::TEXT <> $4
+		)
+	`, orgID, parentID, name, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// loadCategories returns the organization's categories as a tree, siblings by
+// sort order then name. With rootID only that category's subtree is loaded;
+// visibleOnly drops hidden categories together with everything below them.
+func loadCategories(q queryer, orgID, rootID string, visibleOnly bool) ([]*Category, error) {
+	query := `
+		SELECT c.id, c.organization_id, c.parent_id, c.name, c.description, c.image_url, c.is_visible,
+		       c.sort_order, t.depth, (SELECT COUNT(*) FROM products p WHERE p.category_id = c.id),
+		       c.created_at, c.updated_at
+		FROM categories c
+		JOIN v_category_tree t ON t.category_id = c.id
+		WHERE c.organization_id = $1`
+	args := []any{orgID}
+	if rootID != "" {
+		query += ` AND $2::UUID = ANY(t.path)`
+		args = append(args, rootID)
+	}
+	if visibleOnly {
+		query += ` AND t.visible_path`
+	}
+	query += ` ORDER BY t.depth, c.sort_order, c.name`
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Parents come before their children, so every child finds its parent.
+	roots := []*Category{}
+	byID := map[string]*Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.ParentID, &c.Name, &c.Description, &c.ImageURL, &c.IsVisible,
+			&c.SortOrder, &c.Depth, &c.ProductCount, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		byID[c.ID] = &c
+		if parent, ok := byID[derefString(c.ParentID)]; ok {
+			parent.Children = append(parent.Children, &c)
+		} else {
+			roots = append(roots, &c)
+		}
+	}
+	return roots, rows.Err()
+}
+
+// flattenCategories lists a tree depth-first, parents before their children,
+// with Children cleared.
+func flattenCategories(nodes []*Category) []*Category {
+	flat := []*Category{}
+	var walk func([]*Category)
+	walk = func(nodes []*Category) {
+		for _, c := range nodes {
+			children := c.Children
+			c.Children = nil
+			flat = append(flat, c)
+			walk(children)
+		}
+	}
+	walk(nodes)
+	return flat
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// listCategories returns the category tree, or a flat list with ?flat=true.
+// Guests (QR menu, ?organization_id=) only see visible categories.
+func listCategories(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	_, orgID, _ := tenantContext(r)
+	visibleOnly := r.URL.Query().Get("visible_only") == "true"
+	guest := orgID == ""
+	if guest {
+		orgID = r.URL.Query().Get("organization_id")
+		visibleOnly = true
+	}
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+	if _, err := uuid.Parse(orgID); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_organization"})
+		return
+	}
+
+	// Guests get the published menu's categories once there is one.
+	var menu *publishedMenu
+	if guest {
+		var err error
+		if menu, err = loadPublishedMenu(db, orgID); err != nil {
+			log.Printf("Failed to load published menu: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	var categories []*Category
+	if menu != nil {
+		categories = menu.categoryTree(visibleOnly)
+	} else {
+		var err error
+		categories, err = loadCategories(db, orgID, "", visibleOnly)
+		if err != nil {
+			log.Printf("Failed to list categories: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	if r.URL.Query().Get("flat") == "true" {
+		categories = flattenCategories(categories)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"categories": categories})
+}
+
+// getCategory returns one category with its subtree.
+func getCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	_, orgID, _ := tenantContext(r)
+
+	exists, err := categoryInOrg(db, orgID, id)
+	if err == nil && exists {
+		var categories []*Category
+		categories, err = loadCategories(db, orgID, id, false)
+		if err == nil && len(categories) == 1 {
+			writeJSON(w, http.StatusOK, categories[0])
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to get category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "category_not_found"})
+}
+
+// placeCategory checks that a category (of subtreeHeight levels below itself)
+// fits under parentID and returns an error code when it does not. categoryID
+// is empty for a new category.
+func placeCategory(q queryer, orgID, categoryID, parentID string, subtreeHeight int) (int, string, error) {
+	var depth int
+	var path []byte
+	err := q.QueryRow(`
+		SELECT t.depth, array_to_json(t.path)
+		FROM categories c JOIN v_category_tree t ON t.category_id = c.id
+		WHERE c.id = $1 AND c.organization_id = $2
+	`, parentID, orgID).Scan(&depth, &path)
+	if err == sql.ErrNoRows {
+		return http.StatusBadRequest, "parent_not_found", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if categoryID != "" {
+		var ancestors []string
+		if err := json.Unmarshal(path, &ancestors); err != nil {
+			return 0, "", err
+		}
+		for _, id := range ancestors {
+			if id == categoryID {
+				return http.StatusConflict, "category_cycle", nil
+			}
+		}
+	}
+	if depth+1+subtreeHeight >= maxCategoryLevels {
+		return http.StatusConflict, "category_too_deep", nil
+	}
+	return 0, "", nil
+}
+
+func createCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+
+	var req CreateCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	name, code := cleanCategoryName(req.Name)
+	if code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": code, "max_length": maxCategoryNameLength})
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == "" {
+		req.ParentID = nil
+	}
+	if req.ParentID != nil {
+		if _, err := uuid.Parse(*req.ParentID); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "parent_not_found"})
+			return
+		}
+	}
+	visible := req.IsVisible == nil || *req.IsVisible
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.ParentID != nil {
+		status, code, err := placeCategory(tx, orgID, "", *req.ParentID, 0)
+		if err != nil {
+			log.Printf("Failed to check category parent: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if code != "" {
+			writeJSON(w, status, map[string]any{"error": code, "max_levels": maxCategoryLevels})
+			return
+		}
+	}
+
+	taken, err := siblingNameTaken(tx, orgID, req.ParentID, name, "")
+	if err != nil {
+		log.Printf("Failed to check category name: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if taken {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "category_exists"})
+		return
+	}
+
+	var categoryID string
+	err = tx.QueryRow(`
+		INSERT INTO categories (organization_id, parent_id, name, description, image_url, is_visible, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+		RETURNING id
+	`, orgID, req.ParentID, name, nullable(strings.TrimSpace(req.Description)), nullable(strings.TrimSpace(req.ImageURL)),
+		visible, req.SortOrder).Scan(&categoryID)
+	if err != nil {
+		log.Printf("Failed to create category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("category_created", map[string]interface{}{
+		"category_id": categoryID,
+		"parent_id":   req.ParentID,
+		"name":        name,
+	}, "", orgID)
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": categoryID})
+}
+
+// updateCategory renames, moves, re-sorts or hides a category. A rename
+// applies to every product of the category at once.
+func updateCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	_, orgID, _ := tenantContext(r)
+
+	var req UpdateCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	exists, err := categoryInOrg(tx, orgID, id)
+	if err != nil {
+		log.Printf("Failed to get category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "category_not_found"})
+		return
+	}
+
+	var parentID *string
+	var name string
+	err = tx.QueryRow(`SELECT parent_id, name FROM categories WHERE id = $1 FOR UPDATE`, id).Scan(&parentID, &name)
+	if err != nil {
+		log.Printf("Failed to lock category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	updates := []string{}
+	args := []interface{}{}
+	argPos := 1
+
+	if req.ParentID != nil && *req.ParentID != derefString(parentID) {
+		parentID = nil
+		if *req.ParentID != "" {
+			if _, err := uuid.Parse(*req.ParentID); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "parent_not_found"})
+				return
+			}
+			parentID = req.ParentID
+
+			// Lock the new ancestors so a concurrent move cannot close a loop.
+			if _, err := tx.Exec(`
+				SELECT 1 FROM categories
+				WHERE id = ANY(SELECT UNNEST(path) FROM v_category_tree WHERE category_id = $1)
+				FOR UPDATE
+			`, *parentID); err != nil {
+				log.Printf("Failed to lock category parents: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			var height int
+			err := tx.QueryRow(`
+				SELECT COALESCE(MAX(t.depth) - MIN(t.depth), 0)
+				FROM v_category_tree t WHERE $1::UUID = ANY(t.path)
+			`, id).Scan(&height)
+			if err != nil {
+				log.Printf("Failed to measure category subtree: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			status, code, err := placeCategory(tx, orgID, id, *parentID, height)
+			if err != nil {
+				log.Printf("Failed to check category parent: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if code != "" {
+				writeJSON(w, status, map[string]any{"error": code, "max_levels": maxCategoryLevels})
+				return
+			}
+		}
+		updates = append(updates, fmt.Sprintf("parent_id = $%d", argPos))
+		args = append(args, parentID)
+		argPos++
+	}
+	if req.Name != nil {
+		cleaned, code := cleanCategoryName(*req.Name)
+		if code != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": code, "max_length": maxCategoryNameLength})
+			return
+		}
+		name = cleaned
+		updates = append(updates, fmt.Sprintf("name = $%d", argPos))
+		args = append(args, name)
+		argPos++
+	}
+	if req.Description != nil {
+		updates = append(updates, fmt.Sprintf("description = $%d", argPos))
+		args = append(args, nullable(strings.TrimSpace(*req.Description)))
+		argPos++
+	}
+	if req.ImageURL != nil {
+		updates = append(updates, fmt.Sprintf("image_url = $%d", argPos))
+		args = append(args, nullable(strings.TrimSpace(*req.ImageURL)))
+		argPos++
+	}
+	if req.IsVisible != nil {
+		updates = append(updates, fmt.Sprintf("is_visible = $%d", argPos))
+		args = append(args, *req.IsVisible)
+		argPos++
+	}
+	if req.SortOrder != nil {
+		updates = append(updates, fmt.Sprintf("sort_order = $%d", argPos))
+		args = append(args, *req.SortOrder)
+		argPos++
+	}
+
+	if len(updates) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no_updates"})
+		return
+	}
+
+	// A rename or a move can both collide with a sibling.
+	taken, err := siblingNameTaken(tx, orgID, parentID, name, id)
+	if err != nil {
+		log.Printf("Failed to check category name: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if taken {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "category_exists"})
+		return
+	}
+
+	updates = append(updates, "updated_at = NOW()")
+	args = append(args, id)
+	query := fmt.Sprintf(`UPDATE categories SET %s WHERE id = $%d`, strings.Join(updates, ", "), argPos)
+	if _, err := tx.Exec(query, args...); err != nil {
+		log.Printf("Failed to update category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("category_updated", map[string]interface{}{
+		"category_id": id,
+		"parent_id":   parentID,
+		"name":        name,
+	}, "", orgID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// deleteCategory removes an empty category. Subcategories and products have
+// to be moved or deleted first so nothing silently drops off the menu.
+func deleteCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	_, orgID, _ := tenantContext(r)
+
+	exists, err := categoryInOrg(db, orgID, id)
+	if err != nil {
+		log.Printf("Failed to get category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "category_not_found"})
+		return
+	}
+
+	var children, products int
+	err = db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM categories WHERE parent_id = $1),
+		       (SELECT COUNT(*) FROM products WHERE category_id = $1)
+	`, id).Scan(&children, &products)
+	if err != nil {
+		log.Printf("Failed to check category contents: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if children > 0 {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "category_has_children", "children": children})
+		return
+	}
+	if products > 0 {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "category_has_products", "products": products})
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM categories WHERE id = $1 AND organization_id = $2`, id, orgID); err != nil {
+		log.Printf("Failed to delete category: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("category_deleted", map[string]interface{}{"category_id": id}, "", orgID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Items carry a course number (1 = starters, 2 = mains, ...). Held items stay
+// off kitchen screens, tickets and events until their course is fired.
+const maxCourse = 9
+
+const eventCourseFired = "course_fired"
+
+// FiredItem is a held item released to the kitchen, in the shape of the
+// items of order_created events.
+type FiredItem struct {
+	ItemID       string   `json:"item_id"`
+	MenuItemName string   `json:"menu_item_name"`
+	Quantity     int      `json:"quantity"`
+	Options      []string `json:"options"`
+	Notes        *string  `json:"notes"`
+	Course       int      `json:"course"`
+	Station      string   `json:"station"`
+}
+
+// normalizeCourse defaults an unset course to 1.
+func normalizeCourse(course int) (int, bool) {
+	if course == 0 {
+		return 1, true
+	}
+	return course, course >= 1 && course <= maxCourse
+}
+
+// fireCourse releases an order's held items of one course to the kitchen.
+// Without a course in the body the lowest held course is fired.
+func fireCourse(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var req struct {
+		Course int `json:"course"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if req.Course < 0 || req.Course > maxCourse {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_course", "max_course": maxCourse})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if status, code := lockOpenOrder(tx, orderID); code != "" {
+		writeJSON(w, status, map[string]string{"error": code})
+		return
+	}
+
+	course := req.Course
+	if course == 0 {
+		var next sql.NullInt64
+		err := tx.QueryRow(`
+			SELECT MIN(course) FROM order_items WHERE order_id = $1 AND held AND item_status <> 'CANCELLED'
+		`, orderID).Scan(&next)
+		if err != nil {
+			log.Printf("Failed to find next course: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if !next.Valid {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "no_held_items"})
+			return
+		}
+		course = int(next.Int64)
+	}
+
+	rows, err := tx.Query(`
+		UPDATE order_items
+		SET held = false, fired_at = NOW()
+		WHERE order_id = $1 AND course = $2 AND held AND item_status <> 'CANCELLED'
+		RETURNING id, menu_item_name, quantity, modifiers, notes
+	`, orderID, course)
+	if err != nil {
+		log.Printf("Failed to fire course: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	items := []FiredItem{}
+	itemIDs := []string{}
+	for rows.Next() {
+		item := FiredItem{Course: course, Options: []string{}}
+		var modifiers []byte
+		if err := rows.Scan(&item.ItemID, &item.MenuItemName, &item.Quantity, &modifiers, &item.Notes); err != nil {
+			rows.Close()
+			log.Printf("Scan fired item failed: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		var mods []OrderItemModifier
+		if len(modifiers) > 0 && json.Unmarshal(modifiers, &mods) == nil {
+			for _, m := range mods {
+				item.Options = append(item.Options, m.OptionName)
+			}
+		}
+		items = append(items, item)
+		itemIDs = append(itemIDs, item.ItemID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to fire course: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if len(items) == 0 {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "no_held_items", "course": course})
+		return
+	}
+
+	var remaining int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM order_items WHERE order_id = $1 AND held AND item_status <> 'CANCELLED'
+	`, orderID).Scan(&remaining); err != nil {
+		log.Printf("Failed to count held items: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err := recordOrderEvent(tx, orderID, eventCourseFired, userID, nil, map[string]any{"course": course, "item_ids": itemIDs}); err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	stations, err := orderItemStations(db, orderID)
+	if err != nil {
+		log.Printf("Failed to route course to kitchen stations: %v", err)
+	}
+	routed := map[string]string{}
+	for i := range items {
+		if code, ok := stations[items[i].ItemID]; ok {
+			items[i].Station = code
+			routed[items[i].ItemID] = code
+		}
+	}
+	publishStationEvent("course_fired", map[string]interface{}{
+		"order_id":       orderID,
+		"course":         course,
+		"items":          items,
+		"held_remaining": remaining,
+		"fired_by":       userID,
+	}, distinctStations(routed), branchID, orgID)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"order_id":       orderID,
+		"course":         course,
+		"items":          items,
+		"held_remaining": remaining,
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	itemPending   = "PENDING"
+	itemPreparing = "PREPARING"
+	itemReady     = "READY"
+	itemServed    = "SERVED"
+	itemCancelled = "CANCELLED"
+)
+
+// itemStatusFlow is the kitchen lifecycle of a line item. Items only move
+// forward, and may skip steps (e.g. drinks go straight to READY). CANCELLED is
+// outside the flow and is only reached by voiding or comping the item.
+var itemStatusFlow = []string{itemPending, itemPreparing, itemReady, itemServed}
+
+// ItemStatusChange is one item moved by the item status or bump endpoints.
+type ItemStatusChange struct {
+	ItemID       string `json:"item_id"`
+	MenuItemName string `json:"menu_item_name"`
+	FromStatus   string `json:"from_status"`
+	ToStatus     string `json:"to_status"`
+}
+
+// itemStep returns the item's position in itemStatusFlow, or -1 if it is not part of it.
+func itemStep(status string) int {
+	for i, s := range itemStatusFlow {
+		if s == status {
+			return i
+		}
+	}
+	return -1
+}
+
+func nextItemStatus(status string) string {
+	step := itemStep(status)
+	if step < 0 || step == len(itemStatusFlow)-1 {
+		return ""
+	}
+	return itemStatusFlow[step+1]
+}
+
+// moveOrderItem advances one locked item to the target status, stamps
+// prepared_at when it becomes READY (or is served without being readied) and
+// served_at when it is SERVED, and records the move on the order's timeline.
+func moveOrderItem(tx *sql.Tx, orderID, itemID, to, userID string) (*ItemStatusChange, error) {
+	change := &ItemStatusChange{ItemID: itemID, ToStatus: to}
+	var held bool
+	err := tx.QueryRow(`
+		SELECT menu_item_name, item_status, held
+		FROM order_items
+		WHERE id = $1 AND order_id = $2
+		FOR UPDATE
+	`, itemID, orderID).Scan(&change.MenuItemName, &change.FromStatus, &held)
+	if err != nil {
+		return nil, err
+	}
+	if held {
+		return change, &statusTransitionError{Code: "item_held", From: change.FromStatus, To: to, Allowed: []string{}}
+	}
+
+	from := itemStep(change.FromStatus)
+	if from < 0 || itemStep(to) <= from {
+		allowed := []string{}
+		if from >= 0 {
+			allowed = itemStatusFlow[from+1:]
+		}
+		return change, &statusTransitionError{Code: "invalid_item_transition", From: change.FromStatus, To: to, Allowed: allowed}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE order_items
+		SET item_status = $1,
+		    prepared_at = CASE WHEN $1 IN ('READY', 'SERVED') THEN COALESCE(prepared_at, NOW()) ELSE prepared_at END,
+		    served_at = CASE WHEN $1 = 'SERVED' THEN NOW() ELSE served_at END
+		WHERE id = $2
+	`, to, itemID)
+	if err != nil {
+		return change, err
+	}
+
+	err = recordOrderEvent(tx, orderID, eventItemStatusChanged, userID,
+		map[string]string{"item_id": itemID, "menu_item_name": change.MenuItemName, "item_status": change.FromStatus},
+		map[string]string{"item_id": itemID, "menu_item_name": change.MenuItemName, "item_status": to})
+	return change, err
+}
+
+// lockOpenOrder fails with order_cancelled when the kitchen should no longer
+// touch the order's items.
+func lockOpenOrder(tx *sql.Tx, orderID string) (int, string) {
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR SHARE`, orderID).Scan(&status); err != nil {
+		log.Printf("Failed to lock order: %v", err)
+		return http.StatusInternalServerError, "db_error"
+	}
+	if status == statusCancelled {
+		return http.StatusConflict, "order_cancelled"
+	}
+	return 0, ""
+}
+
+// publishItemStatusChanges emits one event per moved item, scoped to the
+// item's kitchen station, and refreshes the pickup queue for off-premise orders.
+func publishItemStatusChanges(db *sql.DB, orderID string, changes []ItemStatusChange, userID, branchID, orgID string) {
+	stations, err := orderItemStations(db, orderID)
+	if err != nil {
+		log.Printf("Failed to route items to kitchen stations: %v", err)
+	}
+	for _, c := range changes {
+		var scope []string
+		if code, ok := stations[c.ItemID]; ok {
+			scope = []string{code}
+		}
+		publishStationEvent("order_item_status_updated", map[string]interface{}{
+			"order_id":       orderID,
+			"item_id":        c.ItemID,
+			"menu_item_name": c.MenuItemName,
+			"status":         c.ToStatus,
+			"from_status":    c.FromStatus,
+			"station":        stations[c.ItemID],
+			"changed_by":     userID,
+		}, scope, branchID, orgID)
+	}
+	if len(changes) > 0 {
+		publishPickupUpdate(db, orderID, orgID)
+	}
+}
+
+func updateOrderItemStatus(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+	itemID := vars["itemId"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.Status = strings.ToUpper(strings.TrimSpace(req.Status))
+	if itemStep(req.Status) < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_item_status"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if status, code := lockOpenOrder(tx, orderID); code != "" {
+		writeJSON(w, status, map[string]string{"error": code})
+		return
+	}
+
+	change, err := moveOrderItem(tx, orderID, itemID, req.Status, userID)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "item_not_found"})
+		return
+	}
+	if transErr, ok := err.(*statusTransitionError); ok {
+		writeJSON(w, http.StatusConflict, transErr)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update item status: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishItemStatusChanges(db, orderID, []ItemStatusChange{*change}, userID, branchID, orgID)
+
+	writeJSON(w, http.StatusOK, change)
+}
+
+// bumpOrderItems moves every active item on a ticket forward. With a target
+// status, items behind it jump to it; without one, each item advances one step.
+func bumpOrderItems(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.Status = strings.ToUpper(strings.TrimSpace(req.Status))
+	if req.Status != "" && itemStep(req.Status) < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_item_status"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if status, code := lockOpenOrder(tx, orderID); code != "" {
+		writeJSON(w, status, map[string]string{"error": code})
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, item_status
+		FROM order_items
+		WHERE order_id = $1 AND item_status IN ('PENDING', 'PREPARING', 'READY') AND NOT held
+		ORDER BY created_at
+	`, orderID)
+	if err != nil {
+		log.Printf("Failed to get ticket items: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	targets := map[string]string{}
+	var itemIDs []string
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			rows.Close()
+			log.Printf("Scan ticket item failed: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		to := req.Status
+		if to == "" {
+			to = nextItemStatus(status)
+		}
+		if itemStep(to) > itemStep(status) {
+			targets[id] = to
+			itemIDs = append(itemIDs, id)
+		}
+	}
+	rows.Close()
+
+	changes := []ItemStatusChange{}
+	for _, id := range itemIDs {
+		change, err := moveOrderItem(tx, orderID, id, targets[id], userID)
+		if _, ok := err.(*statusTransitionError); ok {
+			// Moved by a concurrent request since we read it; leave it be.
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to bump item: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		changes = append(changes, *change)
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishItemStatusChanges(db, orderID, changes, userID, branchID, orgID)
+
+	writeJSON(w, http.StatusOK, map[string]any{"order_id": orderID, "items": changes})
+}
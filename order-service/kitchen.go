@@ -0,0 +1,491 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// KitchenStation is a preparation area of a branch (grill, drinks bar, ...).
+// Items reach a station through their product category or, when that is not
+// mapped, the nearest mapped parent category.
+type KitchenStation struct {
+	ID          string    `json:"id"`
+	BranchID    string    `json:"branch_id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	IsDefault   bool      `json:"is_default"`
+	SortOrder   int       `json:"sort_order"`
+	CategoryIDs []string  `json:"category_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type KitchenStationRequest struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name"`
+	IsDefault   bool     `json:"is_default"`
+	SortOrder   int      `json:"sort_order"`
+	CategoryIDs []string `json:"category_ids"`
+}
+
+// KitchenTicket is one order's active items as seen by a kitchen screen.
+type KitchenTicket struct {
+	OrderID     string              `json:"order_id"`
+	OrderNumber int                 `json:"order_number"`
+	OrderCode   *string             `json:"order_code"`
+	TableNumber *int                `json:"table_number"`
+	Notes       *string             `json:"notes"`
+	Items       []KitchenTicketItem `json:"items"`
+}
+
+type KitchenTicketItem struct {
+	ItemID         string     `json:"item_id"`
+	MenuItemName   string     `json:"menu_item_name"`
+	Quantity       int        `json:"quantity"`
+	Notes          *string    `json:"notes"`
+	Course         int        `json:"course"`
+	ItemStatus     string     `json:"item_status"`
+	StationCode    *string    `json:"station"`
+	StationName    *string    `json:"station_name"`
+	CreatedAt      time.Time  `json:"created_at"`
+	SentAt         time.Time  `json:"sent_at"` // fired_at for items that were held
+	PreparedAt     *time.Time `json:"prepared_at"`
+	MinutesWaiting float64    `json:"minutes_waiting"`
+}
+
+// normalize trims the request and returns an error code when it is unusable.
+func (req *KitchenStationRequest) normalize() string {
+	req.Code = strings.ToLower(strings.TrimSpace(req.Code))
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Code == "" || req.Name == "" {
+		return "invalid_request"
+	}
+	seen := map[string]bool{}
+	categoryIDs := []string{}
+	for _, id := range req.CategoryIDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return "category_not_found"
+		}
+		seen[id] = true
+		categoryIDs = append(categoryIDs, id)
+	}
+	req.CategoryIDs = categoryIDs
+	return ""
+}
+
+// saveStationCategories points the given categories at the station, taking
+// them over from any other station of the branch. It returns false when a
+// category does not belong to the branch's organization.
+func saveStationCategories(tx *sql.Tx, branchID, stationID string, categoryIDs []string) (bool, error) {
+	if _, err := tx.Exec(`DELETE FROM kitchen_station_categories WHERE station_id = $1`, stationID); err != nil {
+		return false, err
+	}
+	for _, categoryID := range categoryIDs {
+		res, err := tx.Exec(`
+			INSERT INTO kitchen_station_categories (branch_id, category_id, station_id)
+			SELECT b.id, c.id, $3
+			FROM branches b
+			JOIN categories c ON c.organization_id = b.organization_id
+			WHERE b.id = $1 AND c.id = $2
+			ON CONFLICT (branch_id, category_id) DO UPDATE SET station_id = EXCLUDED.station_id
+		`, branchID, categoryID, stationID)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// clearDefaultStation drops the default flag from the branch's other stations.
+func clearDefaultStation(tx *sql.Tx, branchID, stationID string) error {
+	_, err := tx.Exec(`
+		UPDATE kitchen_stations SET is_default = false, updated_at = NOW()
+		WHERE branch_id = $1 AND is_default AND id::TEXT <> $2
+	`, branchID, stationID)
+	return err
+}
+
+// stationCodeExists reports whether a station of the branch other than exceptID uses code.
+func stationCodeExists(q queryer, branchID, code, exceptID string) (bool, error) {
+	var exists int
+	err := q.QueryRow(`
+		SELECT 1 FROM kitchen_stations WHERE branch_id = $1 AND code = $2 AND id::TEXT <> $3
+	`, branchID, code, exceptID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// orderItemStations maps each routed item of an order to its station code.
+func orderItemStations(q queryer, orderID string) (map[string]string, error) {
+	rows, err := q.Query(`
+		SELECT item_id, station_code
+		FROM v_order_item_stations
+		WHERE order_id = $1 AND station_code IS NOT NULL
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stations := map[string]string{}
+	for rows.Next() {
+		var itemID, code string
+		if err := rows.Scan(&itemID, &code); err != nil {
+			return nil, err
+		}
+		stations[itemID] = code
+	}
+	return stations, rows.Err()
+}
+
+// distinctStations returns the sorted set of station codes in an item->station map.
+func distinctStations(stations map[string]string) []string {
+	seen := map[string]bool{}
+	codes := []string{}
+	for _, code := range stations {
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// requireStaff refuses guests. The gateway sets X-User-ID only for a valid
+// token, and guests may still know order IDs from the QR menu.
+func requireStaff(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("X-User-ID") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return false
+	}
+	return true
+}
+
+func requireManager(w http.ResponseWriter, r *http.Request) bool {
+	role := r.Header.Get("X-User-Role")
+	if role != "MANAGER" && role != "ADMIN" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "unauthorized"})
+		return false
+	}
+	return true
+}
+
+func listKitchenStations(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	branchID, _, _ := tenantContext(r)
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT ks.id, ks.branch_id, ks.code, ks.name, ks.is_default, COALESCE(ks.sort_order, 0),
+		       ks.created_at, ks.updated_at, ksc.category_id
+		FROM kitchen_stations ks
+		LEFT JOIN kitchen_station_categories ksc ON ksc.station_id = ks.id
+		LEFT JOIN categories c ON c.id = ksc.category_id
+		WHERE ks.branch_id = $1
+		ORDER BY ks.sort_order, ks.name, c.sort_order, c.name
+	`, branchID)
+	if err != nil {
+		log.Printf("Failed to list kitchen stations: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	stations := []*KitchenStation{}
+	byID := map[string]*KitchenStation{}
+	for rows.Next() {
+		var s KitchenStation
+		var categoryID sql.NullString
+		if err := rows.Scan(&s.ID, &s.BranchID, &s.Code, &s.Name, &s.IsDefault, &s.SortOrder,
+			&s.CreatedAt, &s.UpdatedAt, &categoryID); err != nil {
+			log.Printf("Scan kitchen station failed: %v", err)
+			continue
+		}
+		station, ok := byID[s.ID]
+		if !ok {
+			s.CategoryIDs = []string{}
+			station = &s
+			byID[s.ID] = station
+			stations = append(stations, station)
+		}
+		if categoryID.Valid {
+			station.CategoryIDs = append(station.CategoryIDs, categoryID.String)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"stations": stations})
+}
+
+func createKitchenStation(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	branchID, _, _ := tenantContext(r)
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	var req KitchenStationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if code := req.normalize(); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	exists, err := stationCodeExists(tx, branchID, req.Code, "")
+	if err != nil {
+		log.Printf("Failed to check station code: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "station_exists"})
+		return
+	}
+
+	var stationID string
+	err = tx.QueryRow(`
+		INSERT INTO kitchen_stations (branch_id, code, name, is_default, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, false, $4, NOW(), NOW())
+		RETURNING id
+	`, branchID, req.Code, req.Name, req.SortOrder).Scan(&stationID)
+	if err != nil {
+		log.Printf("Failed to create kitchen station: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if req.IsDefault {
+		if err := clearDefaultStation(tx, branchID, stationID); err == nil {
+			_, err = tx.Exec(`UPDATE kitchen_stations SET is_default = true WHERE id = $1`, stationID)
+		}
+		if err != nil {
+			log.Printf("Failed to set default station: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	saved, err := saveStationCategories(tx, branchID, stationID, req.CategoryIDs)
+	if err != nil {
+		log.Printf("Failed to save station categories: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if !saved {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category_not_found"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": stationID})
+}
+
+func updateKitchenStation(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	stationID := mux.Vars(r)["stationId"]
+	branchID, _, _ := tenantContext(r)
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	var req KitchenStationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if code := req.normalize(); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	exists, err := stationCodeExists(tx, branchID, req.Code, stationID)
+	if err != nil {
+		log.Printf("Failed to check station code: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "station_exists"})
+		return
+	}
+
+	if req.IsDefault {
+		if err := clearDefaultStation(tx, branchID, stationID); err != nil {
+			log.Printf("Failed to clear default station: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	res, err := tx.Exec(`
+		UPDATE kitchen_stations
+		SET code = $1, name = $2, is_default = $3, sort_order = $4, updated_at = NOW()
+		WHERE id = $5 AND branch_id = $6
+	`, req.Code, req.Name, req.IsDefault, req.SortOrder, stationID, branchID)
+	if err != nil {
+		log.Printf("Failed to update kitchen station: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "station_not_found"})
+		return
+	}
+
+	saved, err := saveStationCategories(tx, branchID, stationID, req.CategoryIDs)
+	if err != nil {
+		log.Printf("Failed to save station categories: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if !saved {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category_not_found"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+func deleteKitchenStation(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	stationID := mux.Vars(r)["stationId"]
+	branchID, _, _ := tenantContext(r)
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM kitchen_stations WHERE id = $1 AND branch_id = $2`, stationID, branchID)
+	if err != nil {
+		log.Printf("Failed to delete kitchen station: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "station_not_found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// listKitchenTickets returns the branch's active kitchen items grouped by order,
+// oldest first. ?station=<code> limits it to one station's items.
+func listKitchenTickets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	branchID, _, _ := tenantContext(r)
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+	station := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("station")))
+
+	query := `
+		SELECT order_id, order_number, order_code, table_number, order_notes, item_id, menu_item_name, quantity,
+		       item_notes, course, item_status, station_code, station_name, created_at, sent_at, prepared_at, minutes_waiting
+		FROM v_kitchen_display
+		WHERE branch_id = $1`
+	args := []any{branchID}
+
+	if station != "" {
+		exists, err := stationCodeExists(db, branchID, station, "")
+		if err != nil {
+			log.Printf("Failed to check station: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if !exists {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "station_not_found"})
+			return
+		}
+		query += ` AND station_code = $2`
+		args = append(args, station)
+	}
+	query += ` ORDER BY sent_at`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Failed to list kitchen tickets: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	tickets := []*KitchenTicket{}
+	byOrder := map[string]*KitchenTicket{}
+	for rows.Next() {
+		var t KitchenTicket
+		var item KitchenTicketItem
+		if err := rows.Scan(&t.OrderID, &t.OrderNumber, &t.OrderCode, &t.TableNumber, &t.Notes, &item.ItemID, &item.MenuItemName,
+			&item.Quantity, &item.Notes, &item.Course, &item.ItemStatus, &item.StationCode, &item.StationName,
+			&item.CreatedAt, &item.SentAt, &item.PreparedAt, &item.MinutesWaiting); err != nil {
+			log.Printf("Scan kitchen ticket failed: %v", err)
+			continue
+		}
+		ticket, ok := byOrder[t.OrderID]
+		if !ok {
+			ticket = &t
+			byOrder[t.OrderID] = ticket
+			tickets = append(tickets, ticket)
+		}
+		ticket.Items = append(ticket.Items, item)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"station": station, "tickets": tickets})
+}
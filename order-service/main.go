@@ -22,17 +22,28 @@ import (
 var notificationServiceURL string
 
 type Order struct {
-	ID             string    `json:"id"`
-	TableID        *int      `json:"table_id"`
-	OrderNumber    int       `json:"order_number"`
-	Status         string    `json:"status"`
-	Subtotal       float64   `json:"subtotal"`
-	Tax            float64   `json:"tax"`
-	DiscountAmount float64   `json:"discount_amount"`
-	TotalAmount    float64   `json:"total_amount"`
-	CreatedBy      string    `json:"created_by"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID              string     `json:"id"`
+	TableID         *int       `json:"table_id"`
+	OrderNumber     int        `json:"order_number"`
+	OrderCode       *string    `json:"order_code"`
+	OrderType       string     `json:"order_type"`
+	CustomerName    *string    `json:"customer_name"`
+	CustomerPhone   *string    `json:"customer_phone"`
+	DeliveryAddress *string    `json:"delivery_address"`
+	PromisedAt      *time.Time `json:"promised_at"`
+	PickedUpAt      *time.Time `json:"picked_up_at"`
+	Notes           *string    `json:"notes"`
+	Status          string     `json:"status"`
+	Subtotal        float64    `json:"subtotal"`
+	Tax             float64    `json:"tax"`
+	DiscountAmount  float64    `json:"discount_amount"`
+	ServiceCharge   float64    `json:"service_charge"`
+	TotalAmount     float64    `json:"total_amount"`
+	Version         int        `json:"version"`
+	MenuVersionID   *string    `json:"menu_version_id"` // published menu the order was placed from
+	CreatedBy       string     `json:"created_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 type QRSession struct {
@@ -45,40 +56,62 @@ type QRSession struct {
 	IsActive       bool       `json:"is_active"`
 	CreatedAt      time.Time  `json:"created_at"`
 	ClosedAt       *time.Time `json:"closed_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	LastOrderAt    *time.Time `json:"last_order_at"`
+	Expired        bool       `json:"expired"`
+	OrderingClosed bool       `json:"ordering_closed"`
+	MenuURL        string     `json:"menu_url,omitempty"`
+	MergedInto     *string    `json:"merged_into,omitempty"`
 }
 
 type OrderItem struct {
-	ID           string    `json:"id"`
-	OrderID      string    `json:"order_id"`
-	MenuItemID   string    `json:"menu_item_id"`
-	MenuItemName string    `json:"menu_item_name"`
-	Quantity     int       `json:"quantity"`
-	UnitPrice    float64   `json:"unit_price"`
-	ItemTotal    float64   `json:"item_total"`
-	ItemStatus   string    `json:"item_status"`
-	AddedBy      string    `json:"added_by"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           string              `json:"id"`
+	OrderID      string              `json:"order_id"`
+	MenuItemID   string              `json:"menu_item_id"`
+	MenuItemName string              `json:"menu_item_name"`
+	Quantity     int                 `json:"quantity"`
+	UnitPrice    float64             `json:"unit_price"`
+	ItemTotal    float64             `json:"item_total"`
+	Modifiers    []OrderItemModifier `json:"modifiers,omitempty"`
+	Notes        *string             `json:"notes"`
+	Course       int                 `json:"course"`
+	Held         bool                `json:"held"`
+	FiredAt      *time.Time          `json:"fired_at"`
+	ItemStatus   string              `json:"item_status"`
+	AddedBy      string              `json:"added_by"`
+	CreatedAt    time.Time           `json:"created_at"`
 }
 
 type CreateOrderRequest struct {
-	TableID        string            `json:"table_id"`
-	QrSessionToken string            `json:"qr_session_token"`
-	Items          []CreateOrderItem `json:"items"`
-	CreatedBy      string            `json:"created_by"`
+	TableID         string            `json:"table_id"`
+	QrSessionToken  string            `json:"qr_session_token"`
+	OrderType       string            `json:"order_type"` // default DINE_IN with a table, else TAKEAWAY
+	CustomerName    string            `json:"customer_name"`
+	CustomerPhone   string            `json:"customer_phone"`
+	DeliveryAddress string            `json:"delivery_address"`
+	PromisedAt      *time.Time        `json:"promised_at"`
+	Notes           string            `json:"notes"`
+	Items           []CreateOrderItem `json:"items"`
+	CreatedBy       string            `json:"created_by"`
 }
 
 type CreateOrderItem struct {
-	ItemName string  `json:"item_name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ProductID string   `json:"product_id"`
+	OptionIDs []string `json:"option_ids"`
+	Quantity  int      `json:"quantity"`
+	Notes     string   `json:"notes"`
+	Course    int      `json:"course"` // default 1
+	Held      bool     `json:"held"`   // wait for POST /api/orders/{id}/fire
 }
 
 type AddItemRequest struct {
-	MenuItemID   string  `json:"menu_item_id"`
-	MenuItemName string  `json:"menu_item_name"`
-	Quantity     int     `json:"quantity"`
-	UnitPrice    float64 `json:"unit_price"`
-	AddedBy      string  `json:"added_by"`
+	ProductID string   `json:"product_id"`
+	OptionIDs []string `json:"option_ids"`
+	Quantity  int      `json:"quantity"`
+	Notes     string   `json:"notes"`
+	Course    int      `json:"course"`
+	Held      bool     `json:"held"`
+	AddedBy   string   `json:"added_by"`
 }
 
 type SalesReport struct {
@@ -105,38 +138,43 @@ type HourlySales struct {
 type Product struct {
 	ID             string          `json:"id"`
 	OrganizationID string          `json:"organization_id"`
+	SKU            *string         `json:"sku"`
 	Name           string          `json:"name"`
 	Description    string          `json:"description"`
 	Price          float64         `json:"price"`
-	Category       string          `json:"category"`
+	CategoryID     *string         `json:"category_id"`
+	Category       string          `json:"category"` // name of the category, read-only
 	ImageURL       string          `json:"image_url"`
 	IsAvailable    bool            `json:"is_available"`
 	SortOrder      int             `json:"sort_order"`
 	CreatedAt      time.Time       `json:"created_at"`
 	UpdatedAt      time.Time       `json:"updated_at"`
 	Options        []ProductOption `json:"options,omitempty"`
+	OptionGroups   []OptionGroup   `json:"option_groups,omitempty"`
 }
 
 type ProductOption struct {
-	ID            string  `json:"id"`
-	ProductID     string  `json:"product_id"`
-	OptionGroup   string  `json:"option_group"`
-	OptionName    string  `json:"option_name"`
-	PriceModifier float64 `json:"price_modifier"`
-	IsRequired    bool    `json:"is_required"`
-	SortOrder     int     `json:"sort_order"`
+	ID            string    `json:"id"`
+	ProductID     string    `json:"product_id"`
+	OptionGroup   string    `json:"option_group"`
+	OptionName    string    `json:"option_name"`
+	PriceModifier float64   `json:"price_modifier"`
+	IsRequired    bool      `json:"is_required"`
+	SortOrder     int       `json:"sort_order"`
 	CreatedAt     time.Time `json:"created_at"`
 }
 
 type CreateProductRequest struct {
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Category    string  `json:"category"`
-	ImageURL    string  `json:"image_url"`
-	IsAvailable bool    `json:"is_available"`
-	SortOrder   int     `json:"sort_order"`
-	Options     []CreateProductOptionRequest `json:"options"`
+	SKU          string                       `json:"sku"`
+	Name         string                       `json:"name"`
+	Description  string                       `json:"description"`
+	Price        float64                      `json:"price"`
+	CategoryID   string                       `json:"category_id"`
+	ImageURL     string                       `json:"image_url"`
+	IsAvailable  bool                         `json:"is_available"`
+	SortOrder    int                          `json:"sort_order"`
+	Options      []CreateProductOptionRequest `json:"options"`
+	OptionGroups []OptionGroupRequest         `json:"option_groups"`
 }
 
 type CreateProductOptionRequest struct {
@@ -148,10 +186,11 @@ type CreateProductOptionRequest struct {
 }
 
 type UpdateProductRequest struct {
+	SKU         *string  `json:"sku"` // "" removes the SKU
 	Name        *string  `json:"name"`
 	Description *string  `json:"description"`
 	Price       *float64 `json:"price"`
-	Category    *string  `json:"category"`
+	CategoryID  *string  `json:"category_id"` // "" removes the category
 	ImageURL    *string  `json:"image_url"`
 	IsAvailable *bool    `json:"is_available"`
 	SortOrder   *int     `json:"sort_order"`
@@ -246,26 +285,57 @@ func guardOrderScope(db *sql.DB, orderID, branchID, orgID string) error {
 	return nil
 }
 
-// findActiveQRSession fetches a QR session by token.
-func findActiveQRSession(db *sql.DB, token string) (*QRSession, error) {
+// qrSessionColumns selects a QR session with its table and tenant; the window
+// flags are evaluated against the database clock.
+const qrSessionColumns = `
+		qs.id, qs.qr_code_token, qs.table_id, qs.is_active, qs.created_at, qs.closed_at,
+		qs.expires_at, qs.last_order_at, qs.merged_into,
+		(qs.expired_at IS NOT NULL OR COALESCE(qs.expires_at <= NOW(), false)) AS expired,
+		COALESCE(qs.last_order_at <= NOW(), false) AS ordering_closed,
+		t.table_number, b.id AS branch_id, b.organization_id`
+
+func scanQRSession(row interface{ Scan(...any) error }) (*QRSession, error) {
 	var sess QRSession
-	var closedAt sql.NullTime
-	row := db.QueryRow(`
-		SELECT qs.id, qs.qr_code_token, qs.table_id, qs.is_active, qs.created_at, qs.closed_at,
-		       t.table_number, b.id AS branch_id, b.organization_id
+	err := row.Scan(&sess.ID, &sess.Token, &sess.TableID, &sess.IsActive, &sess.CreatedAt, &sess.ClosedAt,
+		&sess.ExpiresAt, &sess.LastOrderAt, &sess.MergedInto, &sess.Expired, &sess.OrderingClosed,
+		&sess.TableNumber, &sess.BranchID, &sess.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	sess.OrderingClosed = sess.OrderingClosed || sess.Expired
+	return &sess, nil
+}
+
+// findActiveQRSession fetches a QR session by token. A session merged into
+// another table resolves to the surviving session. An open session past its
+// lifetime is returned together with errQRSessionExpired.
+func findActiveQRSession(db *sql.DB, token string) (*QRSession, error) {
+	sess, err := scanQRSession(db.QueryRow(`
+		SELECT `+qrSessionColumns+`
 		FROM qr_sessions qs
 		JOIN tables t ON qs.table_id = t.id
 		JOIN branches b ON t.branch_id = b.id
 		WHERE qs.qr_code_token = $1
-	`, token)
-	err := row.Scan(&sess.ID, &sess.Token, &sess.TableID, &sess.IsActive, &sess.CreatedAt, &closedAt, &sess.TableNumber, &sess.BranchID, &sess.OrganizationID)
+	`, token))
 	if err != nil {
 		return nil, err
 	}
-	if closedAt.Valid {
-		sess.ClosedAt = &closedAt.Time
+	for hops := 0; !sess.IsActive && sess.MergedInto != nil && hops < maxMergeHops; hops++ {
+		sess, err = scanQRSession(db.QueryRow(`
+			SELECT `+qrSessionColumns+`
+			FROM qr_sessions qs
+			JOIN tables t ON qs.table_id = t.id
+			JOIN branches b ON t.branch_id = b.id
+			WHERE qs.id = $1
+		`, *sess.MergedInto))
+		if err != nil {
+			return nil, err
+		}
 	}
-	return &sess, nil
+	if sess.IsActive && sess.Expired {
+		return sess, errQRSessionExpired
+	}
+	return sess, nil
 }
 
 // ensureTable returns an existing table id by number or creates it.
@@ -317,16 +387,26 @@ func createQRSession(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	token := uuid.New().String()
-	var sessID string
+	var sessID, menuBaseURL string
+	var expiresAt, lastOrderAt *time.Time
 	err = db.QueryRow(`
-		INSERT INTO qr_sessions (id, table_id, qr_code_token, is_active, created_at)
-		VALUES ($1, $2, $3, true, NOW()) RETURNING id
-	`, uuid.New().String(), tableID, token).Scan(&sessID)
+		INSERT INTO qr_sessions (id, table_id, qr_code_token, is_active, created_at, expires_at, last_order_at)
+		SELECT $1, $2, $3, true, NOW(),
+		       CASE WHEN b.session_lifetime_minutes > 0
+		            THEN NOW() + make_interval(mins => b.session_lifetime_minutes) END,
+		       CASE WHEN b.session_lifetime_minutes > 0
+		            THEN NOW() + make_interval(mins => GREATEST(b.session_lifetime_minutes - b.last_order_minutes, 0)) END
+		FROM branches b WHERE b.id = $4
+		RETURNING id, expires_at, last_order_at
+	`, uuid.New().String(), tableID, token, branchID).Scan(&sessID, &expiresAt, &lastOrderAt)
 	if err != nil {
 		log.Printf("Failed to create qr session: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
+	if err := db.QueryRow(`SELECT menu_base_url FROM branches WHERE id = $1`, branchID).Scan(&menuBaseURL); err != nil {
+		log.Printf("Failed to get branch menu url: %v", err)
+	}
 
 	response := QRSession{
 		ID:             sessID,
@@ -337,6 +417,9 @@ func createQRSession(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		OrganizationID: orgID,
 		IsActive:       true,
 		CreatedAt:      time.Now(),
+		ExpiresAt:      expiresAt,
+		LastOrderAt:    lastOrderAt,
+		MenuURL:        customerMenuURL(menuBaseURL, token, payload.TableNumber),
 	}
 
 	publishEvent("qr_session_opened", map[string]any{
@@ -361,8 +444,7 @@ func listQRSessions(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	query := `
-		SELECT qs.id, qs.qr_code_token, qs.table_id, qs.is_active, qs.created_at, qs.closed_at,
-		       t.table_number, b.id AS branch_id, b.organization_id
+		SELECT ` + qrSessionColumns + `
 		FROM qr_sessions qs
 		JOIN tables t ON qs.table_id = t.id
 		JOIN branches b ON t.branch_id = b.id
@@ -398,16 +480,12 @@ func listQRSessions(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	var sessions []QRSession
 	for rows.Next() {
-		var sess QRSession
-		var closedAt sql.NullTime
-		if err := rows.Scan(&sess.ID, &sess.Token, &sess.TableID, &sess.IsActive, &sess.CreatedAt, &closedAt, &sess.TableNumber, &sess.BranchID, &sess.OrganizationID); err != nil {
+		sess, err := scanQRSession(rows)
+		if err != nil {
 			log.Printf("Scan qr session failed: %v", err)
 			continue
 		}
-		if closedAt.Valid {
-			sess.ClosedAt = &closedAt.Time
-		}
-		sessions = append(sessions, sess)
+		sessions = append(sessions, *sess)
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
@@ -420,46 +498,16 @@ func getQRSessionByToken(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session_not_found"})
 		return
 	}
-	if err != nil {
-		log.Printf("Failed to get qr session: %v", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
-		return
-	}
-	writeJSON(w, http.StatusOK, sess)
-}
-
-func closeQRSession(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
-	branchID, orgID, userID := tenantContext(r)
-	if branchID == "" && orgID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+	if err == errQRSessionExpired {
+		writeJSON(w, http.StatusGone, map[string]any{"error": "qr_session_expired", "session": sess})
 		return
 	}
-
-	res, err := db.Exec(`
-		UPDATE qr_sessions SET is_active = false, closed_at = NOW()
-		WHERE id = $1 AND is_active = true AND EXISTS (
-			SELECT 1 FROM tables t JOIN branches b ON t.branch_id = b.id
-			WHERE t.id = qr_sessions.table_id AND (b.id = $2 OR b.organization_id = $3)
-		)
-	`, id, branchID, orgID)
 	if err != nil {
-		log.Printf("Failed to close qr session: %v", err)
+		log.Printf("Failed to get qr session: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session_not_found"})
-		return
-	}
-
-	publishEvent("qr_session_closed", map[string]any{
-		"qr_session_id": id,
-		"closed_by":     userID,
-	}, branchID, orgID)
-
-	writeJSON(w, http.StatusOK, map[string]string{"status": "closed"})
+	writeJSON(w, http.StatusOK, sess)
 }
 
 func main() {
@@ -495,6 +543,8 @@ func main() {
 		log.Fatalf("DB ping failed: %v", err)
 	}
 
+	go runSessionSweeper(db, sessionSweepInterval())
+
 	router := mux.NewRouter()
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -509,6 +559,22 @@ func main() {
 		listOrders(db, w, r)
 	}).Methods(http.MethodGet)
 
+	router.HandleFunc("/api/orders/note-presets", func(w http.ResponseWriter, r *http.Request) {
+		listNotePresets(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/orders/note-presets", func(w http.ResponseWriter, r *http.Request) {
+		createNotePreset(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/orders/note-presets/{presetId}", func(w http.ResponseWriter, r *http.Request) {
+		deleteNotePreset(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/orders/pickup-queue", func(w http.ResponseWriter, r *http.Request) {
+		getPickupQueue(db, w, r)
+	}).Methods(http.MethodGet)
+
 	router.HandleFunc("/api/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
 		getOrder(db, w, r)
 	}).Methods(http.MethodGet)
@@ -521,10 +587,79 @@ func main() {
 		removeOrderItem(db, w, r)
 	}).Methods(http.MethodDelete)
 
+	router.HandleFunc("/api/orders/{id}/items/{itemId}/void", func(w http.ResponseWriter, r *http.Request) {
+		voidOrderItem(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/orders/{id}/items/{itemId}/comp", func(w http.ResponseWriter, r *http.Request) {
+		compOrderItem(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/orders/{id}/items/{itemId}/status", func(w http.ResponseWriter, r *http.Request) {
+		updateOrderItemStatus(db, w, r)
+	}).Methods(http.MethodPut)
+
+	router.HandleFunc("/api/orders/{id}/bump", func(w http.ResponseWriter, r *http.Request) {
+		bumpOrderItems(db, w, r)
+	}).Methods(http.MethodPost)
+
 	router.HandleFunc("/api/orders/{id}/status", func(w http.ResponseWriter, r *http.Request) {
 		updateOrderStatus(db, w, r)
 	}).Methods(http.MethodPut)
 
+	router.HandleFunc("/api/orders/{id}/status-history", func(w http.ResponseWriter, r *http.Request) {
+		getOrderStatusHistory(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/orders/{id}/collect", func(w http.ResponseWriter, r *http.Request) {
+		collectOrder(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/orders/{id}/ticket", func(w http.ResponseWriter, r *http.Request) {
+		getKitchenTicket(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/orders/{id}/fire", func(w http.ResponseWriter, r *http.Request) {
+		fireCourse(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/orders/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		getOrderTimeline(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/orders/{id}/adjustments", func(w http.ResponseWriter, r *http.Request) {
+		listOrderAdjustments(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/orders/{id}/adjustments", func(w http.ResponseWriter, r *http.Request) {
+		createOrderAdjustment(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/orders/{id}/adjustments/{adjustmentId}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		cancelOrderAdjustment(db, w, r)
+	}).Methods(http.MethodPost)
+
+	// Kitchen
+	router.HandleFunc("/api/kitchen/tickets", func(w http.ResponseWriter, r *http.Request) {
+		listKitchenTickets(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/kitchen/stations", func(w http.ResponseWriter, r *http.Request) {
+		listKitchenStations(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/kitchen/stations", func(w http.ResponseWriter, r *http.Request) {
+		createKitchenStation(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/kitchen/stations/{stationId}", func(w http.ResponseWriter, r *http.Request) {
+		updateKitchenStation(db, w, r)
+	}).Methods(http.MethodPut)
+
+	router.HandleFunc("/api/kitchen/stations/{stationId}", func(w http.ResponseWriter, r *http.Request) {
+		deleteKitchenStation(db, w, r)
+	}).Methods(http.MethodDelete)
+
 	// QR Sessions
 	router.HandleFunc("/api/qr-sessions", func(w http.ResponseWriter, r *http.Request) {
 		createQRSession(db, w, r)
@@ -534,10 +669,34 @@ func main() {
 		listQRSessions(db, w, r)
 	}).Methods(http.MethodGet)
 
+	router.HandleFunc("/api/qr-sessions/{id}/bill", func(w http.ResponseWriter, r *http.Request) {
+		getSessionBill(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/qr-sessions/{id}/qr.png", func(w http.ResponseWriter, r *http.Request) {
+		getSessionQRPNG(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/qr-sessions/{id}/qr.svg", func(w http.ResponseWriter, r *http.Request) {
+		getSessionQRSVG(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/qr-sessions/{id}/tent", func(w http.ResponseWriter, r *http.Request) {
+		getSessionTableTent(db, w, r)
+	}).Methods(http.MethodGet)
+
 	router.HandleFunc("/api/qr-sessions/{id}/close", func(w http.ResponseWriter, r *http.Request) {
 		closeQRSession(db, w, r)
 	}).Methods(http.MethodPut)
 
+	router.HandleFunc("/api/tables/transfer", func(w http.ResponseWriter, r *http.Request) {
+		transferTable(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/tables/merge", func(w http.ResponseWriter, r *http.Request) {
+		mergeTables(db, w, r)
+	}).Methods(http.MethodPost)
+
 	router.HandleFunc("/api/qr-sessions/token/{token}", func(w http.ResponseWriter, r *http.Request) {
 		getQRSessionByToken(db, w, r)
 	}).Methods(http.MethodGet)
@@ -551,6 +710,15 @@ func main() {
 		createProduct(db, w, r)
 	}).Methods(http.MethodPost)
 
+	// Bulk catalog import/export; before {id} so the paths are not taken for IDs
+	router.HandleFunc("/api/products/export", func(w http.ResponseWriter, r *http.Request) {
+		exportCatalog(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/products/import", func(w http.ResponseWriter, r *http.Request) {
+		importCatalog(db, w, r)
+	}).Methods(http.MethodPost)
+
 	router.HandleFunc("/api/products/{id}", func(w http.ResponseWriter, r *http.Request) {
 		getProduct(db, w, r)
 	}).Methods(http.MethodGet)
@@ -563,6 +731,93 @@ func main() {
 		deleteProduct(db, w, r)
 	}).Methods(http.MethodDelete)
 
+	router.HandleFunc("/api/products/{id}/option-groups", func(w http.ResponseWriter, r *http.Request) {
+		listOptionGroups(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/products/{id}/option-groups", func(w http.ResponseWriter, r *http.Request) {
+		createOptionGroup(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/products/{id}/option-groups/{groupId}", func(w http.ResponseWriter, r *http.Request) {
+		updateOptionGroup(db, w, r)
+	}).Methods(http.MethodPut)
+
+	router.HandleFunc("/api/products/{id}/option-groups/{groupId}", func(w http.ResponseWriter, r *http.Request) {
+		deleteOptionGroup(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/products/{id}/branch-overrides", func(w http.ResponseWriter, r *http.Request) {
+		listBranchOverrides(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/products/{id}/branch-overrides/{branchId}", func(w http.ResponseWriter, r *http.Request) {
+		putBranchOverride(db, w, r)
+	}).Methods(http.MethodPut)
+
+	router.HandleFunc("/api/products/{id}/branch-overrides/{branchId}", func(w http.ResponseWriter, r *http.Request) {
+		deleteBranchOverride(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	// Categories endpoints
+	router.HandleFunc("/api/categories", func(w http.ResponseWriter, r *http.Request) {
+		listCategories(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/categories", func(w http.ResponseWriter, r *http.Request) {
+		createCategory(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/categories/{id}", func(w http.ResponseWriter, r *http.Request) {
+		getCategory(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/categories/{id}", func(w http.ResponseWriter, r *http.Request) {
+		updateCategory(db, w, r)
+	}).Methods(http.MethodPut)
+
+	router.HandleFunc("/api/categories/{id}", func(w http.ResponseWriter, r *http.Request) {
+		deleteCategory(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	// Menu schedule endpoints
+	router.HandleFunc("/api/menus", func(w http.ResponseWriter, r *http.Request) {
+		listMenus(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/menus", func(w http.ResponseWriter, r *http.Request) {
+		createMenu(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/menus/{id}", func(w http.ResponseWriter, r *http.Request) {
+		getMenu(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/menus/{id}", func(w http.ResponseWriter, r *http.Request) {
+		updateMenu(db, w, r)
+	}).Methods(http.MethodPut)
+
+	router.HandleFunc("/api/menus/{id}", func(w http.ResponseWriter, r *http.Request) {
+		deleteMenu(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	// Menu version endpoints (publish / rollback)
+	router.HandleFunc("/api/menu-versions", func(w http.ResponseWriter, r *http.Request) {
+		listMenuVersions(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/menu-versions", func(w http.ResponseWriter, r *http.Request) {
+		publishMenu(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/menu-versions/{version}", func(w http.ResponseWriter, r *http.Request) {
+		getMenuVersion(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/menu-versions/{version}/rollback", func(w http.ResponseWriter, r *http.Request) {
+		rollbackMenu(db, w, r)
+	}).Methods(http.MethodPost)
+
 	// Reports endpoints
 	router.HandleFunc("/api/reports/sales", func(w http.ResponseWriter, r *http.Request) {
 		getSalesReport(db, w, r)
@@ -572,6 +827,10 @@ func main() {
 		getTopItems(db, w, r)
 	}).Methods(http.MethodGet)
 
+	router.HandleFunc("/api/reports/voids", func(w http.ResponseWriter, r *http.Request) {
+		getVoidsReport(db, w, r)
+	}).Methods(http.MethodGet)
+
 	router.HandleFunc("/api/reports/hourly-sales", func(w http.ResponseWriter, r *http.Request) {
 		getHourlySales(db, w, r)
 	}).Methods(http.MethodGet)
@@ -624,6 +883,10 @@ func createOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_qr_session"})
 			return
 		}
+		if err == errQRSessionExpired {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "qr_session_expired"})
+			return
+		}
 		if err != nil {
 			log.Printf("Failed to fetch qr session: %v", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
@@ -633,6 +896,10 @@ func createOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "qr_session_closed"})
 			return
 		}
+		if sess.OrderingClosed {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "last_order_passed"})
+			return
+		}
 		qrSessionID = &sess.ID
 		tableID = &sess.TableID
 		branchID = sess.BranchID
@@ -650,26 +917,18 @@ func createOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var orderNumber int
-	if branchID != "" {
-		err = db.QueryRow("SELECT COALESCE(MAX(order_number), 0) + 1 FROM orders WHERE branch_id = $1", branchID).Scan(&orderNumber)
-	} else if orgID != "" {
-		err = db.QueryRow("SELECT COALESCE(MAX(order_number), 0) + 1 FROM orders WHERE organization_id = $1", orgID).Scan(&orderNumber)
-	} else {
-		err = db.QueryRow("SELECT COALESCE(MAX(order_number), 0) + 1 FROM orders").Scan(&orderNumber)
+	orderType, code := req.normalizeOrderType(tableID != nil)
+	if code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
 	}
-	if err != nil {
-		log.Printf("Failed to get order number: %v", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+	var noteOK bool
+	if req.Notes, noteOK = cleanNote(req.Notes, maxOrderNoteLength); !noteOK {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "note_too_long", "max_length": maxOrderNoteLength})
 		return
 	}
 
 	orderID := uuid.New().String()
-	subtotal := 0.0
-	for _, item := range req.Items {
-		subtotal += item.Price * float64(item.Quantity)
-	}
-	tax := subtotal * 0.15
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -679,10 +938,85 @@ func createOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	orderNumber, orderCode, err := nextOrderNumber(tx, branchID, orgID)
+	if err != nil {
+		log.Printf("Failed to get order number: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	// Price every line from the live menu; client-supplied prices are never trusted.
+	menu, err := loadPublishedMenu(tx, orgID)
+	if err != nil {
+		log.Printf("Failed to load published menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	var menuVersionID string
+	if menu != nil {
+		menuVersionID = menu.ID
+		productIDs := make([]string, len(req.Items))
+		for i, item := range req.Items {
+			productIDs[i] = item.ProductID
+		}
+		if err := menu.preloadAvailability(tx, productIDs, branchID); err != nil {
+			log.Printf("Failed to load product availability: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+	lines := make([]*pricedLine, 0, len(req.Items))
+	var lineErrors []*orderLineError
+	subtotal := 0.0
+	for i, item := range req.Items {
+		notes, ok := cleanNote(item.Notes, maxItemNoteLength)
+		if !ok {
+			lineErrors = append(lineErrors, &orderLineError{Code: "note_too_long", ItemIndex: i, ProductID: item.ProductID})
+			continue
+		}
+		course, ok := normalizeCourse(item.Course)
+		if !ok {
+			lineErrors = append(lineErrors, &orderLineError{Code: "invalid_course", ItemIndex: i, ProductID: item.ProductID})
+			continue
+		}
+		line, err := priceOrderLine(tx, orgID, branchID, menu, i, item.ProductID, item.OptionIDs, item.Quantity)
+		if lineErr, ok := err.(*orderLineError); ok {
+			lineErrors = append(lineErrors, lineErr)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to price order item: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		onMenu, err := productOnMenu(tx, item.ProductID, branchID)
+		if err != nil {
+			log.Printf("Failed to check menu schedule: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if !onMenu {
+			lineErrors = append(lineErrors, &orderLineError{Code: "product_off_menu", ItemIndex: i, ProductID: item.ProductID})
+			continue
+		}
+		line.Notes = notes
+		line.Course, line.Held = course, item.Held
+		lines = append(lines, line)
+		subtotal += line.ItemTotal
+	}
+	if len(lineErrors) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_items", "items": lineErrors})
+		return
+	}
+
 	_, err = tx.Exec(`
-		INSERT INTO orders (id, organization_id, branch_id, table_id, qr_session_id, order_number, status, subtotal, tax, total_amount, created_by, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, 'OPEN', $7, $8, $9, $10, NOW(), NOW())
-	`, orderID, nullable(orgID), nullable(branchID), tableID, nullablePtr(qrSessionID), orderNumber, subtotal, tax, subtotal+tax, nullable(req.CreatedBy))
+		INSERT INTO orders (id, organization_id, branch_id, table_id, qr_session_id, order_number, order_code,
+		                    order_type, customer_name, customer_phone, delivery_address, promised_at, notes,
+		                    status, subtotal, menu_version_id, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, 'OPEN', $14, $15, $16, NOW(), NOW())
+	`, orderID, nullable(orgID), nullable(branchID), tableID, nullablePtr(qrSessionID), orderNumber, nullablePtr(orderCode),
+		orderType, nullable(req.CustomerName), nullable(req.CustomerPhone), nullable(req.DeliveryAddress), req.PromisedAt,
+		nullable(req.Notes), subtotal, nullable(menuVersionID), nullable(req.CreatedBy))
 
 	if err != nil {
 		log.Printf("Failed to create order: %v", err)
@@ -690,18 +1024,59 @@ func createOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, item := range req.Items {
+	ticketItems := make([]map[string]any, 0, len(lines))
+	for _, line := range lines {
+		modifiers, err := line.modifiersJSON()
+		if err != nil {
+			log.Printf("Failed to encode modifiers: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
 		itemID := uuid.New().String()
-		itemTotal := item.Price * float64(item.Quantity)
-		_, err := tx.Exec(`
-			INSERT INTO order_items (id, order_id, menu_item_id, menu_item_name, quantity, unit_price, item_total, item_status, added_by, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, 'PENDING', $8, NOW())
-		`, itemID, orderID, uuid.New().String(), item.ItemName, item.Quantity, item.Price, itemTotal, nullable(req.CreatedBy))
+		_, err = tx.Exec(`
+			INSERT INTO order_items (id, order_id, menu_item_id, menu_item_name, quantity, unit_price, item_total, modifiers, notes,
+			                         course, held, menu_version_id, item_status, added_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, 'PENDING', $13, NOW())
+		`, itemID, orderID, line.ProductID, line.ProductName, line.Quantity, line.UnitPrice, line.ItemTotal, modifiers,
+			nullable(line.Notes), line.Course, line.Held, nullable(line.MenuVersionID), nullable(req.CreatedBy))
 		if err != nil {
 			log.Printf("Failed to create order item: %v", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 			return
 		}
+		if err := recordOrderEvent(tx, orderID, eventItemAdded, userID, nil, line.eventSnapshot(itemID)); err != nil {
+			log.Printf("Failed to record order event: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		// Held items reach the kitchen when their course is fired.
+		if !line.Held {
+			ticketItems = append(ticketItems, line.ticketItem(itemID))
+		}
+	}
+
+	totals, err := recalculateOrderTotals(tx, orderID)
+	if err != nil {
+		log.Printf("Failed to calculate order totals: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	err = recordOrderEvent(tx, orderID, eventOrderCreated, userID, nil, map[string]any{
+		"order_number":  orderNumber,
+		"order_code":    orderCode,
+		"order_type":    orderType,
+		"table_id":      tableID,
+		"customer_name": nullable(req.CustomerName),
+		"promised_at":   req.PromisedAt,
+		"notes":         nullable(req.Notes),
+		"status":        statusOpen,
+		"totals":        totals,
+	})
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
 	}
 
 	if err = tx.Commit(); err != nil {
@@ -710,19 +1085,44 @@ func createOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stations, err := orderItemStations(db, orderID)
+	if err != nil {
+		log.Printf("Failed to route order to kitchen stations: %v", err)
+	}
+	routed := map[string]string{}
+	for _, item := range ticketItems {
+		itemID := item["item_id"].(string)
+		item["station"] = stations[itemID]
+		if code, ok := stations[itemID]; ok {
+			routed[itemID] = code
+		}
+	}
+
 	// Publish event
-	publishEvent("order_created", map[string]interface{}{
+	publishStationEvent("order_created", map[string]interface{}{
 		"order_id":     orderID,
 		"order_number": orderNumber,
+		"order_code":   orderCode,
 		"status":       "OPEN",
-		"total_amount": subtotal + tax,
-	}, branchID, orgID)
+		"total_amount": totals.TotalAmount,
+		"notes":        nullable(req.Notes),
+		"items":        ticketItems,
+		"held_items":   len(lines) - len(ticketItems),
+	}, distinctStations(routed), branchID, orgID)
+	if orderType != orderTypeDineIn {
+		publishPickupUpdate(db, orderID, orgID)
+	}
 
 	writeJSON(w, http.StatusCreated, map[string]any{
-		"order_id":     orderID,
-		"order_number": orderNumber,
-		"status":       "OPEN",
-		"total_amount": subtotal + tax,
+		"order_id":       orderID,
+		"order_number":   orderNumber,
+		"order_code":     orderCode,
+		"order_type":     orderType,
+		"status":         "OPEN",
+		"subtotal":       totals.Subtotal,
+		"service_charge": totals.ServiceCharge,
+		"tax":            totals.Tax,
+		"total_amount":   totals.TotalAmount,
 	})
 }
 
@@ -731,8 +1131,9 @@ func getOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	branchID, orgID, _ := tenantContext(r)
 
 	query := `
-		SELECT id, table_id, order_number, status, subtotal, tax, discount_amount, 
-		       total_amount, created_by, created_at, updated_at
+		SELECT id, table_id, order_number, order_code, order_type, customer_name, customer_phone, delivery_address,
+		       promised_at, picked_up_at, notes, status, subtotal, tax, discount_amount,
+		       service_charge, total_amount, version, menu_version_id, created_by, created_at, updated_at
 		FROM orders WHERE id = $1`
 	args := []interface{}{id}
 
@@ -746,9 +1147,10 @@ func getOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	var order Order
 	err := db.QueryRow(query, args...).Scan(
-		&order.ID, &order.TableID, &order.OrderNumber, &order.Status,
-		&order.Subtotal, &order.Tax, &order.DiscountAmount, &order.TotalAmount,
-		&order.CreatedBy, &order.CreatedAt, &order.UpdatedAt,
+		&order.ID, &order.TableID, &order.OrderNumber, &order.OrderCode, &order.OrderType, &order.CustomerName,
+		&order.CustomerPhone, &order.DeliveryAddress, &order.PromisedAt, &order.PickedUpAt, &order.Notes, &order.Status,
+		&order.Subtotal, &order.Tax, &order.DiscountAmount, &order.ServiceCharge, &order.TotalAmount,
+		&order.Version, &order.MenuVersionID, &order.CreatedBy, &order.CreatedAt, &order.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -763,7 +1165,7 @@ func getOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	rows, err := db.Query(`
 		SELECT id, menu_item_id, menu_item_name, quantity, unit_price, item_total, 
-		       item_status, added_by, created_at
+		       modifiers, notes, course, held, fired_at, item_status, added_by, created_at
 		FROM order_items WHERE order_id = $1 ORDER BY created_at DESC
 	`, id)
 	if err != nil {
@@ -776,12 +1178,18 @@ func getOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	var items []OrderItem
 	for rows.Next() {
 		var item OrderItem
+		var modifiers []byte
 		item.OrderID = id
 		rows.Scan(&item.ID, &item.MenuItemID, &item.MenuItemName, &item.Quantity,
-			&item.UnitPrice, &item.ItemTotal, &item.ItemStatus, &item.AddedBy, &item.CreatedAt)
+			&item.UnitPrice, &item.ItemTotal, &modifiers, &item.Notes, &item.Course, &item.Held, &item.FiredAt,
+			&item.ItemStatus, &item.AddedBy, &item.CreatedAt)
+		if len(modifiers) > 0 {
+			_ = json.Unmarshal(modifiers, &item.Modifiers)
+		}
 		items = append(items, item)
 	}
 
+	w.Header().Set("ETag", orderETag(order.Version))
 	writeJSON(w, http.StatusOK, map[string]any{
 		"order": order,
 		"items": items,
@@ -806,6 +1214,16 @@ func addOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
 		return
 	}
+	notes, ok := cleanNote(req.Notes, maxItemNoteLength)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "note_too_long", "max_length": maxItemNoteLength})
+		return
+	}
+	course, ok := normalizeCourse(req.Course)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_course", "max_course": maxCourse})
+		return
+	}
 
 	if req.AddedBy == "" {
 		if userID != "" {
@@ -815,7 +1233,6 @@ func addOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	itemTotal := float64(req.Quantity) * req.UnitPrice
 	itemID := uuid.New().String()
 
 	tx, err := db.Begin()
@@ -826,151 +1243,101 @@ func addOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(`
-		INSERT INTO order_items (id, order_id, menu_item_id, menu_item_name, quantity, 
-		                         unit_price, item_total, item_status, added_by, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, 'PENDING', $8, NOW())
-	`, itemID, orderID, req.MenuItemID, req.MenuItemName, req.Quantity, req.UnitPrice, itemTotal, req.AddedBy)
-
-	if err != nil {
-		log.Printf("Failed to add item: %v", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+	if !checkOrderVersion(tx, w, r, orderID) {
 		return
 	}
 
-	_, err = tx.Exec(`
-		UPDATE orders 
-		SET subtotal = subtotal + $1,
-		    total_amount = total_amount + $1,
-		    updated_at = NOW()
-		WHERE id = $2
-	`, itemTotal, orderID)
-
-	if err != nil {
-		log.Printf("Failed to update order totals: %v", err)
+	var orderOrgID, orderBranchID sql.NullString
+	var orderStatus string
+	if err := tx.QueryRow(`
+		SELECT organization_id, branch_id, status FROM orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&orderOrgID, &orderBranchID, &orderStatus); err != nil {
+		log.Printf("Failed to lock order: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
-
-	if err = tx.Commit(); err != nil {
-		log.Printf("Failed to commit: %v", err)
+	// A paid bill only changes through adjustments.
+	if orderStatus != statusOpen && orderStatus != statusConfirmed {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "order_closed", "status": orderStatus})
+		return
+	}
+	// Shares already paid would no longer add up to the bill.
+	if splitID, err := activeSplitCovering(tx, []string{orderID}); err != nil {
+		log.Printf("Failed to check bill splits: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
+	} else if splitID != "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_in_progress", "split_id": splitID})
+		return
 	}
 
-	writeJSON(w, http.StatusCreated, map[string]any{
-		"id":         itemID,
-		"order_id":   orderID,
-		"item_total": itemTotal,
-	})
-}
-
-func listOrders(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	status := r.URL.Query().Get("status")
-	tableID := r.URL.Query().Get("table_id")
-	branchID, orgID, _ := tenantContext(r)
-
-	query := `SELECT id, table_id, order_number, status, subtotal, tax, discount_amount, 
-	       total_amount, created_by, created_at, updated_at FROM orders WHERE 1=1`
-	var args []interface{}
-
-	if status != "" {
-		query += ` AND status = $` + fmt.Sprintf("%d", len(args)+1)
-		args = append(args, status)
-	}
-	if tableID != "" {
-		query += ` AND table_id = $` + fmt.Sprintf("%d", len(args)+1)
-		args = append(args, tableID)
+	// Added items are priced against the menu live now, which may be newer
+	// than the one the order was placed from.
+	menu, err := loadPublishedMenu(tx, orderOrgID.String)
+	if err != nil {
+		log.Printf("Failed to load published menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
 	}
-	if branchID != "" {
-		query += ` AND branch_id = $` + fmt.Sprintf("%d", len(args)+1)
-		args = append(args, branchID)
-	} else if orgID != "" {
-		query += ` AND organization_id = $` + fmt.Sprintf("%d", len(args)+1)
-		args = append(args, orgID)
+	line, err := priceOrderLine(tx, orderOrgID.String, orderBranchID.String, menu, 0, req.ProductID, req.OptionIDs, req.Quantity)
+	if lineErr, ok := err.(*orderLineError); ok {
+		writeJSON(w, http.StatusBadRequest, lineErr)
+		return
 	}
-
-	query += ` ORDER BY created_at DESC LIMIT 100`
-
-	rows, err := db.Query(query, args...)
 	if err != nil {
-		log.Printf("Failed to list orders: %v", err)
+		log.Printf("Failed to price item: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
-	defer rows.Close()
-
-	var orders []Order
-	for rows.Next() {
-		var order Order
-		rows.Scan(&order.ID, &order.TableID, &order.OrderNumber, &order.Status,
-			&order.Subtotal, &order.Tax, &order.DiscountAmount, &order.TotalAmount,
-			&order.CreatedBy, &order.CreatedAt, &order.UpdatedAt)
-		orders = append(orders, order)
-	}
-
-	writeJSON(w, http.StatusOK, map[string]any{"orders": orders})
-}
-
-func removeOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	orderID := vars["id"]
-	itemID := vars["itemId"]
-	branchID, orgID, _ := tenantContext(r)
-	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
-		if err == sql.ErrNoRows {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
-			return
-		}
-		log.Printf("Scope check failed: %v", err)
+	onMenu, err := productOnMenu(tx, req.ProductID, orderBranchID.String)
+	if err != nil {
+		log.Printf("Failed to check menu schedule: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
-
-	var itemTotal float64
-	err := db.QueryRow(`
-		SELECT oi.item_total FROM order_items oi
-		JOIN orders o ON oi.order_id = o.id
-		WHERE oi.id = $1 AND oi.order_id = $2
-	`, itemID, orderID).Scan(&itemTotal)
-	if err == sql.ErrNoRows {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "item_not_found"})
+	if !onMenu {
+		writeJSON(w, http.StatusBadRequest, &orderLineError{Code: "product_off_menu", ProductID: req.ProductID})
 		return
 	}
+	itemTotal := line.ItemTotal
+	line.Notes = notes
+	line.Course, line.Held = course, req.Held
+
+	modifiers, err := line.modifiersJSON()
 	if err != nil {
-		log.Printf("Failed to get item: %v", err)
+		log.Printf("Failed to encode modifiers: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
 
-	tx, err := db.Begin()
+	_, err = tx.Exec(`
+		INSERT INTO order_items (id, order_id, menu_item_id, menu_item_name, quantity, 
+		                         unit_price, item_total, modifiers, notes, course, held, menu_version_id, item_status, added_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, 'PENDING', $13, NOW())
+	`, itemID, orderID, line.ProductID, line.ProductName, line.Quantity, line.UnitPrice, itemTotal, modifiers,
+		nullable(line.Notes), line.Course, line.Held, nullable(line.MenuVersionID), req.AddedBy)
+
 	if err != nil {
-		log.Printf("Failed to start transaction: %v", err)
+		log.Printf("Failed to add item: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`DELETE FROM order_items WHERE id = $1`, itemID)
+	totals, err := recalculateOrderTotals(tx, orderID)
 	if err != nil {
-		log.Printf("Failed to delete item: %v", err)
+		log.Printf("Failed to update order totals: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
 
-	_, err = tx.Exec(`
-		UPDATE orders 
-		SET subtotal = subtotal - $1,
-		    total_amount = total_amount - $1,
-		    updated_at = NOW()
-		WHERE id = $2
-	`, itemTotal, orderID)
-
-	if err != nil {
-		log.Printf("Failed to update order totals: %v", err)
+	after := line.eventSnapshot(itemID)
+	after["totals"] = totals
+	if err := recordOrderEvent(tx, orderID, eventItemAdded, userID, nil, after); err != nil {
+		log.Printf("Failed to record order event: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
+	setOrderETag(tx, w, orderID)
 
 	if err = tx.Commit(); err != nil {
 		log.Printf("Failed to commit: %v", err)
@@ -978,12 +1345,36 @@ func removeOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	if !line.Held {
+		stations, err := orderItemStations(db, orderID)
+		if err != nil {
+			log.Printf("Failed to route item to kitchen station: %v", err)
+		}
+		var scope []string
+		if code, ok := stations[itemID]; ok {
+			scope = []string{code}
+		}
+		item := line.ticketItem(itemID)
+		item["station"] = stations[itemID]
+		publishStationEvent("order_item_added", map[string]interface{}{
+			"order_id":     orderID,
+			"item":         item,
+			"total_amount": totals.TotalAmount,
+		}, scope, branchID, orgID)
+	}
+	publishPickupUpdate(db, orderID, orgID)
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":         itemID,
+		"order_id":   orderID,
+		"item_total": itemTotal,
+		"totals":     totals,
+	})
 }
 
 func updateOrderStatus(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	orderID := mux.Vars(r)["id"]
-	branchID, orgID, _ := tenantContext(r)
+	branchID, orgID, userID := tenantContext(r)
 	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
 		if err == sql.ErrNoRows {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
@@ -996,29 +1387,60 @@ func updateOrderStatus(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Status string `json:"status"`
+		Reason string `json:"reason"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
 		return
 	}
+	req.Status = strings.ToUpper(strings.TrimSpace(req.Status))
+	if !isKnownOrderStatus(req.Status) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_status"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
 
-	_, err := db.Exec(`
-		UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2
-	`, req.Status, orderID)
+	if !checkOrderVersion(tx, w, r, orderID) {
+		return
+	}
 
+	from, err := transitionOrderStatus(tx, orderID, req.Status, userID, req.Reason)
+	if transErr, ok := err.(*statusTransitionError); ok {
+		writeJSON(w, http.StatusConflict, transErr)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update status: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
 		return
 	}
+	setOrderETag(tx, w, orderID)
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
 
 	// Publish event
 	publishEvent("order_status_updated", map[string]interface{}{
-		"order_id": orderID,
-		"status":   req.Status,
+		"order_id":    orderID,
+		"status":      req.Status,
+		"from_status": from,
+		"changed_by":  userID,
 	}, branchID, orgID)
+	if req.Status == statusCancelled {
+		publishPickupUpdate(db, orderID, orgID)
+	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": req.Status})
+	writeJSON(w, http.StatusOK, map[string]string{"status": req.Status, "from_status": from})
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload any) {
@@ -1208,41 +1630,73 @@ func getHourlySales(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 func listProducts(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	_, orgID, _ := tenantContext(r)
-	
+
 	// For public access (QR menu), allow organization_id from query param
 	if orgID == "" {
 		orgID = r.URL.Query().Get("organization_id")
 	}
-	
+
 	if orgID == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
 		return
 	}
 
-	category := r.URL.Query().Get("category")
+	categoryID := r.URL.Query().Get("category_id")
 	availableOnly := r.URL.Query().Get("available_only")
-	branchID := r.URL.Query().Get("branch_id") // For public access (QR menu)
+	// With branch_id (QR menu, POS) prices and availability are the branch's own;
+	// without it they are the organization-wide settings the manager edits.
+	branchID := r.URL.Query().Get("branch_id")
+	if branchID != "" {
+		if _, err := uuid.Parse(branchID); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_not_found"})
+			return
+		}
+	}
 
 	query := `
-		SELECT id, organization_id, name, description, price, category, image_url, 
-		       is_available, sort_order, created_at, updated_at
-		FROM products
-		WHERE organization_id = $1
+		SELECT p.id, p.organization_id, p.sku, p.name, p.description, COALESCE(bo.price, p.price), p.category_id, c.name, p.image_url,
+		       COALESCE(bo.is_available, p.is_available), p.sort_order, p.created_at, p.updated_at
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		LEFT JOIN branch_product_overrides bo ON bo.product_id = p.id AND bo.branch_id::TEXT = $2
+		WHERE p.organization_id = $1
 	`
-	args := []interface{}{orgID}
-	argPos := 2
+	args := []interface{}{orgID, branchID}
+	argPos := 3
 
-	if category != "" {
-		query += fmt.Sprintf(" AND category = $%d", argPos)
-		args = append(args, category)
+	// A category includes the products of its subcategories.
+	if categoryID != "" {
+		if _, err := uuid.Parse(categoryID); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category_not_found"})
+			return
+		}
+		query += fmt.Sprintf(" AND p.category_id IN (SELECT category_id FROM v_category_tree WHERE $%d::UUID = ANY(path))", argPos)
+		args = append(args, categoryID)
 		argPos++
 	}
 
-	if availableOnly == "true" || branchID != "" {
-		query += fmt.Sprintf(" AND is_available = true")
+	// Guests (no X-User-Role), the QR menu and POS get the published menu once
+	// there is one; only signed-in staff browsing the catalog see the draft.
+	if availableOnly == "true" || branchID != "" || r.Header.Get("X-User-Role") == "" {
+		menu, err := loadPublishedMenu(db, orgID)
+		if err != nil {
+			log.Printf("Failed to load published menu: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if menu != nil {
+			listPublishedProducts(db, w, menu, branchID, categoryID)
+			return
+		}
+
+		query += ` AND COALESCE(bo.is_available, p.is_available)
+			AND (p.category_id IS NULL OR EXISTS (
+				SELECT 1 FROM v_category_tree t WHERE t.category_id = p.category_id AND t.visible_path
+			))
+			AND product_on_menu(p.id, NULLIF($2, '')::UUID)`
 	}
 
-	query += " ORDER BY sort_order, name LIMIT 500"
+	query += " ORDER BY c.sort_order NULLS LAST, p.sort_order, p.name LIMIT 500"
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -1256,7 +1710,7 @@ func listProducts(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var p Product
 		var desc, cat, img sql.NullString
-		err := rows.Scan(&p.ID, &p.OrganizationID, &p.Name, &desc, &p.Price, &cat, &img,
+		err := rows.Scan(&p.ID, &p.OrganizationID, &p.SKU, &p.Name, &desc, &p.Price, &p.CategoryID, &cat, &img,
 			&p.IsAvailable, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
 		if err != nil {
 			log.Printf("Failed to scan product: %v", err)
@@ -1272,24 +1726,8 @@ func listProducts(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 			p.ImageURL = img.String
 		}
 
-		// Load options
-		optRows, err := db.Query(`
-			SELECT id, product_id, option_group, option_name, price_modifier, 
-			       is_required, sort_order, created_at
-			FROM product_options
-			WHERE product_id = $1
-			ORDER BY option_group, sort_order
-		`, p.ID)
-		if err == nil {
-			defer optRows.Close()
-			for optRows.Next() {
-				var opt ProductOption
-				err := optRows.Scan(&opt.ID, &opt.ProductID, &opt.OptionGroup, &opt.OptionName,
-					&opt.PriceModifier, &opt.IsRequired, &opt.SortOrder, &opt.CreatedAt)
-				if err == nil {
-					p.Options = append(p.Options, opt)
-				}
-			}
+		if err := loadProductOptions(db, &p, branchID); err != nil {
+			log.Printf("Failed to load product options: %v", err)
 		}
 
 		products = append(products, p)
@@ -1301,15 +1739,41 @@ func listProducts(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 func getProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	_, orgID, _ := tenantContext(r)
+	branchID := r.URL.Query().Get("branch_id") // as in listProducts
+
+	if branchID != "" || r.Header.Get("X-User-Role") == "" {
+		menu, err := loadPublishedMenu(db, orgID)
+		if err != nil {
+			log.Printf("Failed to load published menu: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if menu != nil {
+			p, err := menu.product(db, id, branchID)
+			if err != nil {
+				log.Printf("Failed to get published product: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if p == nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "product_not_found"})
+				return
+			}
+			writeJSON(w, http.StatusOK, p)
+			return
+		}
+	}
 
 	var p Product
 	var desc, cat, img sql.NullString
 	err := db.QueryRow(`
-		SELECT id, organization_id, name, description, price, category, image_url,
-		       is_available, sort_order, created_at, updated_at
-		FROM products
-		WHERE id = $1 AND organization_id = $2
-	`, id, orgID).Scan(&p.ID, &p.OrganizationID, &p.Name, &desc, &p.Price, &cat, &img,
+		SELECT p.id, p.organization_id, p.sku, p.name, p.description, COALESCE(bo.price, p.price), p.category_id, c.name, p.image_url,
+		       COALESCE(bo.is_available, p.is_available), p.sort_order, p.created_at, p.updated_at
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		LEFT JOIN branch_product_overrides bo ON bo.product_id = p.id AND bo.branch_id::TEXT = $3
+		WHERE p.id = $1 AND p.organization_id = $2
+	`, id, orgID, branchID).Scan(&p.ID, &p.OrganizationID, &p.SKU, &p.Name, &desc, &p.Price, &p.CategoryID, &cat, &img,
 		&p.IsAvailable, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
 
 	if err == sql.ErrNoRows {
@@ -1332,31 +1796,17 @@ func getProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		p.ImageURL = img.String
 	}
 
-	// Load options
-	optRows, err := db.Query(`
-		SELECT id, product_id, option_group, option_name, price_modifier,
-		       is_required, sort_order, created_at
-		FROM product_options
-		WHERE product_id = $1
-		ORDER BY option_group, sort_order
-	`, p.ID)
-	if err == nil {
-		defer optRows.Close()
-		for optRows.Next() {
-			var opt ProductOption
-			err := optRows.Scan(&opt.ID, &opt.ProductID, &opt.OptionGroup, &opt.OptionName,
-				&opt.PriceModifier, &opt.IsRequired, &opt.SortOrder, &opt.CreatedAt)
-			if err == nil {
-				p.Options = append(p.Options, opt)
-			}
-		}
+	if err := loadProductOptions(db, &p, branchID); err != nil {
+		log.Printf("Failed to load product options: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
 	}
 
 	writeJSON(w, http.StatusOK, p)
 }
 
 func createProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	_, orgID, userID := tenantContext(r)
+	_, orgID, _ := tenantContext(r)
 	if orgID == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
 		return
@@ -1379,6 +1829,41 @@ func createProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
 		return
 	}
+	req.SKU = strings.TrimSpace(req.SKU)
+	if len(req.SKU) > maxSKULength {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sku_too_long"})
+		return
+	}
+	if req.SKU != "" {
+		taken, err := skuTaken(db, orgID, req.SKU, "")
+		if err != nil {
+			log.Printf("Failed to check SKU: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if taken {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "sku_exists"})
+			return
+		}
+	}
+	for i := range req.OptionGroups {
+		if code := req.OptionGroups[i].validate(); code != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "option_group": req.OptionGroups[i].Name})
+			return
+		}
+	}
+	if req.CategoryID != "" {
+		exists, err := categoryInOrg(db, orgID, req.CategoryID)
+		if err != nil {
+			log.Printf("Failed to check category: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if !exists {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category_not_found"})
+			return
+		}
+	}
 
 	productID := uuid.New().String()
 	tx, err := db.Begin()
@@ -1390,11 +1875,11 @@ func createProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	_, err = tx.Exec(`
-		INSERT INTO products (id, organization_id, name, description, price, category, 
+		INSERT INTO products (id, organization_id, sku, name, description, price, category_id,
 		                     image_url, is_available, sort_order, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
-	`, productID, orgID, req.Name, nullable(req.Description), req.Price,
-		nullable(req.Category), nullable(req.ImageURL), req.IsAvailable, req.SortOrder)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+	`, productID, orgID, nullable(req.SKU), req.Name, nullable(req.Description), req.Price,
+		nullable(req.CategoryID), nullable(req.ImageURL), req.IsAvailable, req.SortOrder)
 
 	if err != nil {
 		log.Printf("Failed to create product: %v", err)
@@ -1418,6 +1903,14 @@ func createProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	for _, groupReq := range req.OptionGroups {
+		if _, err := insertOptionGroup(tx, productID, groupReq); err != nil {
+			log.Printf("Failed to create option group: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		log.Printf("Failed to commit: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
@@ -1454,6 +1947,28 @@ func updateProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	args := []interface{}{}
 	argPos := 1
 
+	if req.SKU != nil {
+		sku := strings.TrimSpace(*req.SKU)
+		if len(sku) > maxSKULength {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sku_too_long"})
+			return
+		}
+		if sku != "" {
+			taken, err := skuTaken(db, orgID, sku, id)
+			if err != nil {
+				log.Printf("Failed to check SKU: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if taken {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "sku_exists"})
+				return
+			}
+		}
+		updates = append(updates, fmt.Sprintf("sku = $%d", argPos))
+		args = append(args, nullable(sku))
+		argPos++
+	}
 	if req.Name != nil {
 		updates = append(updates, fmt.Sprintf("name = $%d", argPos))
 		args = append(args, *req.Name)
@@ -1469,9 +1984,21 @@ func updateProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		args = append(args, *req.Price)
 		argPos++
 	}
-	if req.Category != nil {
-		updates = append(updates, fmt.Sprintf("category = $%d", argPos))
-		args = append(args, nullable(*req.Category))
+	if req.CategoryID != nil {
+		if *req.CategoryID != "" {
+			exists, err := categoryInOrg(db, orgID, *req.CategoryID)
+			if err != nil {
+				log.Printf("Failed to check category: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if !exists {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category_not_found"})
+				return
+			}
+		}
+		updates = append(updates, fmt.Sprintf("category_id = $%d", argPos))
+		args = append(args, nullable(*req.CategoryID))
 		argPos++
 	}
 	if req.ImageURL != nil {
@@ -1552,6 +2079,12 @@ func deleteProduct(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 }
 
 func publishEvent(eventType string, data map[string]interface{}, branchID, orgID string) {
+	publishStationEvent(eventType, data, nil, branchID, orgID)
+}
+
+// publishStationEvent is publishEvent for kitchen events; screens subscribed to
+// a station only receive it when the station is listed.
+func publishStationEvent(eventType string, data map[string]interface{}, stations []string, branchID, orgID string) {
 	if notificationServiceURL == "" {
 		return
 	}
@@ -1562,6 +2095,9 @@ func publishEvent(eventType string, data map[string]interface{}, branchID, orgID
 		"branch_id":       branchID,
 		"organization_id": orgID,
 	}
+	if len(stations) > 0 {
+		event["stations"] = stations
+	}
 
 	jsonData, err := json.Marshal(event)
 	if err != nil {
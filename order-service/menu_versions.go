@@ -0,0 +1,699 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Managers edit the catalog tables (categories, products, option groups) as a
+// draft. Publishing copies the organization's draft into an immutable menu
+// version; the QR menu, the POS and order pricing serve the newest version, so
+// half-finished edits stay out of sight until the next publish. A rollback
+// publishes an older snapshot again as a new version, leaving the draft alone.
+// Branch price and option modifier overrides are published with the catalog.
+// Availability is not versioned: sold-out switches on the product and in a
+// branch apply at once.
+
+const maxMenuVersionNoteLength = 500
+
+// MenuVersion is one publish of an organization's menu.
+type MenuVersion struct {
+	ID             string        `json:"id"`
+	OrganizationID string        `json:"organization_id"`
+	Version        int           `json:"version"`
+	Note           *string       `json:"note"`
+	RestoredFrom   *int          `json:"restored_from"` // rollbacks: the version copied
+	PublishedBy    *string       `json:"published_by"`
+	PublishedAt    time.Time     `json:"published_at"`
+	IsLive         bool          `json:"is_live"`
+	ProductCount   int           `json:"product_count"`
+	Snapshot       *menuSnapshot `json:"snapshot,omitempty"`
+}
+
+// menuSnapshot is the catalog as published: organization-wide values, plus
+// the branches' price overrides.
+type menuSnapshot struct {
+	Categories      []*Category          `json:"categories"` // flat, parents before children
+	Products        []Product            `json:"products"`   // menu order, with option groups
+	BranchOverrides []menuBranchOverride `json:"branch_overrides"`
+}
+
+// menuBranchOverride is one branch's published prices for one product.
+type menuBranchOverride struct {
+	BranchID        string                   `json:"branch_id"`
+	ProductID       string                   `json:"product_id"`
+	Price           *float64                 `json:"price"`
+	OptionModifiers []OptionModifierOverride `json:"option_modifiers"`
+}
+
+type PublishMenuRequest struct {
+	Note string `json:"note"`
+}
+
+// publishedMenu is an organization's live menu version, ready to serve.
+type publishedMenu struct {
+	ID       string
+	Version  int
+	snapshot menuSnapshot
+
+	products   map[string]*Product
+	categories map[string]*Category
+	overrides  map[string]*menuBranchOverride // by branchOverrideKey
+
+	// Live availability read ahead by preloadAvailability, by branchOverrideKey
+	available map[string]bool
+	preloaded map[string]bool
+}
+
+func branchOverrideKey(branchID, productID string) string {
+	return branchID + "/" + productID
+}
+
+// loadPublishedMenu returns the organization's live menu version, or nil when
+// nothing has been published yet and the catalog tables are served as is.
+func loadPublishedMenu(q queryer, orgID string) (*publishedMenu, error) {
+	m := &publishedMenu{}
+	var raw []byte
+	err := q.QueryRow(`
+		SELECT id, version, snapshot
+		FROM menu_versions
+		WHERE organization_id::TEXT = $1
+		ORDER BY version DESC
+		LIMIT 1
+	`, orgID).Scan(&m.ID, &m.Version, &raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &m.snapshot); err != nil {
+		return nil, err
+	}
+
+	m.products = map[string]*Product{}
+	for i := range m.snapshot.Products {
+		m.products[m.snapshot.Products[i].ID] = &m.snapshot.Products[i]
+	}
+	m.categories = map[string]*Category{}
+	for _, c := range m.snapshot.Categories {
+		m.categories[c.ID] = c
+	}
+	m.overrides = map[string]*menuBranchOverride{}
+	for i := range m.snapshot.BranchOverrides {
+		o := &m.snapshot.BranchOverrides[i]
+		m.overrides[branchOverrideKey(o.BranchID, o.ProductID)] = o
+	}
+	return m, nil
+}
+
+// menuPublished reports whether the organization has a live menu version, in
+// which case catalog price changes wait for the next publish.
+func menuPublished(q queryer, orgID string) (bool, error) {
+	var published bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM menu_versions WHERE organization_id::TEXT = $1)`, orgID).Scan(&published)
+	return published, err
+}
+
+// visible reports whether a category and all its parents are visible; products
+// without a category always are.
+func (m *publishedMenu) visible(categoryID *string) bool {
+	for c := m.categories[derefString(categoryID)]; c != nil; c = m.categories[derefString(c.ParentID)] {
+		if !c.IsVisible {
+			return false
+		}
+	}
+	return true
+}
+
+// inCategory reports whether a category is rootID or one of its subcategories.
+func (m *publishedMenu) inCategory(categoryID *string, rootID string) bool {
+	for c := m.categories[derefString(categoryID)]; c != nil; c = m.categories[derefString(c.ParentID)] {
+		if c.ID == rootID {
+			return true
+		}
+	}
+	return false
+}
+
+// categoryTree rebuilds the published category tree.
+func (m *publishedMenu) categoryTree(visibleOnly bool) []*Category {
+	roots := []*Category{}
+	byID := map[string]*Category{}
+	for _, src := range m.snapshot.Categories {
+		if visibleOnly && !m.visible(&src.ID) {
+			continue
+		}
+		c := *src
+		c.Children = nil
+		byID[c.ID] = &c
+		if parent, ok := byID[derefString(c.ParentID)]; ok {
+			parent.Children = append(parent.Children, &c)
+		} else {
+			roots = append(roots, &c)
+		}
+	}
+	return roots
+}
+
+// product returns a published product as served in the branch, or nil when
+// it is not on the published menu.
+func (m *publishedMenu) product(q queryer, productID, branchID string) (*Product, error) {
+	if _, ok := m.products[productID]; !ok {
+		return nil, nil
+	}
+	key := branchOverrideKey(branchID, productID)
+	if m.preloaded[key] {
+		available := map[string]bool{}
+		if isAvailable, ok := m.available[key]; ok {
+			available[productID] = isAvailable
+		}
+		return m.branchProduct(productID, branchID, available), nil
+	}
+	available, err := liveAvailability(q, []string{productID}, branchID)
+	if err != nil {
+		return nil, err
+	}
+	return m.branchProduct(productID, branchID, available), nil
+}
+
+// preloadAvailability reads the live availability of several products in one
+// query, so pricing the lines of an order does not query once per line.
+func (m *publishedMenu) preloadAvailability(q queryer, productIDs []string, branchID string) error {
+	var ids []string
+	for _, id := range productIDs {
+		if _, err := uuid.Parse(id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	available, err := liveAvailability(q, ids, branchID)
+	if err != nil {
+		return err
+	}
+	if m.preloaded == nil {
+		m.available, m.preloaded = map[string]bool{}, map[string]bool{}
+	}
+	for _, id := range ids {
+		key := branchOverrideKey(branchID, id)
+		m.preloaded[key] = true
+		if isAvailable, ok := available[id]; ok {
+			m.available[key] = isAvailable
+		}
+	}
+	return nil
+}
+
+// branchProduct copies a published product with the branch's published price
+// and option modifiers and the live availability applied.
+func (m *publishedMenu) branchProduct(productID, branchID string, available map[string]bool) *Product {
+	src := m.products[productID]
+	p := *src
+	if isAvailable, ok := available[productID]; ok {
+		p.IsAvailable = isAvailable
+	}
+
+	modifiers := map[string]float64{}
+	if o, ok := m.overrides[branchOverrideKey(branchID, productID)]; ok {
+		if o.Price != nil {
+			p.Price = *o.Price
+		}
+		for _, mod := range o.OptionModifiers {
+			modifiers[optionKey(mod.OptionGroup, mod.OptionName)] = mod.PriceModifier
+		}
+	}
+
+	p.OptionGroups = make([]OptionGroup, len(src.OptionGroups))
+	p.Options = nil
+	for i, g := range src.OptionGroups {
+		g.Options = append([]ProductOption(nil), g.Options...)
+		for j := range g.Options {
+			opt := &g.Options[j]
+			if modifier, ok := modifiers[optionKey(opt.OptionGroup, opt.OptionName)]; ok {
+				opt.PriceModifier = modifier
+			}
+		}
+		p.OptionGroups[i] = g
+		p.Options = append(p.Options, g.Options...)
+	}
+	return &p
+}
+
+// liveAvailability reads the current availability of products in a branch,
+// which is not versioned. Products since deleted from the draft are left out
+// and keep their published availability.
+func liveAvailability(q queryer, productIDs []string, branchID string) (map[string]bool, error) {
+	rows, err := q.Query(`
+		SELECT p.id::TEXT, COALESCE(bo.is_available, p.is_available)
+		FROM products p
+		LEFT JOIN branch_product_overrides bo ON bo.product_id = p.id AND bo.branch_id::TEXT = $2
+		WHERE p.id = ANY($1::UUID[])
+	`, pq.Array(productIDs), branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	available := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var isAvailable bool
+		if err := rows.Scan(&id, &isAvailable); err != nil {
+			return nil, err
+		}
+		available[id] = isAvailable
+	}
+	return available, rows.Err()
+}
+
+// buildMenuSnapshot reads the organization's draft catalog.
+func buildMenuSnapshot(q queryer, orgID string) (*menuSnapshot, error) {
+	categories, err := loadCategories(q, orgID, "", false)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &menuSnapshot{Categories: flattenCategories(categories), Products: []Product{}}
+
+	rows, err := q.Query(`
+		SELECT p.id, p.organization_id, p.sku, p.name, COALESCE(p.description, ''), p.price, p.category_id, COALESCE(c.name, ''),
+		       COALESCE(p.image_url, ''), p.is_available, p.sort_order, p.created_at, p.updated_at
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		WHERE p.organization_id = $1
+		ORDER BY c.sort_order NULLS LAST, p.sort_order, p.name
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.ID, &p.OrganizationID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.CategoryID, &p.Category,
+			&p.ImageURL, &p.IsAvailable, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snapshot.Products = append(snapshot.Products, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range snapshot.Products {
+		groups, err := loadOptionGroups(q, snapshot.Products[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Products[i].OptionGroups = groups
+	}
+
+	if snapshot.BranchOverrides, err = loadMenuBranchOverrides(q, orgID); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// loadMenuBranchOverrides reads the price and option modifier overrides of
+// every branch of the organization.
+func loadMenuBranchOverrides(q queryer, orgID string) ([]menuBranchOverride, error) {
+	rows, err := q.Query(`
+		SELECT o.branch_id, o.product_id, bo.price, oo.option_group, oo.option_name, oo.price_modifier
+		FROM (
+			SELECT branch_id, product_id FROM branch_product_overrides WHERE price IS NOT NULL
+			UNION
+			SELECT branch_id, product_id FROM branch_option_overrides
+		) o
+		JOIN products p ON p.id = o.product_id
+		LEFT JOIN branch_product_overrides bo ON bo.branch_id = o.branch_id AND bo.product_id = o.product_id
+		LEFT JOIN branch_option_overrides oo ON oo.branch_id = o.branch_id AND oo.product_id = o.product_id
+		WHERE p.organization_id = $1
+		ORDER BY o.branch_id, o.product_id, oo.option_group, oo.option_name
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []menuBranchOverride{}
+	for rows.Next() {
+		var branchID, productID string
+		var price sql.NullFloat64
+		var group, name sql.NullString
+		var modifier sql.NullFloat64
+		if err := rows.Scan(&branchID, &productID, &price, &group, &name, &modifier); err != nil {
+			return nil, err
+		}
+		n := len(overrides)
+		if n == 0 || overrides[n-1].BranchID != branchID || overrides[n-1].ProductID != productID {
+			o := menuBranchOverride{BranchID: branchID, ProductID: productID, OptionModifiers: []OptionModifierOverride{}}
+			if price.Valid {
+				o.Price = &price.Float64
+			}
+			overrides = append(overrides, o)
+			n++
+		}
+		if group.Valid {
+			overrides[n-1].OptionModifiers = append(overrides[n-1].OptionModifiers, OptionModifierOverride{
+				OptionGroup:   group.String,
+				OptionName:    name.String,
+				PriceModifier: modifier.Float64,
+			})
+		}
+	}
+	return overrides, rows.Err()
+}
+
+// insertMenuVersion publishes a snapshot as the organization's next version.
+// Callers hold the organization lock (lockMenuVersions).
+func insertMenuVersion(tx *sql.Tx, orgID string, snapshot []byte, note string, restoredFrom *int, userID string) (string, int, error) {
+	var id string
+	var version int
+	err := tx.QueryRow(`
+		INSERT INTO menu_versions (organization_id, version, snapshot, note, restored_from, published_by, published_at)
+		VALUES ($1, (SELECT COALESCE(MAX(version), 0) + 1 FROM menu_versions WHERE organization_id = $1), $2, $3, $4, $5, NOW())
+		RETURNING id, version
+	`, orgID, snapshot, nullable(note), restoredFrom, nullable(userID)).Scan(&id, &version)
+	return id, version, err
+}
+
+// lockMenuVersions serializes publishes within an organization so version
+// numbers are issued one at a time.
+func lockMenuVersions(tx *sql.Tx, orgID string) error {
+	_, err := tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID)
+	return err
+}
+
+func decodeMenuVersionNote(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req PublishMenuRequest
+	// The body is optional.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return "", false
+	}
+	note := strings.TrimSpace(req.Note)
+	if len(note) > maxMenuVersionNoteLength {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "note_too_long"})
+		return "", false
+	}
+	return note, true
+}
+
+// listMenuVersions returns the organization's published versions, newest
+// first, without their snapshots.
+func listMenuVersions(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, organization_id, version, note, restored_from, published_by, published_at,
+		       version = MAX(version) OVER (), jsonb_array_length(snapshot->'products')
+		FROM menu_versions
+		WHERE organization_id = $1
+		ORDER BY version DESC
+		LIMIT 100
+	`, orgID)
+	if err != nil {
+		log.Printf("Failed to list menu versions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	versions := []MenuVersion{}
+	for rows.Next() {
+		var v MenuVersion
+		if err := rows.Scan(&v.ID, &v.OrganizationID, &v.Version, &v.Note, &v.RestoredFrom, &v.PublishedBy, &v.PublishedAt,
+			&v.IsLive, &v.ProductCount); err != nil {
+			log.Printf("Scan menu version failed: %v", err)
+			continue
+		}
+		versions = append(versions, v)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+}
+
+// getMenuVersion returns one version with its snapshot.
+func getMenuVersion(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	number, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_version_not_found"})
+		return
+	}
+
+	var v MenuVersion
+	var raw []byte
+	err = db.QueryRow(`
+		SELECT id, organization_id, version, note, restored_from, published_by, published_at,
+		       version = (SELECT MAX(version) FROM menu_versions WHERE organization_id = mv.organization_id),
+		       jsonb_array_length(snapshot->'products'), snapshot
+		FROM menu_versions mv
+		WHERE organization_id::TEXT = $1 AND version = $2
+	`, orgID, number).Scan(&v.ID, &v.OrganizationID, &v.Version, &v.Note, &v.RestoredFrom, &v.PublishedBy, &v.PublishedAt,
+		&v.IsLive, &v.ProductCount, &raw)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_version_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get menu version: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	v.Snapshot = &menuSnapshot{}
+	if err := json.Unmarshal(raw, v.Snapshot); err != nil {
+		log.Printf("Failed to decode menu snapshot: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+// publishMenu snapshots the draft catalog as the organization's new live menu.
+func publishMenu(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, userID := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+	note, ok := decodeMenuVersionNote(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockMenuVersions(tx, orgID); err != nil {
+		log.Printf("Failed to lock menu versions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	snapshot, err := buildMenuSnapshot(tx, orgID)
+	if err != nil {
+		log.Printf("Failed to build menu snapshot: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	// An empty menu would take every product off the QR menu at once.
+	if len(snapshot.Products) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "menu_empty"})
+		return
+	}
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("Failed to encode menu snapshot: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	id, version, err := insertMenuVersion(tx, orgID, raw, note, nil, userID)
+	if err != nil {
+		log.Printf("Failed to publish menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	// QR menus and POS screens reload on this.
+	publishEvent("menu_published", map[string]interface{}{
+		"menu_version_id": id,
+		"version":         version,
+	}, "", orgID)
+
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "version": version, "product_count": len(snapshot.Products)})
+}
+
+// rollbackMenu makes an earlier version live again by publishing a copy of
+// its snapshot. The draft is left as it is.
+func rollbackMenu(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, userID := tenantContext(r)
+	number, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_version_not_found"})
+		return
+	}
+	note, ok := decodeMenuVersionNote(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockMenuVersions(tx, orgID); err != nil {
+		log.Printf("Failed to lock menu versions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var raw []byte
+	var isLive bool
+	err = tx.QueryRow(`
+		SELECT snapshot, version = (SELECT MAX(version) FROM menu_versions WHERE organization_id = mv.organization_id)
+		FROM menu_versions mv
+		WHERE organization_id::TEXT = $1 AND version = $2
+	`, orgID, number).Scan(&raw, &isLive)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_version_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get menu version: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if isLive {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "menu_version_already_live"})
+		return
+	}
+
+	id, version, err := insertMenuVersion(tx, orgID, raw, note, &number, userID)
+	if err != nil {
+		log.Printf("Failed to roll back menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("menu_published", map[string]interface{}{
+		"menu_version_id": id,
+		"version":         version,
+		"restored_from":   number,
+	}, "", orgID)
+
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "version": version, "restored_from": number})
+}
+
+// listPublishedProducts serves listProducts from the live menu version, with
+// the same filters applied to the snapshot.
+func listPublishedProducts(db *sql.DB, w http.ResponseWriter, menu *publishedMenu, branchID, categoryID string) {
+	candidates := []string{}
+	for _, src := range menu.snapshot.Products {
+		if categoryID != "" && !menu.inCategory(src.CategoryID, categoryID) {
+			continue
+		}
+		if !menu.visible(src.CategoryID) {
+			continue
+		}
+		candidates = append(candidates, src.ID)
+	}
+	available, err := liveAvailability(db, candidates, branchID)
+	if err != nil {
+		log.Printf("Failed to load product availability: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var products []Product
+	ids := []string{}
+	for _, id := range candidates {
+		p := menu.branchProduct(id, branchID, available)
+		if !p.IsAvailable {
+			continue
+		}
+		products = append(products, *p)
+		ids = append(ids, p.ID)
+	}
+
+	// Menu schedules apply to published products as to the draft.
+	rows, err := db.Query(`
+		SELECT id::TEXT FROM unnest($1::UUID[]) id
+		WHERE NOT product_on_menu(id, NULLIF($2, '')::UUID)
+	`, pq.Array(ids), branchID)
+	if err != nil {
+		log.Printf("Failed to check menu schedules: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+	offMenu := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Scan menu schedule failed: %v", err)
+			continue
+		}
+		offMenu[id] = true
+	}
+	if len(offMenu) > 0 {
+		served := products[:0]
+		for _, p := range products {
+			if !offMenu[p.ID] {
+				served = append(served, p)
+			}
+		}
+		products = served
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"products": products, "menu_version": menu.Version})
+}
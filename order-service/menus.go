@@ -0,0 +1,506 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Menus are named schedules (breakfast, late night, ...). Products on a menu,
+// directly or through their category, can only be ordered while the menu is
+// open in the branch's local time; see product_on_menu in the schema.
+
+const maxMenuNameLength = 100
+
+// MenuWindow is a weekly opening window. An end before the start runs past
+// midnight; equal times cover the whole day.
+type MenuWindow struct {
+	Days      []int  `json:"days"` // 0 = Sunday
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+type Menu struct {
+	ID             string       `json:"id"`
+	OrganizationID string       `json:"organization_id"`
+	Name           string       `json:"name"`
+	IsActive       bool         `json:"is_active"`
+	SortOrder      int          `json:"sort_order"`
+	Windows        []MenuWindow `json:"windows"`
+	ProductIDs     []string     `json:"product_ids"`
+	CategoryIDs    []string     `json:"category_ids"`
+	OpenNow        *bool        `json:"open_now,omitempty"` // in the requested branch
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// MenuRequest creates or replaces a menu with its windows and members.
+type MenuRequest struct {
+	Name        string       `json:"name"`
+	IsActive    *bool        `json:"is_active"` // default true
+	SortOrder   int          `json:"sort_order"`
+	Windows     []MenuWindow `json:"windows"`
+	ProductIDs  []string     `json:"product_ids"`
+	CategoryIDs []string     `json:"category_ids"`
+}
+
+// normalize trims and checks the request and returns an error code when it
+// is unusable. Windows without days run every day.
+func (req *MenuRequest) normalize() string {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return "name_required"
+	}
+	if len(req.Name) > maxMenuNameLength {
+		return "name_too_long"
+	}
+	if len(req.Windows) == 0 {
+		return "windows_required"
+	}
+	for i := range req.Windows {
+		w := &req.Windows[i]
+		start, err := time.Parse("15:04", strings.TrimSpace(w.StartTime))
+		if err != nil {
+			return "invalid_window_time"
+		}
+		end, err := time.Parse("15:04", strings.TrimSpace(w.EndTime))
+		if err != nil {
+			return "invalid_window_time"
+		}
+		w.StartTime, w.EndTime = start.Format("15:04"), end.Format("15:04")
+
+		if len(w.Days) == 0 {
+			w.Days = []int{0, 1, 2, 3, 4, 5, 6}
+		}
+		seen := map[int]bool{}
+		days := []int{}
+		for _, d := range w.Days {
+			if d < 0 || d > 6 {
+				return "invalid_window_days"
+			}
+			if !seen[d] {
+				seen[d] = true
+				days = append(days, d)
+			}
+		}
+		sort.Ints(days)
+		w.Days = days
+	}
+
+	var ok bool
+	if req.ProductIDs, ok = uniqueIDs(req.ProductIDs); !ok {
+		return "product_not_found"
+	}
+	if req.CategoryIDs, ok = uniqueIDs(req.CategoryIDs); !ok {
+		return "category_not_found"
+	}
+	return ""
+}
+
+// uniqueIDs drops blanks and duplicates; it fails on anything not a UUID.
+func uniqueIDs(ids []string) ([]string, bool) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, false
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result, true
+}
+
+// productOnMenu reports whether the product's menus allow ordering it in the
+// branch right now.
+func productOnMenu(q queryer, productID, branchID string) (bool, error) {
+	var onMenu bool
+	err := q.QueryRow(`SELECT product_on_menu($1, NULLIF($2, '')::UUID)`, productID, branchID).Scan(&onMenu)
+	return onMenu, err
+}
+
+// loadMenus returns the organization's menus, or just menuID's. With a branch
+// each menu says whether it is open there now.
+func loadMenus(q queryer, orgID, menuID, branchID string) ([]*Menu, error) {
+	query := `
+		SELECT m.id, m.organization_id, m.name, m.is_active, m.sort_order, m.created_at, m.updated_at,
+		       CASE WHEN b.id IS NULL THEN NULL ELSE menu_open_at(m.id, NOW() AT TIME ZONE b.timezone) END
+		FROM menus m
+		LEFT JOIN branches b ON b.id::TEXT = $2 AND b.organization_id = m.organization_id
+		WHERE m.organization_id = $1`
+	args := []any{orgID, branchID}
+	if menuID != "" {
+		query += ` AND m.id = $3`
+		args = append(args, menuID)
+	}
+	query += ` ORDER BY m.sort_order, m.name`
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	menus := []*Menu{}
+	byID := map[string]*Menu{}
+	ids := []string{}
+	for rows.Next() {
+		m := Menu{Windows: []MenuWindow{}, ProductIDs: []string{}, CategoryIDs: []string{}}
+		if err := rows.Scan(&m.ID, &m.OrganizationID, &m.Name, &m.IsActive, &m.SortOrder, &m.CreatedAt, &m.UpdatedAt,
+			&m.OpenNow); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		menus = append(menus, &m)
+		byID[m.ID] = &m
+		ids = append(ids, m.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(menus) == 0 {
+		return menus, err
+	}
+
+	rows, err = q.Query(`
+		SELECT menu_id, array_to_json(days), to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM menu_windows
+		WHERE menu_id = ANY($1)
+		ORDER BY start_time, id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var menuID string
+		var days []byte
+		var w MenuWindow
+		if err := rows.Scan(&menuID, &days, &w.StartTime, &w.EndTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := json.Unmarshal(days, &w.Days); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[menuID].Windows = append(byID[menuID].Windows, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT menu_id, product_id, 'product' FROM menu_products WHERE menu_id = ANY($1)
+		UNION ALL
+		SELECT menu_id, category_id, 'category' FROM menu_categories WHERE menu_id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var menuID, memberID, kind string
+		if err := rows.Scan(&menuID, &memberID, &kind); err != nil {
+			return nil, err
+		}
+		if kind == "product" {
+			byID[menuID].ProductIDs = append(byID[menuID].ProductIDs, memberID)
+		} else {
+			byID[menuID].CategoryIDs = append(byID[menuID].CategoryIDs, memberID)
+		}
+	}
+	return menus, rows.Err()
+}
+
+// saveMenuContents replaces a menu's windows, products and categories. It
+// returns an error code when a product or category is not the organization's.
+func saveMenuContents(tx *sql.Tx, orgID, menuID string, req MenuRequest) (string, error) {
+	for _, table := range []string{"menu_windows", "menu_products", "menu_categories"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE menu_id = $1`, menuID); err != nil {
+			return "", err
+		}
+	}
+
+	for _, w := range req.Windows {
+		_, err := tx.Exec(`
+			INSERT INTO menu_windows (menu_id, days, start_time, end_time)
+			VALUES ($1, $2, $3, $4)
+		`, menuID, pq.Array(w.Days), w.StartTime, w.EndTime)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, productID := range req.ProductIDs {
+		res, err := tx.Exec(`
+			INSERT INTO menu_products (menu_id, product_id)
+			SELECT $1, id FROM products WHERE id = $2 AND organization_id = $3
+		`, menuID, productID, orgID)
+		if err != nil {
+			return "", err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return "product_not_found", nil
+		}
+	}
+
+	for _, categoryID := range req.CategoryIDs {
+		res, err := tx.Exec(`
+			INSERT INTO menu_categories (menu_id, category_id)
+			SELECT $1, id FROM categories WHERE id = $2 AND organization_id = $3
+		`, menuID, categoryID, orgID)
+		if err != nil {
+			return "", err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return "category_not_found", nil
+		}
+	}
+	return "", nil
+}
+
+// menuNameTaken reports whether another menu of the organization uses the name.
+func menuNameTaken(q queryer, orgID, name, exceptID string) (bool, error) {
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM menus WHERE organization_id = $1 AND name = $2 AND id::TEXT <> $3)
+	`, orgID, name, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// listMenus returns the organization's menus; ?branch_id= (default: the
+// caller's branch) adds whether each is open there now.
+func listMenus(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	branchID, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+	if b := r.URL.Query().Get("branch_id"); b != "" {
+		branchID = b
+	}
+
+	menus, err := loadMenus(db, orgID, "", branchID)
+	if err != nil {
+		log.Printf("Failed to list menus: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"menus": menus})
+}
+
+func getMenu(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	menuID := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if b := r.URL.Query().Get("branch_id"); b != "" {
+		branchID = b
+	}
+	if _, err := uuid.Parse(menuID); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_not_found"})
+		return
+	}
+
+	menus, err := loadMenus(db, orgID, menuID, branchID)
+	if err != nil {
+		log.Printf("Failed to get menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if len(menus) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_not_found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, menus[0])
+}
+
+func createMenu(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+
+	var req MenuRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if code := req.normalize(); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+	active := req.IsActive == nil || *req.IsActive
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	taken, err := menuNameTaken(tx, orgID, req.Name, "")
+	if err != nil {
+		log.Printf("Failed to check menu name: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if taken {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "menu_exists"})
+		return
+	}
+
+	var menuID string
+	err = tx.QueryRow(`
+		INSERT INTO menus (organization_id, name, is_active, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		RETURNING id
+	`, orgID, req.Name, active, req.SortOrder).Scan(&menuID)
+	if err != nil {
+		log.Printf("Failed to create menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	code, err := saveMenuContents(tx, orgID, menuID, req)
+	if err != nil {
+		log.Printf("Failed to save menu contents: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("menu_created", map[string]interface{}{"menu_id": menuID, "name": req.Name}, "", orgID)
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": menuID})
+}
+
+// updateMenu replaces a menu's settings, windows and members.
+func updateMenu(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	menuID := mux.Vars(r)["id"]
+	_, orgID, _ := tenantContext(r)
+	if _, err := uuid.Parse(menuID); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_not_found"})
+		return
+	}
+
+	var req MenuRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if code := req.normalize(); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+	active := req.IsActive == nil || *req.IsActive
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	taken, err := menuNameTaken(tx, orgID, req.Name, menuID)
+	if err != nil {
+		log.Printf("Failed to check menu name: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if taken {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "menu_exists"})
+		return
+	}
+
+	res, err := tx.Exec(`
+		UPDATE menus SET name = $1, is_active = $2, sort_order = $3, updated_at = NOW()
+		WHERE id = $4 AND organization_id = $5
+	`, req.Name, active, req.SortOrder, menuID, orgID)
+	if err != nil {
+		log.Printf("Failed to update menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_not_found"})
+		return
+	}
+
+	code, err := saveMenuContents(tx, orgID, menuID, req)
+	if err != nil {
+		log.Printf("Failed to save menu contents: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("menu_updated", map[string]interface{}{"menu_id": menuID, "name": req.Name}, "", orgID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// deleteMenu removes a menu; its products are served all day again unless
+// another menu schedules them.
+func deleteMenu(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	menuID := mux.Vars(r)["id"]
+	_, orgID, _ := tenantContext(r)
+	if _, err := uuid.Parse(menuID); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_not_found"})
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM menus WHERE id = $1 AND organization_id = $2`, menuID, orgID)
+	if err != nil {
+		log.Printf("Failed to delete menu: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "menu_not_found"})
+		return
+	}
+
+	publishEvent("menu_deleted", map[string]interface{}{"menu_id": menuID}, "", orgID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxItemNoteLength  = 200
+	maxOrderNoteLength = 500
+	maxNotePresets     = 50
+)
+
+// NotePreset is a quick note the ordering screens offer with one tap.
+type NotePreset struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	SortOrder int       `json:"sort_order"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type NotePresetRequest struct {
+	Label     string `json:"label"`
+	SortOrder int    `json:"sort_order"`
+}
+
+// cleanNote trims a kitchen note and drops control characters other than
+// line breaks, which would garble printed tickets. ok is false when the note
+// is longer than max characters.
+func cleanNote(note string, max int) (string, bool) {
+	note = strings.Map(func(r rune) rune {
+		if r == '\r' || (unicode.IsControl(r) && r != '\n') {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(note))
+	return note, utf8.RuneCountInString(note) <= max
+}
+
+// ticketItem is how a new line appears in kitchen notification events.
+func (l *pricedLine) ticketItem(itemID string) map[string]any {
+	options := make([]string, 0, len(l.Modifiers))
+	for _, m := range l.Modifiers {
+		options = append(options, m.OptionName)
+	}
+	return map[string]any{
+		"item_id":        itemID,
+		"menu_item_name": l.ProductName,
+		"quantity":       l.Quantity,
+		"options":        options,
+		"notes":          nullable(l.Notes),
+		"course":         l.Course,
+	}
+}
+
+// listNotePresets is public so the QR menu can offer the same quick notes;
+// guests pass organization_id like they do for products.
+func listNotePresets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		orgID = r.URL.Query().Get("organization_id")
+	}
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, label, sort_order, created_at
+		FROM note_presets
+		WHERE organization_id = $1
+		ORDER BY sort_order, label
+	`, orgID)
+	if err != nil {
+		log.Printf("Failed to list note presets: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	presets := []NotePreset{}
+	for rows.Next() {
+		var p NotePreset
+		if err := rows.Scan(&p.ID, &p.Label, &p.SortOrder, &p.CreatedAt); err != nil {
+			log.Printf("Scan note preset failed: %v", err)
+			continue
+		}
+		presets = append(presets, p)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"presets": presets})
+}
+
+func createNotePreset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+	if orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "organization_required"})
+		return
+	}
+
+	var req NotePresetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	label, ok := cleanNote(req.Label, maxItemNoteLength)
+	if label == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "label_required"})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "note_too_long", "max_length": maxItemNoteLength})
+		return
+	}
+
+	var count int
+	var exists bool
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(BOOL_OR(label = $2), false) FROM note_presets WHERE organization_id = $1
+	`, orgID, label).Scan(&count, &exists)
+	if err != nil {
+		log.Printf("Failed to count note presets: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "preset_exists"})
+		return
+	}
+	if count >= maxNotePresets {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "too_many_presets", "max": maxNotePresets})
+		return
+	}
+
+	preset := NotePreset{Label: label, SortOrder: req.SortOrder}
+	err = db.QueryRow(`
+		INSERT INTO note_presets (organization_id, label, sort_order, created_at)
+		VALUES ($1, $2, $3, NOW())
+		RETURNING id, created_at
+	`, orgID, label, req.SortOrder).Scan(&preset.ID, &preset.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create note preset: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, preset)
+}
+
+func deleteNotePreset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireManager(w, r) {
+		return
+	}
+	_, orgID, _ := tenantContext(r)
+
+	res, err := db.Exec(`DELETE FROM note_presets WHERE id = $1 AND organization_id = $2`, mux.Vars(r)["presetId"], orgID)
+	if err != nil {
+		log.Printf("Failed to delete note preset: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "preset_not_found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// getKitchenTicket renders an order's active items as a plain-text ticket for
+// 42-column kitchen printers. ?station= limits it to one station's items.
+func getKitchenTicket(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireStaff(w, r) {
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	station := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("station")))
+
+	var header struct {
+		orderNumber  int
+		orderCode    *string
+		orderType    string
+		tableNumber  *int
+		customerName *string
+		notes        *string
+		promisedAt   *time.Time
+		createdAt    time.Time
+	}
+	err := db.QueryRow(`
+		SELECT o.order_number, o.order_code, o.order_type, t.table_number, o.customer_name, o.notes, o.promised_at, o.created_at
+		FROM orders o
+		LEFT JOIN tables t ON t.id = o.table_id
+		WHERE o.id = $1
+	`, orderID).Scan(&header.orderNumber, &header.orderCode, &header.orderType, &header.tableNumber,
+		&header.customerName, &header.notes, &header.promisedAt, &header.createdAt)
+	if err != nil {
+		log.Printf("Failed to get order: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	query := `
+		SELECT oi.quantity, oi.menu_item_name, oi.modifiers, oi.notes, COALESCE(s.station_name, '')
+		FROM order_items oi
+		LEFT JOIN v_order_item_stations s ON s.item_id = oi.id
+		WHERE oi.order_id = $1 AND oi.item_status <> 'CANCELLED' AND NOT oi.held`
+	args := []any{orderID}
+	if station != "" {
+		query += ` AND s.station_code = $2`
+		args = append(args, station)
+	}
+	query += ` ORDER BY oi.created_at`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Failed to get ticket items: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	const rule = "------------------------------------------"
+	var b strings.Builder
+	number := fmt.Sprintf("#%d", header.orderNumber)
+	if header.orderCode != nil {
+		number = *header.orderCode
+	}
+	fmt.Fprintf(&b, "ORDER %s\n", number)
+	if header.tableNumber != nil {
+		fmt.Fprintf(&b, "TABLE %d\n", *header.tableNumber)
+	} else {
+		fmt.Fprintf(&b, "%s\n", strings.ReplaceAll(header.orderType, "_", " "))
+	}
+	if header.customerName != nil {
+		fmt.Fprintf(&b, "NAME  %s\n", *header.customerName)
+	}
+	if header.promisedAt != nil {
+		fmt.Fprintf(&b, "READY BY %s\n", header.promisedAt.Format("15:04"))
+	}
+	fmt.Fprintf(&b, "%s\n", header.createdAt.Format("2006-01-02 15:04"))
+	b.WriteString(rule + "\n")
+
+	for rows.Next() {
+		var quantity int
+		var name, stationName string
+		var modifiers []byte
+		var notes *string
+		if err := rows.Scan(&quantity, &name, &modifiers, &notes, &stationName); err != nil {
+			log.Printf("Scan ticket item failed: %v", err)
+			continue
+		}
+		fmt.Fprintf(&b, "%2d x %s\n", quantity, name)
+		var mods []OrderItemModifier
+		if len(modifiers) > 0 && json.Unmarshal(modifiers, &mods) == nil {
+			for _, m := range mods {
+				fmt.Fprintf(&b, "     + %s\n", m.OptionName)
+			}
+		}
+		if notes != nil {
+			for _, line := range strings.Split(*notes, "\n") {
+				fmt.Fprintf(&b, "     ** %s\n", line)
+			}
+		}
+		if station == "" && stationName != "" {
+			fmt.Fprintf(&b, "     [%s]\n", stationName)
+		}
+	}
+
+	if header.notes != nil {
+		b.WriteString(rule + "\n")
+		b.WriteString("ORDER NOTE:\n")
+		b.WriteString(*header.notes + "\n")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
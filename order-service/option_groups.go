@@ -0,0 +1,481 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	selectionSingle   = "SINGLE"
+	selectionMultiple = "MULTIPLE"
+)
+
+// OptionGroup holds the selection rules for one named group of product options.
+// Groups without a product_option_groups row are inferred from the legacy
+// is_required flag on their options and have an empty ID.
+type OptionGroup struct {
+	ID            string          `json:"id,omitempty"`
+	ProductID     string          `json:"product_id"`
+	Name          string          `json:"name"`
+	SelectionType string          `json:"selection_type"`
+	MinSelections int             `json:"min_selections"`
+	MaxSelections *int            `json:"max_selections"`
+	SortOrder     int             `json:"sort_order"`
+	Options       []ProductOption `json:"options"`
+}
+
+type OptionGroupRequest struct {
+	Name          string                       `json:"name"`
+	SelectionType string                       `json:"selection_type"`
+	MinSelections int                          `json:"min_selections"`
+	MaxSelections *int                         `json:"max_selections"`
+	SortOrder     int                          `json:"sort_order"`
+	Options       []CreateProductOptionRequest `json:"options"`
+}
+
+// optionGroupViolation names a group whose selection rules an order line broke.
+type optionGroupViolation struct {
+	OptionGroup   string `json:"option_group"`
+	Reason        string `json:"reason"`
+	MinSelections int    `json:"min_selections"`
+	MaxSelections *int   `json:"max_selections"`
+	Selected      int    `json:"selected"`
+}
+
+// validate normalises the request and returns an error code when the rules are inconsistent.
+func (req *OptionGroupRequest) validate() string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.SelectionType = strings.ToUpper(req.SelectionType)
+	if req.SelectionType == "" {
+		req.SelectionType = selectionSingle
+	}
+	if req.Name == "" {
+		return "name_required"
+	}
+	if req.MinSelections < 0 {
+		return "invalid_min_selections"
+	}
+	switch req.SelectionType {
+	case selectionSingle:
+		one := 1
+		req.MaxSelections = &one
+		if req.MinSelections > 1 {
+			return "invalid_min_selections"
+		}
+	case selectionMultiple:
+		if req.MaxSelections != nil && (*req.MaxSelections < 1 || *req.MaxSelections < req.MinSelections) {
+			return "invalid_max_selections"
+		}
+	default:
+		return "invalid_selection_type"
+	}
+	for _, opt := range req.Options {
+		if strings.TrimSpace(opt.OptionName) == "" {
+			return "option_name_required"
+		}
+	}
+	return ""
+}
+
+// loadOptionGroups returns every option group of a product with its options,
+// merging explicit group rules with groups inferred from legacy options.
+func loadOptionGroups(q queryer, productID string) ([]OptionGroup, error) {
+	groups := map[string]*OptionGroup{}
+
+	rows, err := q.Query(`
+		SELECT id, name, selection_type, min_selections, max_selections, sort_order
+		FROM product_option_groups
+		WHERE product_id = $1
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		g := OptionGroup{ProductID: productID, Options: []ProductOption{}}
+		var maxSel sql.NullInt64
+		if err := rows.Scan(&g.ID, &g.Name, &g.SelectionType, &g.MinSelections, &maxSel, &g.SortOrder); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if maxSel.Valid {
+			max := int(maxSel.Int64)
+			g.MaxSelections = &max
+		}
+		groups[g.Name] = &g
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	optRows, err := q.Query(`
+		SELECT id, product_id, option_group, option_name, COALESCE(price_modifier, 0),
+		       COALESCE(is_required, false), COALESCE(sort_order, 0), created_at
+		FROM product_options
+		WHERE product_id = $1
+		ORDER BY option_group, sort_order
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	for optRows.Next() {
+		var opt ProductOption
+		if err := optRows.Scan(&opt.ID, &opt.ProductID, &opt.OptionGroup, &opt.OptionName,
+			&opt.PriceModifier, &opt.IsRequired, &opt.SortOrder, &opt.CreatedAt); err != nil {
+			optRows.Close()
+			return nil, err
+		}
+		g, ok := groups[opt.OptionGroup]
+		if !ok {
+			one := 1
+			g = &OptionGroup{
+				ProductID:     productID,
+				Name:          opt.OptionGroup,
+				SelectionType: selectionSingle,
+				MaxSelections: &one,
+				SortOrder:     len(groups),
+			}
+			groups[opt.OptionGroup] = g
+		}
+		// Legacy groups become required as soon as any option is flagged required.
+		if g.ID == "" && opt.IsRequired {
+			g.MinSelections = 1
+		}
+		g.Options = append(g.Options, opt)
+	}
+	optRows.Close()
+	if err := optRows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]OptionGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].SortOrder != result[j].SortOrder {
+			return result[i].SortOrder < result[j].SortOrder
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// loadProductOptions fills both the flat options list and the grouped view of a
+// product, priced for the branch when branchID is set.
+func loadProductOptions(q queryer, p *Product, branchID string) error {
+	groups, err := loadBranchOptionGroups(q, p.ID, branchID)
+	if err != nil {
+		return err
+	}
+	p.OptionGroups = groups
+	for _, g := range groups {
+		p.Options = append(p.Options, g.Options...)
+	}
+	return nil
+}
+
+// checkOptionGroups verifies the selected option ids against each group's min/max rules.
+func checkOptionGroups(groups []OptionGroup, selected map[string]bool) []optionGroupViolation {
+	var violations []optionGroupViolation
+	for _, g := range groups {
+		count := 0
+		for _, opt := range g.Options {
+			if selected[opt.ID] {
+				count++
+			}
+		}
+		reason := ""
+		if count < g.MinSelections {
+			reason = "too_few_selections"
+		} else if g.MaxSelections != nil && count > *g.MaxSelections {
+			reason = "too_many_selections"
+		}
+		if reason != "" {
+			violations = append(violations, optionGroupViolation{
+				OptionGroup:   g.Name,
+				Reason:        reason,
+				MinSelections: g.MinSelections,
+				MaxSelections: g.MaxSelections,
+				Selected:      count,
+			})
+		}
+	}
+	return violations
+}
+
+// insertOptionGroup writes a group rule row and its options inside tx.
+func insertOptionGroup(tx *sql.Tx, productID string, req OptionGroupRequest) (string, error) {
+	groupID := uuid.New().String()
+	_, err := tx.Exec(`
+		INSERT INTO product_option_groups (id, product_id, name, selection_type, min_selections, max_selections, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+	`, groupID, productID, req.Name, req.SelectionType, req.MinSelections, req.MaxSelections, req.SortOrder)
+	if err != nil {
+		return "", err
+	}
+	for _, opt := range req.Options {
+		_, err := tx.Exec(`
+			INSERT INTO product_options (id, product_id, option_group, option_name,
+			                            price_modifier, is_required, sort_order, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		`, uuid.New().String(), productID, req.Name, strings.TrimSpace(opt.OptionName),
+			opt.PriceModifier, req.MinSelections > 0, opt.SortOrder)
+		if err != nil {
+			return "", err
+		}
+	}
+	return groupID, nil
+}
+
+// requireProductInOrg checks a manager request targets a product of their organization.
+func requireProductInOrg(db *sql.DB, w http.ResponseWriter, r *http.Request, productID string) bool {
+	role := r.Header.Get("X-User-Role")
+	if role != "MANAGER" && role != "ADMIN" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "unauthorized"})
+		return false
+	}
+	_, orgID, _ := tenantContext(r)
+	var ok int
+	err := db.QueryRow(`SELECT 1 FROM products WHERE id = $1 AND organization_id = $2`, productID, orgID).Scan(&ok)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product_not_found"})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to check product: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return false
+	}
+	return true
+}
+
+func listOptionGroups(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	productID := mux.Vars(r)["id"]
+	_, orgID, _ := tenantContext(r)
+
+	var ok int
+	err := db.QueryRow(`SELECT 1 FROM products WHERE id = $1 AND organization_id = $2`, productID, orgID).Scan(&ok)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to check product: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	groups, err := loadOptionGroups(db, productID)
+	if err != nil {
+		log.Printf("Failed to load option groups: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"option_groups": groups})
+}
+
+func createOptionGroup(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	productID := mux.Vars(r)["id"]
+	if !requireProductInOrg(db, w, r, productID) {
+		return
+	}
+
+	var req OptionGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if code := req.validate(); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Legacy options already using this group name are adopted by the new rules.
+	var exists int
+	err = tx.QueryRow(`SELECT 1 FROM product_option_groups WHERE product_id = $1 AND name = $2`, productID, req.Name).Scan(&exists)
+	if err == nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "option_group_exists"})
+		return
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Failed to check option group: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	groupID, err := insertOptionGroup(tx, productID, req)
+	if err != nil {
+		log.Printf("Failed to create option group: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": groupID})
+}
+
+func updateOptionGroup(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, groupID := vars["id"], vars["groupId"]
+	if !requireProductInOrg(db, w, r, productID) {
+		return
+	}
+
+	var req OptionGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if code := req.validate(); code != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var oldName string
+	err = tx.QueryRow(`
+		SELECT name FROM product_option_groups WHERE id = $1 AND product_id = $2 FOR UPDATE
+	`, groupID, productID).Scan(&oldName)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "option_group_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get option group: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE product_option_groups
+		SET name = $1, selection_type = $2, min_selections = $3, max_selections = $4, sort_order = $5, updated_at = NOW()
+		WHERE id = $6
+	`, req.Name, req.SelectionType, req.MinSelections, req.MaxSelections, req.SortOrder, groupID)
+	if err != nil {
+		log.Printf("Failed to update option group: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	// Options are linked by group name, so a rename has to follow through.
+	_, err = tx.Exec(`
+		UPDATE product_options SET option_group = $1, is_required = $2
+		WHERE product_id = $3 AND option_group = $4
+	`, req.Name, req.MinSelections > 0, productID, oldName)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE branch_option_overrides SET option_group = $1 WHERE product_id = $2 AND option_group = $3
+		`, req.Name, productID, oldName)
+	}
+	if err != nil {
+		log.Printf("Failed to update group options: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if req.Options != nil {
+		if _, err := tx.Exec(`DELETE FROM product_options WHERE product_id = $1 AND option_group = $2`, productID, req.Name); err != nil {
+			log.Printf("Failed to replace group options: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		for _, opt := range req.Options {
+			_, err := tx.Exec(`
+				INSERT INTO product_options (id, product_id, option_group, option_name,
+				                            price_modifier, is_required, sort_order, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			`, uuid.New().String(), productID, req.Name, strings.TrimSpace(opt.OptionName),
+				opt.PriceModifier, req.MinSelections > 0, opt.SortOrder)
+			if err != nil {
+				log.Printf("Failed to create group option: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+func deleteOptionGroup(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, groupID := vars["id"], vars["groupId"]
+	if !requireProductInOrg(db, w, r, productID) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRow(`
+		DELETE FROM product_option_groups WHERE id = $1 AND product_id = $2 RETURNING name
+	`, groupID, productID).Scan(&name)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "option_group_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete option group: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM product_options WHERE product_id = $1 AND option_group = $2`, productID, name)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM branch_option_overrides WHERE product_id = $1 AND option_group = $2`, productID, name)
+	}
+	if err != nil {
+		log.Printf("Failed to delete group options: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
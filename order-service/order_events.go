@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Timeline event types written by order-service. payment-service and
+// promotion-service append their own (payment_recorded, discount_applied, ...).
+const (
+	eventOrderCreated      = "order_created"
+	eventItemAdded         = "item_added"
+	eventItemVoided        = "item_voided"
+	eventItemComped        = "item_comped"
+	eventItemStatusChanged = "item_status_changed"
+	eventStatusChanged     = "status_changed"
+	eventTableMoved        = "table_moved"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// OrderEvent is one entry of an order's timeline.
+type OrderEvent struct {
+	ID        int64           `json:"id"`
+	OrderID   string          `json:"order_id"`
+	Source    string          `json:"source"`
+	EventType string          `json:"event_type"`
+	ActorID   *string         `json:"actor_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// eventValue encodes a before/after snapshot; nil stays NULL.
+func eventValue(value any) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// eventSnapshot is the timeline view of a newly inserted line.
+func (l *pricedLine) eventSnapshot(itemID string) map[string]any {
+	return map[string]any{
+		"item_id":        itemID,
+		"menu_item_id":   l.ProductID,
+		"menu_item_name": l.ProductName,
+		"quantity":       l.Quantity,
+		"unit_price":     l.UnitPrice,
+		"item_total":     l.ItemTotal,
+		"modifiers":      l.Modifiers,
+		"item_status":    itemPending,
+		"notes":          nullable(l.Notes),
+		"course":         l.Course,
+		"held":           l.Held,
+	}
+}
+
+// recordOrderEvent appends to the order's timeline. Run it in the same
+// transaction as the change so the log never disagrees with the order.
+func recordOrderEvent(ex execer, orderID, eventType, actorID string, before, after any) error {
+	beforeValue, err := eventValue(before)
+	if err != nil {
+		return err
+	}
+	afterValue, err := eventValue(after)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(`
+		INSERT INTO order_events (order_id, source, event_type, actor_id, before_value, after_value, created_at)
+		VALUES ($1, 'order', $2, $3, $4, $5, NOW())
+	`, orderID, eventType, nullable(actorID), beforeValue, afterValue)
+	return err
+}
+
+// getOrderTimeline returns every recorded change to the order, oldest first.
+func getOrderTimeline(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireStaff(w, r) {
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, order_id, source, event_type, actor_id, before_value, after_value, created_at
+		FROM order_events
+		WHERE order_id = $1
+		ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		log.Printf("Failed to get order timeline: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	events := []OrderEvent{}
+	for rows.Next() {
+		var e OrderEvent
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.OrderID, &e.Source, &e.EventType, &e.ActorID, &before, &after, &e.CreatedAt); err != nil {
+			log.Printf("Scan order event failed: %v", err)
+			continue
+		}
+		if len(before) > 0 {
+			e.Before = before
+		}
+		if len(after) > 0 {
+			e.After = after
+		}
+		events = append(events, e)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"order_id": orderID, "events": events})
+}
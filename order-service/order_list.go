@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOrderPageSize = 50
+	maxOrderPageSize     = 200
+)
+
+// encodeOrderCursor points just past the last order of a page.
+func encodeOrderCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeOrderCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", fmt.Errorf("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	return t, id, err
+}
+
+// likePattern escapes LIKE wildcards so q is matched literally.
+func likePattern(q string) string {
+	q = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q)
+	return "%" + q + "%"
+}
+
+// listOrders pages through orders newest first. Filters: status, table_id,
+// order_type, created_by, from/to (YYYY-MM-DD, inclusive), min_total/max_total
+// and q (order number or code, customer name or phone, item names). Pass
+// next_cursor back as cursor to get the following page.
+func listOrders(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	// Orders carry customer phone numbers and addresses.
+	if !requireStaff(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	branchID, orgID, _ := tenantContext(r)
+
+	query := `SELECT o.id, o.table_id, o.order_number, o.order_code, o.order_type, o.customer_name, o.customer_phone,
+	       o.delivery_address, o.promised_at, o.picked_up_at, o.notes, o.status,
+	       o.subtotal, o.tax, o.discount_amount, o.service_charge, o.total_amount, o.version, o.menu_version_id,
+	       COALESCE(o.created_by::TEXT, ''), o.created_at, o.updated_at
+	FROM orders o WHERE 1=1`
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	badRequest := func(code string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+	}
+
+	if branchID != "" {
+		query += ` AND o.branch_id = ` + arg(branchID)
+	} else if orgID != "" {
+		query += ` AND o.organization_id = ` + arg(orgID)
+	}
+
+	if status := strings.ToUpper(params.Get("status")); status != "" {
+		query += ` AND o.status = ` + arg(status)
+	}
+	if tableID := params.Get("table_id"); tableID != "" {
+		id, err := strconv.Atoi(tableID)
+		if err != nil {
+			badRequest("invalid_table_id")
+			return
+		}
+		query += ` AND o.table_id = ` + arg(id)
+	}
+	if orderType := strings.ToUpper(params.Get("order_type")); orderType != "" {
+		if !isKnownOrderType(orderType) {
+			badRequest("invalid_order_type")
+			return
+		}
+		query += ` AND o.order_type = ` + arg(orderType)
+	}
+	if createdBy := params.Get("created_by"); createdBy != "" {
+		query += ` AND o.created_by::TEXT = ` + arg(createdBy)
+	}
+
+	if from := params.Get("from"); from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			badRequest("invalid_date")
+			return
+		}
+		query += ` AND o.created_at >= ` + arg(day)
+	}
+	if to := params.Get("to"); to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			badRequest("invalid_date")
+			return
+		}
+		query += ` AND o.created_at < ` + arg(day.AddDate(0, 0, 1))
+	}
+
+	for _, bound := range []struct{ param, op string }{{"min_total", ">="}, {"max_total", "<="}} {
+		value := params.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		total, err := strconv.ParseFloat(value, 64)
+		if err != nil || total < 0 {
+			badRequest("invalid_total")
+			return
+		}
+		query += ` AND o.total_amount ` + bound.op + ` ` + arg(total)
+	}
+
+	if q := strings.TrimSpace(params.Get("q")); q != "" {
+		pattern := arg(likePattern(q))
+		search := `o.order_code ILIKE ` + pattern +
+			` OR o.customer_name ILIKE ` + pattern +
+			` OR o.customer_phone ILIKE ` + pattern +
+			` OR EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.menu_item_name ILIKE ` + pattern + `)`
+		if n, err := strconv.Atoi(strings.TrimPrefix(q, "#")); err == nil {
+			search += ` OR o.order_number = ` + arg(n)
+		}
+		query += ` AND (` + search + `)`
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeOrderCursor(cursor)
+		if err != nil {
+			badRequest("invalid_cursor")
+			return
+		}
+		query += ` AND (o.created_at, o.id) < (` + arg(createdAt) + `, ` + arg(id) + `::UUID)`
+	}
+
+	limit := defaultOrderPageSize
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxOrderPageSize {
+			badRequest("invalid_limit")
+			return
+		}
+		limit = n
+	}
+	// One extra row tells whether another page exists.
+	query += ` ORDER BY o.created_at DESC, o.id DESC LIMIT ` + arg(limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Failed to list orders: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		var order Order
+		if err := rows.Scan(&order.ID, &order.TableID, &order.OrderNumber, &order.OrderCode, &order.OrderType, &order.CustomerName,
+			&order.CustomerPhone, &order.DeliveryAddress, &order.PromisedAt, &order.PickedUpAt, &order.Notes, &order.Status, &order.Subtotal, &order.Tax, &order.DiscountAmount, &order.ServiceCharge, &order.TotalAmount,
+			&order.Version, &order.MenuVersionID, &order.CreatedBy, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			log.Printf("Scan order failed: %v", err)
+			continue
+		}
+		orders = append(orders, order)
+	}
+
+	var nextCursor *string
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		cursor := encodeOrderCursor(last.CreatedAt, last.ID)
+		nextCursor = &cursor
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"orders": orders, "next_cursor": nextCursor})
+}
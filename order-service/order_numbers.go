@@ -0,0 +1,32 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// nextOrderNumber issues the order's number inside its transaction. Branch
+// orders use the branch counter and format (generate_order_number); the rare
+// order without a branch keeps a plain running number per organization,
+// serialized with an advisory lock.
+func nextOrderNumber(tx *sql.Tx, branchID, orgID string) (int, *string, error) {
+	if branchID != "" {
+		var seq int
+		var code string
+		err := tx.QueryRow(`SELECT seq, code FROM generate_order_number($1)`, branchID).Scan(&seq, &code)
+		if err != sql.ErrNoRows {
+			return seq, &code, err
+		}
+	}
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, "order_number:"+orgID); err != nil {
+		return 0, nil, err
+	}
+	var seq int
+	var err error
+	if orgID != "" {
+		err = tx.QueryRow(`SELECT COALESCE(MAX(order_number), 0) + 1 FROM orders WHERE branch_id IS NULL AND organization_id = $1`, orgID).Scan(&seq)
+	} else {
+		err = tx.QueryRow(`SELECT COALESCE(MAX(order_number), 0) + 1 FROM orders WHERE branch_id IS NULL AND organization_id IS NULL`).Scan(&seq)
+	}
+	return seq, nil, err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	statusOpen      = "OPEN"
+	statusConfirmed = "CONFIRMED"
+	statusCompleted = "COMPLETED"
+	statusPaid      = "PAID"
+	statusCancelled = "CANCELLED"
+)
+
+// orderTransitions is the status graph open to PUT /status. PAID and CANCELLED
+// are terminal. CONFIRMED and COMPLETED orders become PAID only through
+// payment-service, which records the payment and refuses split bills.
+var orderTransitions = map[string][]string{
+	statusOpen:      {statusConfirmed, statusCancelled},
+	statusConfirmed: {statusCompleted, statusCancelled},
+	statusCompleted: {},
+	statusPaid:      {},
+	statusCancelled: {},
+}
+
+type OrderStatusChange struct {
+	ID         string    `json:"id"`
+	OrderID    string    `json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Reason     string    `json:"reason,omitempty"`
+	ChangedBy  *string   `json:"changed_by"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// statusTransitionError reports a move the graph does not allow.
+type statusTransitionError struct {
+	Code    string   `json:"error"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Allowed []string `json:"allowed"`
+}
+
+func (e *statusTransitionError) Error() string {
+	return e.Code
+}
+
+func isKnownOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionOrderStatus locks the order, validates the move against the graph,
+// stamps the matching timestamp column and appends a history row and a
+// timeline event.
+func transitionOrderStatus(tx *sql.Tx, orderID, to, userID, reason string) (string, error) {
+	var from string
+	if err := tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&from); err != nil {
+		return "", err
+	}
+	if !canTransition(from, to) {
+		allowed := orderTransitions[from]
+		if allowed == nil {
+			allowed = []string{}
+		}
+		return from, &statusTransitionError{Code: "invalid_status_transition", From: from, To: to, Allowed: allowed}
+	}
+
+	_, err := tx.Exec(`
+		UPDATE orders
+		SET status = $1,
+		    confirmed_at = CASE WHEN $1 = 'CONFIRMED' THEN NOW() ELSE confirmed_at END,
+		    completed_at = CASE WHEN $1 = 'COMPLETED' THEN NOW() ELSE completed_at END,
+		    paid_at = CASE WHEN $1 = 'PAID' THEN NOW() ELSE paid_at END,
+		    cancelled_at = CASE WHEN $1 = 'CANCELLED' THEN NOW() ELSE cancelled_at END,
+		    updated_at = NOW()
+		WHERE id = $2
+	`, to, orderID)
+	if err != nil {
+		return from, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO order_status_history (order_id, from_status, to_status, reason, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`, orderID, from, to, nullable(reason), nullable(userID))
+	if err != nil {
+		return from, err
+	}
+
+	err = recordOrderEvent(tx, orderID, eventStatusChanged, userID,
+		map[string]string{"status": from},
+		map[string]any{"status": to, "reason": nullable(reason)})
+	return from, err
+}
+
+func getOrderStatusHistory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !requireStaff(w, r) {
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, order_id, from_status, to_status, COALESCE(reason, ''), changed_by, changed_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY changed_at
+	`, orderID)
+	if err != nil {
+		log.Printf("Failed to get status history: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	history := []OrderStatusChange{}
+	for rows.Next() {
+		var c OrderStatusChange
+		if err := rows.Scan(&c.ID, &c.OrderID, &c.FromStatus, &c.ToStatus, &c.Reason, &c.ChangedBy, &c.ChangedAt); err != nil {
+			log.Printf("Scan status history failed: %v", err)
+			continue
+		}
+		history = append(history, c)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"history": history})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	orderTypeDineIn   = "DINE_IN"
+	orderTypeTakeaway = "TAKEAWAY"
+	orderTypeDelivery = "DELIVERY"
+
+	maxCustomerNameLength    = 100
+	maxDeliveryAddressLength = 500
+
+	// Off-premise orders older than this drop off the pickup queue even if
+	// nobody marked them collected.
+	pickupQueueWindow = 12 * time.Hour
+)
+
+const (
+	pickupPreparing = "PREPARING"
+	pickupReady     = "READY"
+	pickupCollected = "COLLECTED"
+	pickupRemoved   = "REMOVED"
+)
+
+const eventOrderCollected = "order_collected"
+
+var customerPhonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,28}$`)
+
+func isKnownOrderType(orderType string) bool {
+	return orderType == orderTypeDineIn || orderType == orderTypeTakeaway || orderType == orderTypeDelivery
+}
+
+// normalizeOrderType trims the customer fields, settles the order type
+// (DINE_IN with a table, TAKEAWAY without) and returns an error code when the
+// combination is unusable.
+func (req *CreateOrderRequest) normalizeOrderType(hasTable bool) (string, string) {
+	req.CustomerName = strings.TrimSpace(req.CustomerName)
+	req.CustomerPhone = strings.TrimSpace(req.CustomerPhone)
+	req.DeliveryAddress = strings.TrimSpace(req.DeliveryAddress)
+
+	orderType := strings.ToUpper(strings.TrimSpace(req.OrderType))
+	switch {
+	case orderType == "" && hasTable:
+		orderType = orderTypeDineIn
+	case orderType == "":
+		orderType = orderTypeTakeaway
+	case !isKnownOrderType(orderType):
+		return "", "invalid_order_type"
+	}
+
+	if orderType == orderTypeDineIn {
+		if !hasTable {
+			return "", "table_required"
+		}
+		if req.DeliveryAddress != "" || req.PromisedAt != nil {
+			return "", "dine_in_has_no_pickup"
+		}
+	} else {
+		if hasTable {
+			return "", "table_not_allowed"
+		}
+		// The name is what the counter calls out and the display shows.
+		if req.CustomerName == "" {
+			return "", "customer_name_required"
+		}
+	}
+	if orderType == orderTypeDelivery {
+		if req.CustomerPhone == "" {
+			return "", "customer_phone_required"
+		}
+		if req.DeliveryAddress == "" {
+			return "", "delivery_address_required"
+		}
+	} else if req.DeliveryAddress != "" {
+		return "", "delivery_address_not_allowed"
+	}
+
+	if len(req.CustomerName) > maxCustomerNameLength {
+		return "", "customer_name_too_long"
+	}
+	if req.CustomerPhone != "" && !customerPhonePattern.MatchString(req.CustomerPhone) {
+		return "", "invalid_customer_phone"
+	}
+	if len(req.DeliveryAddress) > maxDeliveryAddressLength {
+		return "", "delivery_address_too_long"
+	}
+	if req.PromisedAt != nil && req.PromisedAt.Before(time.Now().Add(-time.Minute)) {
+		return "", "promised_at_in_past"
+	}
+	return orderType, ""
+}
+
+// PickupQueueEntry is an off-premise order as the "now serving" display sees
+// it; it carries no phone number or address.
+type PickupQueueEntry struct {
+	OrderID     string     `json:"order_id"`
+	OrderNumber int        `json:"order_number"`
+	OrderCode   *string    `json:"order_code"`
+	OrderType   string     `json:"order_type"`
+	DisplayName string     `json:"display_name"`
+	QueueStatus string     `json:"queue_status"`
+	PromisedAt  *time.Time `json:"promised_at"`
+	ReadyAt     *time.Time `json:"ready_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	branchID string
+}
+
+const pickupEntryQuery = `
+	SELECT o.id, COALESCE(o.branch_id::TEXT, ''), o.order_number, o.order_code, o.order_type, COALESCE(o.customer_name, ''), o.status,
+	       o.picked_up_at IS NOT NULL, o.promised_at, o.created_at,
+	       COUNT(oi.id) FILTER (WHERE oi.item_status <> 'CANCELLED'),
+	       COUNT(oi.id) FILTER (WHERE oi.item_status IN ('PENDING', 'PREPARING')),
+	       MAX(oi.prepared_at)
+	FROM orders o
+	LEFT JOIN order_items oi ON oi.order_id = o.id`
+
+func scanPickupEntry(row interface{ Scan(...any) error }) (*PickupQueueEntry, error) {
+	var e PickupQueueEntry
+	var customerName, status string
+	var collected bool
+	var active, waiting int
+	err := row.Scan(&e.OrderID, &e.branchID, &e.OrderNumber, &e.OrderCode, &e.OrderType, &customerName, &status,
+		&collected, &e.PromisedAt, &e.CreatedAt, &active, &waiting, &e.ReadyAt)
+	if err != nil {
+		return nil, err
+	}
+	// First name only; the display is public.
+	if fields := strings.Fields(customerName); len(fields) > 0 {
+		e.DisplayName = fields[0]
+	}
+	switch {
+	case status == statusCancelled:
+		e.QueueStatus = pickupRemoved
+	case collected:
+		e.QueueStatus = pickupCollected
+	case active > 0 && waiting == 0:
+		e.QueueStatus = pickupReady
+	default:
+		e.QueueStatus = pickupPreparing
+		e.ReadyAt = nil
+	}
+	return &e, nil
+}
+
+// publishPickupUpdate tells the order's branch pickup displays where an
+// off-premise order now stands. Dine-in orders are ignored.
+func publishPickupUpdate(db *sql.DB, orderID, orgID string) {
+	entry, err := scanPickupEntry(db.QueryRow(pickupEntryQuery+`
+		WHERE o.id = $1 AND o.order_type <> 'DINE_IN'
+		GROUP BY o.id
+	`, orderID))
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load pickup entry: %v", err)
+		return
+	}
+
+	publishEvent("pickup_queue_updated", map[string]interface{}{
+		"order_id":     entry.OrderID,
+		"order_number": entry.OrderNumber,
+		"order_code":   entry.OrderCode,
+		"order_type":   entry.OrderType,
+		"display_name": entry.DisplayName,
+		"queue_status": entry.QueueStatus,
+		"promised_at":  entry.PromisedAt,
+		"ready_at":     entry.ReadyAt,
+	}, entry.branchID, orgID)
+}
+
+// getPickupQueue lists the branch's off-premise orders that have not been
+// handed over yet, split into preparing and ready.
+func getPickupQueue(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	branchID, _, _ := tenantContext(r)
+	if branchID == "" {
+		branchID = r.URL.Query().Get("branch_id")
+	}
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	rows, err := db.Query(pickupEntryQuery+`
+		WHERE o.branch_id = $1 AND o.order_type <> 'DINE_IN' AND o.picked_up_at IS NULL
+		  AND o.status <> 'CANCELLED' AND o.created_at >= $2
+		GROUP BY o.id
+		HAVING COUNT(oi.id) FILTER (WHERE oi.item_status <> 'CANCELLED') > 0
+		ORDER BY COALESCE(o.promised_at, o.created_at), o.created_at
+	`, branchID, time.Now().Add(-pickupQueueWindow))
+	if err != nil {
+		log.Printf("Failed to get pickup queue: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	preparing := []PickupQueueEntry{}
+	ready := []PickupQueueEntry{}
+	for rows.Next() {
+		entry, err := scanPickupEntry(rows)
+		if err != nil {
+			log.Printf("Scan pickup entry failed: %v", err)
+			continue
+		}
+		if entry.QueueStatus == pickupReady {
+			ready = append(ready, *entry)
+		} else {
+			preparing = append(preparing, *entry)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"preparing": preparing, "ready": ready})
+}
+
+// collectOrder marks an off-premise order as handed to the customer or
+// driver, which takes it off the pickup queue.
+func collectOrder(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkOrderVersion(tx, w, r, orderID) {
+		return
+	}
+
+	var orderType, status string
+	var pickedUpAt *time.Time
+	err = tx.QueryRow(`SELECT order_type, status, picked_up_at FROM orders WHERE id = $1 FOR UPDATE`, orderID).
+		Scan(&orderType, &status, &pickedUpAt)
+	if err != nil {
+		log.Printf("Failed to lock order: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	switch {
+	case orderType == orderTypeDineIn:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "not_pickup_order"})
+		return
+	case status == statusCancelled:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "order_cancelled"})
+		return
+	case pickedUpAt != nil:
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "already_collected", "picked_up_at": pickedUpAt})
+		return
+	}
+
+	var collectedAt time.Time
+	if err := tx.QueryRow(`UPDATE orders SET picked_up_at = NOW(), updated_at = NOW() WHERE id = $1 RETURNING picked_up_at`, orderID).Scan(&collectedAt); err != nil {
+		log.Printf("Failed to mark order collected: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if err := recordOrderEvent(tx, orderID, eventOrderCollected, userID, nil, map[string]any{"picked_up_at": collectedAt}); err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	setOrderETag(tx, w, orderID)
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishPickupUpdate(db, orderID, orgID)
+
+	writeJSON(w, http.StatusOK, map[string]any{"order_id": orderID, "picked_up_at": collectedAt})
+}
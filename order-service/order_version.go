@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Every update of an orders row bumps orders.version (trg_orders_version), so
+// the version changes whenever the bill does: items added or voided, status,
+// discounts, table moves. Kitchen progress on items does not touch the row.
+
+func orderETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatches reports whether an If-Match header accepts the current version.
+// Only strong tags of the form "<version>" and * match.
+func ifMatches(header string, version int) bool {
+	current := orderETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrderVersion enforces If-Match on a mutating request. It locks the
+// order for the rest of the transaction and answers 412 with the current ETag
+// when the client edited a stale copy. Requests without If-Match pass.
+func checkOrderVersion(tx *sql.Tx, w http.ResponseWriter, r *http.Request, orderID string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	var version int
+	err := tx.QueryRow(`SELECT version FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&version)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to check order version: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return false
+	}
+	if !ifMatches(header, version) {
+		w.Header().Set("ETag", orderETag(version))
+		writeJSON(w, http.StatusPreconditionFailed, map[string]any{"error": "version_conflict", "current_version": version})
+		return false
+	}
+	return true
+}
+
+// setOrderETag sends the version the transaction leaves the order at. Call it
+// after the last change and before writing the response.
+func setOrderETag(q queryer, w http.ResponseWriter, orderID string) {
+	var version int
+	if err := q.QueryRow(`SELECT version FROM orders WHERE id = $1`, orderID).Scan(&version); err != nil {
+		log.Printf("Failed to read order version: %v", err)
+		return
+	}
+	w.Header().Set("ETag", orderETag(version))
+}
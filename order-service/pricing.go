@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so lookups can run inside
+// or outside a transaction.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// OrderItemModifier is the resolved option snapshot stored in order_items.modifiers.
+type OrderItemModifier struct {
+	OptionID      string  `json:"option_id"`
+	OptionGroup   string  `json:"option_group"`
+	OptionName    string  `json:"option_name"`
+	PriceModifier float64 `json:"price_modifier"`
+}
+
+// pricedLine is an order line priced from the catalog, ready to insert.
+type pricedLine struct {
+	ProductID   string
+	ProductName string
+	Quantity    int
+	UnitPrice   float64
+	ItemTotal   float64
+	Modifiers   []OrderItemModifier
+	Notes       string // kitchen note, already cleaned
+	Course      int    // 1 = first course
+	Held        bool   // kept from the kitchen until its course is fired
+
+	MenuVersionID string // published menu version priced against; "" = draft catalog
+}
+
+// orderLineError describes why a requested order line was rejected.
+type orderLineError struct {
+	Code      string `json:"error"`
+	ItemIndex int    `json:"item_index"`
+	ProductID string `json:"product_id,omitempty"`
+	OptionID  string `json:"option_id,omitempty"`
+
+	Violations []optionGroupViolation `json:"option_groups,omitempty"`
+}
+
+func (e *orderLineError) Error() string {
+	return e.Code
+}
+
+// modifiersJSON encodes the line's selections for the order_items.modifiers column.
+func (l *pricedLine) modifiersJSON() ([]byte, error) {
+	if len(l.Modifiers) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(l.Modifiers)
+}
+
+// priceOrderLine looks up the product and chosen options for one order line and
+// computes the unit price server-side, with the branch's overrides applied. Products
+// must belong to orgID and be available in the branch, and the selection must satisfy
+// every option group's min/max rules. With a published menu the line is priced
+// against it; a nil menu prices from the catalog tables.
+func priceOrderLine(q queryer, orgID, branchID string, menu *publishedMenu, index int, productID string, optionIDs []string, quantity int) (*pricedLine, error) {
+	if quantity <= 0 {
+		return nil, &orderLineError{Code: "invalid_quantity", ItemIndex: index, ProductID: productID}
+	}
+	if _, err := uuid.Parse(productID); err != nil || orgID == "" {
+		return nil, &orderLineError{Code: "product_not_found", ItemIndex: index, ProductID: productID}
+	}
+
+	line := &pricedLine{ProductID: productID, Quantity: quantity}
+	var available bool
+	var groups []OptionGroup
+	if menu != nil {
+		p, err := menu.product(q, productID, branchID)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, &orderLineError{Code: "product_not_found", ItemIndex: index, ProductID: productID}
+		}
+		line.ProductName, line.UnitPrice, available, groups = p.Name, p.Price, p.IsAvailable, p.OptionGroups
+		line.MenuVersionID = menu.ID
+	} else {
+		err := q.QueryRow(`
+			SELECT p.name, COALESCE(bo.price, p.price), COALESCE(bo.is_available, p.is_available)
+			FROM products p
+			LEFT JOIN branch_product_overrides bo ON bo.product_id = p.id AND bo.branch_id::TEXT = $3
+			WHERE p.id = $1 AND p.organization_id = $2
+		`, productID, orgID, branchID).Scan(&line.ProductName, &line.UnitPrice, &available)
+		if err == sql.ErrNoRows {
+			return nil, &orderLineError{Code: "product_not_found", ItemIndex: index, ProductID: productID}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if groups, err = loadBranchOptionGroups(q, productID, branchID); err != nil {
+			return nil, err
+		}
+	}
+	if !available {
+		return nil, &orderLineError{Code: "product_unavailable", ItemIndex: index, ProductID: productID}
+	}
+	options := map[string]ProductOption{}
+	for _, g := range groups {
+		for _, opt := range g.Options {
+			options[opt.ID] = opt
+		}
+	}
+
+	selected := map[string]bool{}
+	for _, optionID := range optionIDs {
+		opt, ok := options[optionID]
+		if !ok || selected[optionID] {
+			return nil, &orderLineError{Code: "option_not_found", ItemIndex: index, ProductID: productID, OptionID: optionID}
+		}
+		selected[optionID] = true
+		line.UnitPrice += opt.PriceModifier
+		line.Modifiers = append(line.Modifiers, OrderItemModifier{
+			OptionID:      opt.ID,
+			OptionGroup:   opt.OptionGroup,
+			OptionName:    opt.OptionName,
+			PriceModifier: opt.PriceModifier,
+		})
+	}
+
+	if violations := checkOptionGroups(groups, selected); len(violations) > 0 {
+		return nil, &orderLineError{Code: "invalid_option_selection", ItemIndex: index, ProductID: productID, Violations: violations}
+	}
+
+	if line.UnitPrice < 0 {
+		line.UnitPrice = 0
+	}
+	line.ItemTotal = line.UnitPrice * float64(quantity)
+	return line, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+)
+
+// qrLevels maps the conventional L/M/Q/H error-correction letters to the encoder levels.
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// qrTarget is what a session's QR code points at and is printed with.
+type qrTarget struct {
+	TableNumber int
+	BranchName  string
+	MenuURL     string
+}
+
+// defaultMenuBaseURL is used for branches without their own menu_base_url.
+func defaultMenuBaseURL() string {
+	if base := os.Getenv("MENU_BASE_URL"); base != "" {
+		return base
+	}
+	return "http://localhost:30080"
+}
+
+// customerMenuURL is the guest ordering link for a session token.
+func customerMenuURL(baseURL, token string, tableNumber int) string {
+	if baseURL == "" {
+		baseURL = defaultMenuBaseURL()
+	}
+	params := url.Values{}
+	params.Set("session", token)
+	params.Set("table", strconv.Itoa(tableNumber))
+	return strings.TrimRight(baseURL, "/") + "/user?" + params.Encode()
+}
+
+// loadQRTarget resolves an active session in the caller's branch or organization.
+func loadQRTarget(db *sql.DB, w http.ResponseWriter, r *http.Request) (*qrTarget, bool) {
+	id := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if branchID == "" && orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return nil, false
+	}
+
+	var target qrTarget
+	var token, baseURL string
+	var active bool
+	err := db.QueryRow(`
+		SELECT qs.qr_code_token, qs.is_active, t.table_number, b.name, b.menu_base_url
+		FROM qr_sessions qs
+		JOIN tables t ON qs.table_id = t.id
+		JOIN branches b ON t.branch_id = b.id
+		WHERE qs.id = $1 AND (b.id = $2 OR b.organization_id = $3)
+	`, id, nullable(branchID), nullable(orgID)).Scan(&token, &active, &target.TableNumber, &target.BranchName, &baseURL)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session_not_found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get qr session: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return nil, false
+	}
+	if !active {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "qr_session_closed"})
+		return nil, false
+	}
+
+	target.MenuURL = customerMenuURL(baseURL, token, target.TableNumber)
+	return &target, true
+}
+
+// parseQROptions reads ?size= (pixels) and ?level= (L, M, Q or H).
+func parseQROptions(w http.ResponseWriter, r *http.Request) (int, qrcode.RecoveryLevel, bool) {
+	size := defaultQRSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_size"})
+			return 0, 0, false
+		}
+		size = n
+	}
+
+	level := qrcode.Medium
+	if value := r.URL.Query().Get("level"); value != "" {
+		l, ok := qrLevels[strings.ToUpper(value)]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_level"})
+			return 0, 0, false
+		}
+		level = l
+	}
+	return size, level, true
+}
+
+// qrSVG renders the code as an SVG of size x size pixels, one rect per run of dark modules.
+func qrSVG(code *qrcode.QRCode, size int) string {
+	bitmap := code.Bitmap()
+	n := len(bitmap)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`, n, n)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="1" fill="#000"/>`, start, y, x-start)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func getSessionQRPNG(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	size, level, ok := parseQROptions(w, r)
+	if !ok {
+		return
+	}
+	target, ok := loadQRTarget(db, w, r)
+	if !ok {
+		return
+	}
+
+	png, err := qrcode.Encode(target.MenuURL, level, size)
+	if err != nil {
+		log.Printf("Failed to encode qr code: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "qr_encode_failed"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(png)
+}
+
+func getSessionQRSVG(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	size, level, ok := parseQROptions(w, r)
+	if !ok {
+		return
+	}
+	target, ok := loadQRTarget(db, w, r)
+	if !ok {
+		return
+	}
+
+	code, err := qrcode.New(target.MenuURL, level)
+	if err != nil {
+		log.Printf("Failed to encode qr code: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "qr_encode_failed"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(qrSVG(code, size)))
+}
+
+var tableTentTemplate = template.Must(template.New("tent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.BranchName}} - Table {{.TableNumber}}</title>
+<style>
+  @page { size: A5 portrait; margin: 12mm; }
+  body { font-family: sans-serif; text-align: center; margin: 0; color: #111; }
+  .tent { border: 2px dashed #ccc; padding: 24px; }
+  .branch { font-size: 22px; font-weight: bold; }
+  .table { font-size: 56px; font-weight: bold; margin: 12px 0; }
+  .hint { font-size: 18px; margin-top: 12px; }
+  .url { font-size: 10px; color: #666; word-break: break-all; margin-top: 8px; }
+  @media print { .tent { border: none; } }
+</style>
+</head>
+<body onload="window.print()">
+<div class="tent">
+  <div class="branch">{{.BranchName}}</div>
+  <div class="table">Table {{.TableNumber}}</div>
+  {{.QR}}
+  <div class="hint">Scan to order / สแกนเพื่อสั่งอาหาร</div>
+  <div class="url">{{.MenuURL}}</div>
+</div>
+</body>
+</html>
+`))
+
+// getSessionTableTent renders a printable HTML table tent with the branch name,
+// table number and the session's QR code.
+func getSessionTableTent(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	size, level, ok := parseQROptions(w, r)
+	if !ok {
+		return
+	}
+	target, ok := loadQRTarget(db, w, r)
+	if !ok {
+		return
+	}
+
+	code, err := qrcode.New(target.MenuURL, level)
+	if err != nil {
+		log.Printf("Failed to encode qr code: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "qr_encode_failed"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	err = tableTentTemplate.Execute(w, map[string]any{
+		"BranchName":  target.BranchName,
+		"TableNumber": target.TableNumber,
+		"MenuURL":     target.MenuURL,
+		"QR":          template.HTML(qrSVG(code, size)),
+	})
+	if err != nil {
+		log.Printf("Failed to render table tent: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// errQRSessionExpired is returned for an open session past its lifetime. The
+// table's bill stays open for the cashier, but guests can no longer order.
+var errQRSessionExpired = errors.New("qr_session_expired")
+
+// sessionSweepInterval reads QR_SESSION_SWEEP_INTERVAL (a Go duration), defaulting to one minute.
+func sessionSweepInterval() time.Duration {
+	if value := os.Getenv("QR_SESSION_SWEEP_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid QR_SESSION_SWEEP_INTERVAL %q, using 1m", value)
+	}
+	return time.Minute
+}
+
+// runSessionSweeper periodically expires QR sessions past their lifetime.
+func runSessionSweeper(db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := expireStaleSessions(db); err != nil {
+			log.Printf("Failed to expire qr sessions: %v", err)
+		}
+	}
+}
+
+// expireStaleSessions stamps expired_at on every open session whose expires_at
+// has passed and publishes qr_session_expired for each of them.
+func expireStaleSessions(db *sql.DB) error {
+	rows, err := db.Query(`
+		UPDATE qr_sessions qs
+		SET expired_at = NOW()
+		FROM tables t
+		JOIN branches b ON b.id = t.branch_id
+		WHERE t.id = qs.table_id
+		  AND qs.is_active = true
+		  AND qs.expired_at IS NULL
+		  AND qs.expires_at <= NOW()
+		RETURNING qs.id, t.table_number, b.id, b.organization_id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sessID, branchID, orgID string
+		var tableNumber int
+		if err := rows.Scan(&sessID, &tableNumber, &branchID, &orgID); err != nil {
+			return err
+		}
+		publishEvent("qr_session_expired", map[string]any{
+			"qr_session_id": sessID,
+			"table_number":  tableNumber,
+		}, branchID, orgID)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionBill is the table's combined bill: every non-cancelled order placed
+// under one QR session, with identical lines merged.
+type SessionBill struct {
+	SessionID      string             `json:"qr_session_id"`
+	TableNumber    int                `json:"table_number"`
+	IsActive       bool               `json:"is_active"`
+	OpenedAt       time.Time          `json:"opened_at"`
+	ClosedAt       *time.Time         `json:"closed_at"`
+	Orders         []SessionBillOrder `json:"orders"`
+	Items          []SessionBillLine  `json:"items"`
+	Subtotal       float64            `json:"subtotal"`
+	DiscountAmount float64            `json:"discount_amount"`
+	ServiceCharge  float64            `json:"service_charge"`
+	Tax            float64            `json:"tax"`
+	TotalAmount    float64            `json:"total_amount"`
+	Paid           float64            `json:"paid"`
+	BalanceDue     float64            `json:"balance_due"`
+}
+
+type SessionBillOrder struct {
+	ID          string  `json:"id"`
+	OrderNumber int     `json:"order_number"`
+	OrderCode   *string `json:"order_code"`
+	Status      string  `json:"status"`
+	TotalAmount float64 `json:"total_amount"`
+	Paid        float64 `json:"paid"`
+}
+
+type SessionBillLine struct {
+	MenuItemID   string              `json:"menu_item_id"`
+	MenuItemName string              `json:"menu_item_name"`
+	Quantity     int                 `json:"quantity"`
+	UnitPrice    float64             `json:"unit_price"`
+	ItemTotal    float64             `json:"item_total"`
+	Modifiers    []OrderItemModifier `json:"modifiers,omitempty"`
+}
+
+// loadSessionBill builds the bill for a session in the caller's branch or
+// organization. It returns sql.ErrNoRows when the session is out of scope.
+func loadSessionBill(q queryer, sessionID, branchID, orgID string) (*SessionBill, error) {
+	bill := &SessionBill{SessionID: sessionID, Orders: []SessionBillOrder{}, Items: []SessionBillLine{}}
+	err := q.QueryRow(`
+		SELECT t.table_number, s.is_active, s.created_at, s.closed_at
+		FROM qr_sessions s
+		JOIN tables t ON t.id = s.table_id
+		JOIN branches b ON b.id = t.branch_id
+		WHERE s.id = $1 AND (b.id = $2 OR b.organization_id = $3)
+	`, sessionID, nullable(branchID), nullable(orgID)).Scan(&bill.TableNumber, &bill.IsActive, &bill.OpenedAt, &bill.ClosedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT o.id, o.order_number, o.order_code, o.status, o.subtotal, o.discount_amount, o.service_charge, o.tax, o.total_amount,
+		       COALESCE((SELECT SUM(p.amount) FROM payments p
+		                  WHERE p.order_id = o.id AND p.status = 'SUCCESS' AND p.adjustment_id IS NULL), 0)
+		FROM orders o
+		WHERE o.qr_session_id = $1 AND o.status <> 'CANCELLED'
+		ORDER BY o.created_at
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o SessionBillOrder
+		var subtotal, discount, serviceCharge, tax float64
+		if err := rows.Scan(&o.ID, &o.OrderNumber, &o.OrderCode, &o.Status, &subtotal, &discount, &serviceCharge, &tax, &o.TotalAmount, &o.Paid); err != nil {
+			return nil, err
+		}
+		bill.Orders = append(bill.Orders, o)
+		bill.Subtotal += subtotal
+		bill.DiscountAmount += discount
+		bill.ServiceCharge += serviceCharge
+		bill.Tax += tax
+		bill.TotalAmount += o.TotalAmount
+		bill.Paid += o.Paid
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lines, err := q.Query(`
+		SELECT oi.menu_item_id, oi.menu_item_name, oi.unit_price, oi.modifiers,
+		       SUM(oi.quantity), SUM(oi.item_total)
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		WHERE o.qr_session_id = $1 AND o.status <> 'CANCELLED' AND oi.item_status <> 'CANCELLED'
+		GROUP BY oi.menu_item_id, oi.menu_item_name, oi.unit_price, oi.modifiers
+		ORDER BY MIN(oi.created_at)
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer lines.Close()
+	for lines.Next() {
+		var line SessionBillLine
+		var modifiers []byte
+		if err := lines.Scan(&line.MenuItemID, &line.MenuItemName, &line.UnitPrice, &modifiers, &line.Quantity, &line.ItemTotal); err != nil {
+			return nil, err
+		}
+		if len(modifiers) > 0 {
+			if err := json.Unmarshal(modifiers, &line.Modifiers); err != nil {
+				log.Printf("Failed to decode modifiers: %v", err)
+			}
+		}
+		bill.Items = append(bill.Items, line)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, amount := range []*float64{&bill.Subtotal, &bill.DiscountAmount, &bill.ServiceCharge, &bill.Tax, &bill.TotalAmount, &bill.Paid} {
+		*amount = math.Round(*amount*100) / 100
+	}
+	bill.BalanceDue = math.Max(math.Round((bill.TotalAmount-bill.Paid)*100)/100, 0)
+	return bill, nil
+}
+
+func getSessionBill(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	branchID, orgID, _ := tenantContext(r)
+	if branchID == "" && orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	bill, err := loadSessionBill(db, id, branchID, orgID)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to build session bill: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bill)
+}
+
+// closeQRSession closes the table session and returns its final bill. A
+// session with an unpaid balance stays open unless a manager forces the close
+// with a reason.
+func closeQRSession(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	branchID, orgID, userID := tenantContext(r)
+	if branchID == "" && orgID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	var req struct {
+		Force  bool   `json:"force"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the session row so concurrent closes produce a single final bill.
+	var active bool
+	err = tx.QueryRow(`SELECT is_active FROM qr_sessions WHERE id = $1 FOR UPDATE`, id).Scan(&active)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock qr session: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	bill, err := loadSessionBill(tx, id, branchID, orgID)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to build session bill: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	override := ""
+	if bill.BalanceDue > 0 {
+		role := r.Header.Get("X-User-Role")
+		if !req.Force || (role != "MANAGER" && role != "ADMIN") {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": "balance_outstanding", "balance_due": bill.BalanceDue})
+			return
+		}
+		if req.Reason == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reason_required"})
+			return
+		}
+		override = req.Reason
+	}
+
+	now := time.Now()
+	bill.IsActive = false
+	bill.ClosedAt = &now
+	snapshot, err := json.Marshal(bill)
+	if err != nil {
+		log.Printf("Failed to encode session bill: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE qr_sessions
+		SET is_active = false, closed_at = $2, closed_by = $3, final_bill = $4, close_override_reason = $5
+		WHERE id = $1
+	`, id, now, nullable(userID), snapshot, nullable(override))
+	if err != nil {
+		log.Printf("Failed to close qr session: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	publishEvent("qr_session_closed", map[string]any{
+		"qr_session_id": id,
+		"closed_by":     userID,
+		"total_amount":  bill.TotalAmount,
+		"balance_due":   bill.BalanceDue,
+		"override":      override != "",
+	}, branchID, orgID)
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "closed", "bill": bill})
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	moveTransfer = "TRANSFER"
+	moveMerge    = "MERGE"
+
+	// maxMergeHops bounds how far a merged session token is followed.
+	maxMergeHops = 8
+)
+
+type TableMoveRequest struct {
+	FromTableNumber int    `json:"from_table_number"`
+	ToTableNumber   int    `json:"to_table_number"`
+	ReissueToken    bool   `json:"reissue_token"`
+	Reason          string `json:"reason"`
+}
+
+type TableMove struct {
+	ID              string       `json:"id"`
+	Kind            string       `json:"kind"`
+	FromTableNumber int          `json:"from_table_number"`
+	ToTableNumber   int          `json:"to_table_number"`
+	OrderIDs        []string     `json:"order_ids"`
+	QRSessionID     *string      `json:"qr_session_id"`
+	Token           string       `json:"token,omitempty"`
+	MenuURL         string       `json:"menu_url,omitempty"`
+	TokenReissued   bool         `json:"token_reissued"`
+	Bill            *SessionBill `json:"bill,omitempty"`
+}
+
+// lockedSession is an active session on one of the two tables of a move.
+type lockedSession struct {
+	ID      string
+	TableID int
+}
+
+// lookupTable finds a table by number within the branch without creating it.
+func lookupTable(db *sql.DB, branchID string, tableNumber int) (int, error) {
+	var tableID int
+	err := db.QueryRow(`SELECT id FROM tables WHERE branch_id = $1 AND table_number = $2`, branchID, tableNumber).Scan(&tableID)
+	return tableID, err
+}
+
+// lockTableSessions locks the active sessions of both tables, oldest first,
+// in one statement so concurrent moves cannot deadlock.
+func lockTableSessions(tx *sql.Tx, fromID, toID int) (from, to []lockedSession, err error) {
+	rows, err := tx.Query(`
+		SELECT id, table_id FROM qr_sessions
+		WHERE table_id IN ($1, $2) AND is_active = true
+		ORDER BY created_at, id
+		FOR UPDATE
+	`, fromID, toID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s lockedSession
+		if err := rows.Scan(&s.ID, &s.TableID); err != nil {
+			return nil, nil, err
+		}
+		if s.TableID == fromID {
+			from = append(from, s)
+		} else {
+			to = append(to, s)
+		}
+	}
+	return from, to, rows.Err()
+}
+
+// lockOpenTableOrders locks the unpaid, non-cancelled orders of a table.
+func lockOpenTableOrders(tx *sql.Tx, tableID int) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT id FROM orders
+		WHERE table_id = $1 AND status NOT IN ('PAID', 'CANCELLED')
+		ORDER BY created_at
+		FOR UPDATE
+	`, tableID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// activeSplitCovering returns an ACTIVE bill split over any of the orders.
+func activeSplitCovering(q queryer, orderIDs []string) (string, error) {
+	var splitID string
+	err := q.QueryRow(`
+		SELECT bs.id FROM bill_splits bs
+		JOIN bill_split_orders bso ON bso.split_id = bs.id
+		WHERE bso.order_id = ANY($1) AND bs.status = 'ACTIVE'
+		LIMIT 1
+	`, pq.Array(orderIDs)).Scan(&splitID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return splitID, err
+}
+
+func transferTable(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	moveTable(db, w, r, moveTransfer)
+}
+
+func mergeTables(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	moveTable(db, w, r, moveMerge)
+}
+
+// moveTable moves the open orders and active sessions of one table to another.
+//
+// TRANSFER needs a free target table; the sessions move with the guests and
+// keep their tokens unless reissue_token is set. MERGE joins the source orders
+// to the target table's oldest session (or moves the source session over when
+// the target only has staff orders) and closes the other source sessions with
+// merged_into, so their QR codes keep working. With reissue_token the surviving
+// session gets a new token and old codes stop working.
+func moveTable(db *sql.DB, w http.ResponseWriter, r *http.Request, kind string) {
+	branchID, orgID, userID := tenantContext(r)
+	if branchID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch_required"})
+		return
+	}
+
+	var req TableMoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.FromTableNumber <= 0 || req.ToTableNumber <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "table_number_required"})
+		return
+	}
+	if req.FromTableNumber == req.ToTableNumber {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "same_table"})
+		return
+	}
+
+	fromID, err := lookupTable(db, branchID, req.FromTableNumber)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "table_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get table: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	toID, err := ensureTable(db, branchID, req.ToTableNumber)
+	if err != nil {
+		log.Printf("ensureTable failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	fromSessions, toSessions, err := lockTableSessions(tx, fromID, toID)
+	if err != nil {
+		log.Printf("Failed to lock table sessions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	orderIDs, err := lockOpenTableOrders(tx, fromID)
+	if err != nil {
+		log.Printf("Failed to lock table orders: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if len(orderIDs) == 0 && len(fromSessions) == 0 {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "nothing_to_move"})
+		return
+	}
+	if orderIDs == nil {
+		orderIDs = []string{}
+	}
+
+	// A split bill is priced against the current orders; moving them would
+	// leave its shares out of date.
+	if splitID, err := activeSplitCovering(tx, orderIDs); err != nil {
+		log.Printf("Failed to check bill splits: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	} else if splitID != "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_in_progress", "split_id": splitID})
+		return
+	}
+
+	var survivor *lockedSession
+	var fromSessionID *string
+	if len(fromSessions) > 0 {
+		fromSessionID = &fromSessions[0].ID
+	}
+	var merged []lockedSession
+
+	switch kind {
+	case moveTransfer:
+		if len(toSessions) > 0 {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "table_occupied"})
+			return
+		}
+		var targetOrders int
+		if err := tx.QueryRow(`
+			SELECT COUNT(*) FROM orders WHERE table_id = $1 AND status NOT IN ('PAID', 'CANCELLED')
+		`, toID).Scan(&targetOrders); err != nil {
+			log.Printf("Failed to check target table: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		if targetOrders > 0 {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "table_occupied"})
+			return
+		}
+		if len(fromSessions) > 0 {
+			survivor = &fromSessions[0]
+		}
+		// Every session at the table follows the guests.
+		for _, s := range fromSessions {
+			if _, err := tx.Exec(`UPDATE qr_sessions SET table_id = $1 WHERE id = $2`, toID, s.ID); err != nil {
+				log.Printf("Failed to move qr session: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		}
+		if _, err := tx.Exec(`UPDATE orders SET table_id = $1, updated_at = NOW() WHERE id = ANY($2)`, toID, pq.Array(orderIDs)); err != nil {
+			log.Printf("Failed to move orders: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+
+	case moveMerge:
+		switch {
+		case len(toSessions) > 0:
+			survivor = &toSessions[0]
+			merged = fromSessions
+		case len(fromSessions) > 0:
+			survivor = &fromSessions[0]
+			merged = fromSessions[1:]
+			if _, err := tx.Exec(`UPDATE qr_sessions SET table_id = $1 WHERE id = $2`, toID, survivor.ID); err != nil {
+				log.Printf("Failed to move qr session: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		}
+		if survivor == nil {
+			var targetOrders int
+			if err := tx.QueryRow(`
+				SELECT COUNT(*) FROM orders WHERE table_id = $1 AND status NOT IN ('PAID', 'CANCELLED')
+			`, toID).Scan(&targetOrders); err != nil {
+				log.Printf("Failed to check target table: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if targetOrders == 0 {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "target_table_empty"})
+				return
+			}
+		}
+
+		var survivorID interface{}
+		if survivor != nil {
+			survivorID = survivor.ID
+		}
+		_, err := tx.Exec(`
+			UPDATE orders SET table_id = $1, qr_session_id = COALESCE($2, qr_session_id), updated_at = NOW()
+			WHERE id = ANY($3)
+		`, toID, survivorID, pq.Array(orderIDs))
+		if err != nil {
+			log.Printf("Failed to move orders: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+
+		// Any other orders still pointing at a merged session join the survivor.
+		for _, s := range merged {
+			if _, err := tx.Exec(`UPDATE orders SET qr_session_id = $1, updated_at = NOW() WHERE qr_session_id = $2`, survivor.ID, s.ID); err != nil {
+				log.Printf("Failed to re-point session orders: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			var mergedInto interface{}
+			if !req.ReissueToken {
+				mergedInto = survivor.ID
+			}
+			_, err := tx.Exec(`
+				UPDATE qr_sessions
+				SET is_active = false, closed_at = NOW(), closed_by = $2, merged_into = $3
+				WHERE id = $1
+			`, s.ID, nullable(userID), mergedInto)
+			if err != nil {
+				log.Printf("Failed to close merged session: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		}
+	}
+
+	move := TableMove{
+		ID:              uuid.New().String(),
+		Kind:            kind,
+		FromTableNumber: req.FromTableNumber,
+		ToTableNumber:   req.ToTableNumber,
+		OrderIDs:        orderIDs,
+		TokenReissued:   req.ReissueToken && survivor != nil,
+	}
+
+	if survivor != nil {
+		move.QRSessionID = &survivor.ID
+		if move.TokenReissued {
+			move.Token = uuid.New().String()
+			if _, err := tx.Exec(`UPDATE qr_sessions SET qr_code_token = $1 WHERE id = $2`, move.Token, survivor.ID); err != nil {
+				log.Printf("Failed to reissue qr token: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		} else if err := tx.QueryRow(`SELECT qr_code_token FROM qr_sessions WHERE id = $1`, survivor.ID).Scan(&move.Token); err != nil {
+			log.Printf("Failed to get qr token: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		var menuBaseURL string
+		if err := tx.QueryRow(`SELECT menu_base_url FROM branches WHERE id = $1`, branchID).Scan(&menuBaseURL); err != nil {
+			log.Printf("Failed to get branch menu url: %v", err)
+		}
+		move.MenuURL = customerMenuURL(menuBaseURL, move.Token, req.ToTableNumber)
+	}
+
+	for _, orderID := range orderIDs {
+		if _, err := recalculateOrderTotals(tx, orderID); err != nil {
+			log.Printf("Failed to recalculate order totals: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		err := recordOrderEvent(tx, orderID, eventTableMoved, userID,
+			map[string]any{"table_number": req.FromTableNumber, "qr_session_id": fromSessionID},
+			map[string]any{"table_number": req.ToTableNumber, "qr_session_id": move.QRSessionID, "kind": kind, "move_id": move.ID, "reason": nullable(req.Reason)})
+		if err != nil {
+			log.Printf("Failed to record order event: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO table_moves (id, organization_id, branch_id, kind, from_table_id, to_table_id,
+		                         from_qr_session_id, to_qr_session_id, order_ids, token_reissued, reason, moved_by, moved_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+	`, move.ID, nullable(orgID), branchID, kind, fromID, toID,
+		nullablePtr(fromSessionID), nullablePtr(move.QRSessionID), pq.Array(orderIDs), move.TokenReissued,
+		nullable(req.Reason), nullable(userID))
+	if err != nil {
+		log.Printf("Failed to record table move: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if move.QRSessionID != nil {
+		move.Bill, err = loadSessionBill(tx, *move.QRSessionID, branchID, orgID)
+		if err != nil {
+			log.Printf("Failed to build session bill: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	eventType := "table_transferred"
+	if kind == moveMerge {
+		eventType = "tables_merged"
+	}
+	mergedIDs := make([]string, len(merged))
+	for i, s := range merged {
+		mergedIDs[i] = s.ID
+	}
+	publishEvent(eventType, map[string]any{
+		"move_id":            move.ID,
+		"from_table_number":  req.FromTableNumber,
+		"to_table_number":    req.ToTableNumber,
+		"order_ids":          orderIDs,
+		"qr_session_id":      move.QRSessionID,
+		"merged_session_ids": mergedIDs,
+		"token_reissued":     move.TokenReissued,
+		"moved_by":           userID,
+	}, branchID, orgID)
+
+	writeJSON(w, http.StatusOK, move)
+}
@@ -0,0 +1,21 @@
+package main
+
+// OrderTotals are the cached money columns of an order after recalculation.
+type OrderTotals struct {
+	Subtotal      float64 `json:"subtotal"`
+	ServiceCharge float64 `json:"service_charge"`
+	Tax           float64 `json:"tax"`
+	TotalAmount   float64 `json:"total_amount"`
+}
+
+// recalculateOrderTotals recomputes an order's subtotal, service charge, tax and
+// total from its non-cancelled line items and branch tax settings. It must run in
+// the same transaction as the change that triggered it.
+func recalculateOrderTotals(q queryer, orderID string) (OrderTotals, error) {
+	var totals OrderTotals
+	err := q.QueryRow(`
+		SELECT new_subtotal, new_service_charge, new_tax, new_total
+		FROM recalc_order_totals($1)
+	`, orderID).Scan(&totals.Subtotal, &totals.ServiceCharge, &totals.Tax, &totals.TotalAmount)
+	return totals, err
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	voidTypeVoid = "VOID"
+	voidTypeComp = "COMP"
+)
+
+// Wrong approval PINs count against the user who entered them and their
+// branch; past either limit within the window, PIN approval is locked.
+const (
+	maxUserPINFailures    = 5
+	maxBranchPINFailures  = 20
+	approvalLockoutWindow = 15 * time.Minute
+)
+
+// voidReasonCodes are the accepted reason codes per operation. OTHER needs a note.
+var voidReasonCodes = map[string][]string{
+	voidTypeVoid: {"ENTRY_ERROR", "CUSTOMER_CHANGED_MIND", "DUPLICATE", "OUT_OF_STOCK", "OTHER"},
+	voidTypeComp: {"QUALITY_ISSUE", "LONG_WAIT", "GOODWILL", "STAFF_MEAL", "OTHER"},
+}
+
+type VoidItemRequest struct {
+	ReasonCode  string `json:"reason_code"`
+	Note        string `json:"note"`
+	ApprovalPIN string `json:"approval_pin"`
+}
+
+type VoidReportLine struct {
+	ItemID       string    `json:"item_id"`
+	OrderID      string    `json:"order_id"`
+	OrderNumber  int       `json:"order_number"`
+	MenuItemName string    `json:"menu_item_name"`
+	Quantity     int       `json:"quantity"`
+	Amount       float64   `json:"amount"`
+	VoidType     string    `json:"void_type"`
+	ReasonCode   string    `json:"reason_code"`
+	Note         *string   `json:"note"`
+	VoidedBy     *string   `json:"voided_by"`
+	ApprovedBy   *string   `json:"approved_by"`
+	CancelledAt  time.Time `json:"cancelled_at"`
+}
+
+type VoidReasonTotal struct {
+	VoidType   string  `json:"void_type"`
+	ReasonCode string  `json:"reason_code"`
+	Count      int     `json:"count"`
+	Quantity   int     `json:"quantity"`
+	Amount     float64 `json:"amount"`
+}
+
+func validVoidReason(voidType, code string) bool {
+	for _, c := range voidReasonCodes[voidType] {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// itemSentToKitchen reports whether the kitchen has started on the item;
+// voiding it from then on needs a manager.
+func itemSentToKitchen(status string) bool {
+	return status != itemPending
+}
+
+// findApprover returns the active manager or admin of the order's organization
+// or branch whose approval PIN matches, or "" when none does. Pass the IDs
+// stored on the order, not the caller's headers.
+func findApprover(q queryer, orgID, branchID, pin string) (string, error) {
+	rows, err := q.Query(`
+		SELECT id, approval_pin_hash FROM users
+		WHERE is_active = true
+		  AND UPPER(role) IN ('MANAGER', 'ADMIN')
+		  AND approval_pin_hash IS NOT NULL
+		  AND (organization_id = $1 OR branch_id = $2)
+	`, nullable(orgID), nullable(branchID))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return "", err
+		}
+		// users.approval_pin_hash is the bcrypt hash auth-service stores
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pin)) == nil {
+			return id, nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// approvalLockedOut reports whether recent wrong PINs lock approval for the
+// user or the branch.
+func approvalLockedOut(q queryer, userID, branchID string) (bool, error) {
+	var userFailures, branchFailures int
+	err := q.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE user_id = $1), COUNT(*) FILTER (WHERE branch_id = $2)
+		FROM approval_pin_failures
+		WHERE (user_id = $1 OR branch_id = $2)
+		  AND attempted_at > NOW() - make_interval(secs => $3)
+	`, nullable(userID), nullable(branchID), approvalLockoutWindow.Seconds()).Scan(&userFailures, &branchFailures)
+	if err != nil {
+		return false, err
+	}
+	return userFailures >= maxUserPINFailures || branchFailures >= maxBranchPINFailures, nil
+}
+
+// recordApprovalFailure logs a wrong PIN. It runs outside the void's
+// transaction, which is rolled back.
+func recordApprovalFailure(db *sql.DB, orgID, branchID, userID, orderID, itemID string) {
+	log.Printf("Approval PIN rejected: user %q, branch %q, order %s, item %s", userID, branchID, orderID, itemID)
+	_, err := db.Exec(`
+		INSERT INTO approval_pin_failures (organization_id, branch_id, user_id, order_id, item_id, attempted_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`, nullable(orgID), nullable(branchID), nullable(userID), orderID, itemID)
+	if err != nil {
+		log.Printf("Failed to record approval PIN failure: %v", err)
+	}
+}
+
+func voidOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	cancelOrderItem(db, w, r, voidTypeVoid, "")
+}
+
+func compOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	cancelOrderItem(db, w, r, voidTypeComp, "")
+}
+
+// removeOrderItem is kept for older clients; it voids the item as an entry error
+// unless the body says otherwise.
+func removeOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	cancelOrderItem(db, w, r, voidTypeVoid, "ENTRY_ERROR")
+}
+
+// cancelOrderItem voids or comps one item: the row stays, marked CANCELLED with
+// who, why and when, and the order totals are recalculated. Comps and voids of
+// items the kitchen has started need a manager, either as the caller or through
+// approval_pin.
+func cancelOrderItem(db *sql.DB, w http.ResponseWriter, r *http.Request, voidType, defaultReason string) {
+	vars := mux.Vars(r)
+	orderID := vars["id"]
+	itemID := vars["itemId"]
+	branchID, orgID, userID := tenantContext(r)
+	if err := guardOrderScope(db, orderID, branchID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+			return
+		}
+		log.Printf("Scope check failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var req VoidItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.ReasonCode = strings.ToUpper(strings.TrimSpace(req.ReasonCode))
+	req.Note = strings.TrimSpace(req.Note)
+	if req.ReasonCode == "" {
+		req.ReasonCode = defaultReason
+	}
+	if !validVoidReason(voidType, req.ReasonCode) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_reason_code", "allowed": voidReasonCodes[voidType]})
+		return
+	}
+	if req.ReasonCode == "OTHER" && req.Note == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "note_required"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkOrderVersion(tx, w, r, orderID) {
+		return
+	}
+
+	var orderStatus, orderOrgID, orderBranchID string
+	if err := tx.QueryRow(`
+		SELECT status, COALESCE(organization_id::TEXT, ''), COALESCE(branch_id::TEXT, '')
+		FROM orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&orderStatus, &orderOrgID, &orderBranchID); err != nil {
+		log.Printf("Failed to lock order: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if orderStatus == statusPaid || orderStatus == statusCancelled {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "order_closed", "status": orderStatus})
+		return
+	}
+	if splitID, err := activeSplitCovering(tx, []string{orderID}); err != nil {
+		log.Printf("Failed to check bill splits: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	} else if splitID != "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_in_progress", "split_id": splitID})
+		return
+	}
+
+	change := ItemStatusChange{ItemID: itemID, ToStatus: itemCancelled}
+	var quantity int
+	var amount float64
+	err = tx.QueryRow(`
+		SELECT menu_item_name, item_status, quantity, item_total
+		FROM order_items
+		WHERE id = $1 AND order_id = $2
+		FOR UPDATE
+	`, itemID, orderID).Scan(&change.MenuItemName, &change.FromStatus, &quantity, &amount)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "item_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get item: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if change.FromStatus == itemCancelled {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "item_already_cancelled"})
+		return
+	}
+
+	var approvedBy string
+	if voidType == voidTypeComp || itemSentToKitchen(change.FromStatus) {
+		role := r.Header.Get("X-User-Role")
+		switch {
+		case role == "MANAGER" || role == "ADMIN":
+			approvedBy = userID
+		case req.ApprovalPIN != "":
+			locked, err := approvalLockedOut(tx, userID, orderBranchID)
+			if err != nil {
+				log.Printf("Failed to check approval lockout: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if locked {
+				writeJSON(w, http.StatusTooManyRequests, map[string]any{
+					"error":         "approval_pin_locked",
+					"retry_minutes": int(approvalLockoutWindow.Minutes()),
+				})
+				return
+			}
+			approvedBy, err = findApprover(tx, orderOrgID, orderBranchID, req.ApprovalPIN)
+			if err != nil {
+				log.Printf("Failed to check approval pin: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+			if approvedBy == "" {
+				recordApprovalFailure(db, orderOrgID, orderBranchID, userID, orderID, itemID)
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid_approval_pin"})
+				return
+			}
+		default:
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "manager_approval_required"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE order_items
+		SET item_status = 'CANCELLED', cancelled_at = NOW(), void_type = $1, void_reason_code = $2,
+		    void_note = $3, voided_by = $4, void_approved_by = $5
+		WHERE id = $6
+	`, voidType, req.ReasonCode, nullable(req.Note), nullable(userID), nullable(approvedBy), itemID)
+	if err != nil {
+		log.Printf("Failed to void item: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	totals, err := recalculateOrderTotals(tx, orderID)
+	if err != nil {
+		log.Printf("Failed to update order totals: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	eventType := eventItemVoided
+	if voidType == voidTypeComp {
+		eventType = eventItemComped
+	}
+	err = recordOrderEvent(tx, orderID, eventType, userID, map[string]any{
+		"item_id":        itemID,
+		"menu_item_name": change.MenuItemName,
+		"quantity":       quantity,
+		"item_total":     amount,
+		"item_status":    change.FromStatus,
+	}, map[string]any{
+		"item_id":     itemID,
+		"item_status": itemCancelled,
+		"void_type":   voidType,
+		"reason_code": req.ReasonCode,
+		"note":        nullable(req.Note),
+		"approved_by": nullable(approvedBy),
+		"totals":      totals,
+	})
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	setOrderETag(tx, w, orderID)
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	// Kitchen screens drop the item; cashier screens pick up the new total.
+	publishItemStatusChanges(db, orderID, []ItemStatusChange{change}, userID, branchID, orgID)
+	publishEvent("order_item_voided", map[string]any{
+		"order_id":       orderID,
+		"item_id":        itemID,
+		"menu_item_name": change.MenuItemName,
+		"void_type":      voidType,
+		"reason_code":    req.ReasonCode,
+		"amount":         amount,
+		"voided_by":      userID,
+		"approved_by":    approvedBy,
+		"total_amount":   totals.TotalAmount,
+	}, branchID, orgID)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":      itemCancelled,
+		"void_type":   voidType,
+		"reason_code": req.ReasonCode,
+		"approved_by": nullable(approvedBy),
+		"totals":      totals,
+	})
+}
+
+// getVoidsReport lists voided and comped items between from and to
+// (YYYY-MM-DD, default last 30 days) with totals per reason code.
+func getVoidsReport(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	branchID, orgID, _ := tenantContext(r)
+
+	if from == "" {
+		from = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+	if to == "" {
+		to = time.Now().Format("2006-01-02")
+	}
+
+	query := `
+		SELECT oi.id, o.id, o.order_number, oi.menu_item_name, oi.quantity, oi.item_total,
+		       oi.void_type, oi.void_reason_code, oi.void_note, vu.name, au.name, oi.cancelled_at
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		LEFT JOIN users vu ON vu.id = oi.voided_by
+		LEFT JOIN users au ON au.id = oi.void_approved_by
+		WHERE oi.void_type IS NOT NULL
+			AND DATE(oi.cancelled_at) BETWEEN $1 AND $2
+	`
+	args := []interface{}{from, to}
+	if branchID != "" {
+		query += " AND o.branch_id = $3"
+		args = append(args, branchID)
+	} else if orgID != "" {
+		query += " AND o.organization_id = $3"
+		args = append(args, orgID)
+	}
+	query += " ORDER BY oi.cancelled_at DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Failed to get voids report: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	lines := []VoidReportLine{}
+	byReason := []VoidReasonTotal{}
+	reasonIndex := map[string]int{}
+	totals := map[string]float64{voidTypeVoid: 0, voidTypeComp: 0}
+	for rows.Next() {
+		var l VoidReportLine
+		err := rows.Scan(&l.ItemID, &l.OrderID, &l.OrderNumber, &l.MenuItemName, &l.Quantity, &l.Amount,
+			&l.VoidType, &l.ReasonCode, &l.Note, &l.VoidedBy, &l.ApprovedBy, &l.CancelledAt)
+		if err != nil {
+			log.Printf("Failed to scan row: %v", err)
+			continue
+		}
+		lines = append(lines, l)
+
+		key := l.VoidType + "/" + l.ReasonCode
+		i, ok := reasonIndex[key]
+		if !ok {
+			i = len(byReason)
+			reasonIndex[key] = i
+			byReason = append(byReason, VoidReasonTotal{VoidType: l.VoidType, ReasonCode: l.ReasonCode})
+		}
+		byReason[i].Count++
+		byReason[i].Quantity += l.Quantity
+		byReason[i].Amount = math.Round((byReason[i].Amount+l.Amount)*100) / 100
+		totals[l.VoidType] = math.Round((totals[l.VoidType]+l.Amount)*100) / 100
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":        from,
+		"to":          to,
+		"void_amount": totals[voidTypeVoid],
+		"comp_amount": totals[voidTypeComp],
+		"by_reason":   byReason,
+		"items":       lines,
+	})
+}
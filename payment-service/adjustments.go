@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Adjustments of PAID orders are created by order-service
+// (POST /api/orders/{id}/adjustments); payment-service settles them: a CHARGE
+// is paid like a bill, a REFUND through its refund request.
+
+const (
+	eventAdjustmentSettled = "adjustment_settled"
+	eventRefundCompleted   = "refund_completed"
+)
+
+type Refund struct {
+	ID           string     `json:"id"`
+	AdjustmentID string     `json:"adjustment_id"`
+	OrderID      string     `json:"order_id"`
+	Amount       float64    `json:"amount"`
+	Status       string     `json:"status"`
+	RefundMethod *string    `json:"refund_method"`
+	Reference    *string    `json:"reference"`
+	Reason       string     `json:"reason"`
+	RequestedBy  *string    `json:"requested_by"`
+	ProcessedBy  *string    `json:"processed_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+}
+
+type PayAdjustmentRequest struct {
+	PaymentMethod  string `json:"payment_method"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type CompleteRefundRequest struct {
+	RefundMethod string `json:"refund_method"`
+	Reference    string `json:"reference"`
+}
+
+func isManager(r *http.Request) bool {
+	role := r.Header.Get("X-User-Role")
+	return role == "MANAGER" || role == "ADMIN"
+}
+
+// payAdjustment collects the supplementary charge of a CHARGE adjustment. The
+// payment belongs to the original order but is tagged with the adjustment, so
+// the paid bill's own balance is unaffected.
+func payAdjustment(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	adjustmentID := mux.Vars(r)["id"]
+	userID := r.Header.Get("X-User-ID")
+
+	var req PayAdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if req.PaymentMethod == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing_fields"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var orderID, adjustmentType, status string
+	var amount float64
+	err = tx.QueryRow(`
+		SELECT order_id, adjustment_type, status, total_amount
+		FROM order_adjustments WHERE id = $1
+		FOR UPDATE
+	`, adjustmentID).Scan(&orderID, &adjustmentType, &status, &amount)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "adjustment_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if adjustmentType != "CHARGE" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "not_a_charge", "adjustment_type": adjustmentType})
+		return
+	}
+	if status != "PENDING" {
+		// A retried request with the same key gets the original result.
+		var paymentID string
+		if req.IdempotencyKey != "" && status == "SETTLED" {
+			err := tx.QueryRow(`
+				SELECT id FROM payments WHERE adjustment_id = $1 AND external_payment_id = $2
+			`, adjustmentID, req.IdempotencyKey).Scan(&paymentID)
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Failed to look up payment: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
+		}
+		if paymentID != "" {
+			writeJSON(w, http.StatusOK, map[string]any{"payment_id": paymentID, "adjustment_id": adjustmentID, "status": "SETTLED"})
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "adjustment_not_pending", "status": status})
+		return
+	}
+
+	now := time.Now()
+	var paymentID string
+	err = tx.QueryRow(`
+		INSERT INTO payments (order_id, amount, payment_method, status, external_payment_id, adjustment_id, created_at, completed_at)
+		VALUES ($1, $2, $3, 'SUCCESS', $4, $5, $6, $6)
+		RETURNING id
+	`, orderID, amount, req.PaymentMethod, nullable(req.IdempotencyKey), adjustmentID, now).Scan(&paymentID)
+	if err != nil {
+		log.Printf("Failed to create payment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if _, err := tx.Exec(`UPDATE order_adjustments SET status = 'SETTLED', settled_at = $1 WHERE id = $2`, now, adjustmentID); err != nil {
+		log.Printf("Failed to settle adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	err = recordOrderEvent(tx, orderID, eventPaymentRecorded, userID, nil, map[string]any{
+		"payment_id":     paymentID,
+		"amount":         amount,
+		"payment_method": req.PaymentMethod,
+		"status":         "SUCCESS",
+		"adjustment_id":  adjustmentID,
+	})
+	if err == nil {
+		err = recordOrderEvent(tx, orderID, eventAdjustmentSettled, userID,
+			map[string]string{"adjustment_id": adjustmentID, "status": status},
+			map[string]string{"adjustment_id": adjustmentID, "status": "SETTLED"})
+	}
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"payment_id":    paymentID,
+		"adjustment_id": adjustmentID,
+		"order_id":      orderID,
+		"amount":        amount,
+		"status":        "SETTLED",
+	})
+}
+
+// listRefunds is the managers' refund queue; ?status= defaults to REQUESTED.
+func listRefunds(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !isManager(r) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "unauthorized"})
+		return
+	}
+	status := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("status")))
+	if status == "" {
+		status = "REQUESTED"
+	}
+
+	query := `
+		SELECT f.id, f.adjustment_id, f.order_id, f.amount, f.status, f.refund_method, f.reference, a.reason,
+		       f.requested_by, f.processed_by, f.created_at, f.completed_at
+		FROM refunds f
+		JOIN order_adjustments a ON a.id = f.adjustment_id
+		JOIN orders o ON o.id = f.order_id
+		WHERE f.status = $1`
+	args := []any{status}
+	if branchID := r.Header.Get("X-Branch-ID"); branchID != "" {
+		query += ` AND o.branch_id = $2`
+		args = append(args, branchID)
+	} else if orgID := r.Header.Get("X-Organization-ID"); orgID != "" {
+		query += ` AND o.organization_id = $2`
+		args = append(args, orgID)
+	}
+	query += ` ORDER BY f.created_at`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Failed to list refunds: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		var f Refund
+		if err := rows.Scan(&f.ID, &f.AdjustmentID, &f.OrderID, &f.Amount, &f.Status, &f.RefundMethod, &f.Reference, &f.Reason,
+			&f.RequestedBy, &f.ProcessedBy, &f.CreatedAt, &f.CompletedAt); err != nil {
+			log.Printf("Scan refund failed: %v", err)
+			continue
+		}
+		refunds = append(refunds, f)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"refunds": refunds})
+}
+
+// completeRefund records that the money went back to the customer and
+// settles the adjustment that requested it.
+func completeRefund(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if !isManager(r) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "unauthorized"})
+		return
+	}
+	refundID := mux.Vars(r)["id"]
+	userID := r.Header.Get("X-User-ID")
+
+	var req CompleteRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.RefundMethod = strings.ToUpper(strings.TrimSpace(req.RefundMethod))
+	req.Reference = strings.TrimSpace(req.Reference)
+	if req.RefundMethod == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing_fields"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var adjustmentID, orderID, status string
+	var amount float64
+	err = tx.QueryRow(`
+		SELECT adjustment_id, order_id, status, amount FROM refunds WHERE id = $1 FOR UPDATE
+	`, refundID).Scan(&adjustmentID, &orderID, &status, &amount)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "refund_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock refund: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if status != "REQUESTED" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "refund_not_requested", "status": status})
+		return
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		UPDATE refunds
+		SET status = 'COMPLETED', refund_method = $1, reference = $2, processed_by = $3, completed_at = $4
+		WHERE id = $5
+	`, req.RefundMethod, nullable(req.Reference), nullable(userID), now, refundID)
+	if err != nil {
+		log.Printf("Failed to complete refund: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if _, err := tx.Exec(`UPDATE order_adjustments SET status = 'SETTLED', settled_at = $1 WHERE id = $2`, now, adjustmentID); err != nil {
+		log.Printf("Failed to settle adjustment: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	err = recordOrderEvent(tx, orderID, eventRefundCompleted, userID, nil, map[string]any{
+		"refund_id":     refundID,
+		"adjustment_id": adjustmentID,
+		"amount":        amount,
+		"refund_method": req.RefundMethod,
+		"reference":     nullable(req.Reference),
+	})
+	if err == nil {
+		err = recordOrderEvent(tx, orderID, eventAdjustmentSettled, userID,
+			map[string]string{"adjustment_id": adjustmentID, "status": "PENDING"},
+			map[string]string{"adjustment_id": adjustmentID, "status": "SETTLED"})
+	}
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"refund_id":     refundID,
+		"adjustment_id": adjustmentID,
+		"amount":        amount,
+		"status":        "COMPLETED",
+		"completed_at":  now,
+	})
+}
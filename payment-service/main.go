@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -86,6 +88,34 @@ func main() {
 		checkout(db, promotionServiceURL, w, r)
 	}).Methods(http.MethodPost)
 
+	router.HandleFunc("/api/payments/splits", func(w http.ResponseWriter, r *http.Request) {
+		createSplit(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/payments/splits/{id}", func(w http.ResponseWriter, r *http.Request) {
+		getSplit(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/payments/splits/{id}", func(w http.ResponseWriter, r *http.Request) {
+		cancelSplit(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/payments/splits/{id}/shares/{shareId}/pay", func(w http.ResponseWriter, r *http.Request) {
+		payShare(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/payments/adjustments/{id}/pay", func(w http.ResponseWriter, r *http.Request) {
+		payAdjustment(db, w, r)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/payments/refunds", func(w http.ResponseWriter, r *http.Request) {
+		listRefunds(db, w, r)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/payments/refunds/{id}/complete", func(w http.ResponseWriter, r *http.Request) {
+		completeRefund(db, w, r)
+	}).Methods(http.MethodPost)
+
 	router.HandleFunc("/api/payments/{id}", func(w http.ResponseWriter, r *http.Request) {
 		getPayment(db, w, r)
 	}).Methods(http.MethodGet)
@@ -119,13 +149,27 @@ func checkout(db *sql.DB, promotionServiceURL string, w http.ResponseWriter, r *
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing_fields"})
 		return
 	}
+	userID := r.Header.Get("X-User-ID")
+
+	// The order stays locked from the checks to the PAID update, so two
+	// checkouts of one bill cannot both charge it.
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
 
 	var orderTotal float64
 	var existingDiscount float64
-	err := db.QueryRow(`
-		SELECT total_amount, COALESCE(discount_amount, 0)
+	var orderStatus string
+	var version int
+	err = tx.QueryRow(`
+		SELECT total_amount, COALESCE(discount_amount, 0), status, version
 		FROM orders WHERE id = $1
-	`, req.OrderID).Scan(&orderTotal, &existingDiscount)
+		FOR UPDATE
+	`, req.OrderID).Scan(&orderTotal, &existingDiscount, &orderStatus, &version)
 
 	if err == sql.ErrNoRows {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
@@ -137,37 +181,54 @@ func checkout(db *sql.DB, promotionServiceURL string, w http.ResponseWriter, r *
 		return
 	}
 
+	// Charging a bill that changed since the cashier loaded it would take the
+	// wrong amount; If-Match carries the order ETag they saw.
+	if header := r.Header.Get("If-Match"); header != "" && !ifMatches(header, version) {
+		w.Header().Set("ETag", orderETag(version))
+		writeJSON(w, http.StatusPreconditionFailed, map[string]any{"error": "version_conflict", "current_version": version})
+		return
+	}
+
+	// Only confirmed or completed orders may move to PAID
+	if !isPayableStatus(orderStatus) {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error": "invalid_status_transition",
+			"from":  orderStatus,
+			"to":    "PAID",
+		})
+		return
+	}
+
+	// A split bill is paid share by share through /api/payments/splits.
+	splitID, err := activeSplitFor(tx, req.OrderID)
+	if err != nil {
+		log.Printf("Failed to check split: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if splitID != "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_in_progress", "split_id": splitID})
+		return
+	}
+
 	finalAmount := orderTotal
-	var promotionID *string
 
 	if req.PromotionCode != nil && *req.PromotionCode != "" {
 		promoResp, err := evaluatePromotion(promotionServiceURL, *req.PromotionCode, orderTotal)
 		if err != nil {
 			log.Printf("Failed to evaluate promotion: %v", err)
 		} else if promoResp.Valid {
-			finalAmount = orderTotal - promoResp.DiscountAmount
-			promotionID = &promoResp.PromotionID
-
-			tx, _ := db.Begin()
-			defer tx.Rollback()
-
-			_, _ = tx.Exec(`
-				INSERT INTO order_discounts (id, order_id, promotion_id, discount_name, discount_amount, applied_by, applied_at)
-				VALUES ($1, $2, $3, $4, $5, $6, NOW())
-			`, uuid.New().String(), req.OrderID, promotionID, *req.PromotionCode, promoResp.DiscountAmount, uuid.New().String())
-
-			_, _ = tx.Exec(`
-				UPDATE orders SET discount_amount = discount_amount + $1, total_amount = total_amount - $1, updated_at = NOW()
-				WHERE id = $2
-			`, promoResp.DiscountAmount, req.OrderID)
-
-			tx.Commit()
+			if finalAmount, err = applyPromotion(tx, req.OrderID, *req.PromotionCode, promoResp, userID, existingDiscount, orderTotal); err != nil {
+				log.Printf("Failed to apply promotion: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+				return
+			}
 		}
 	}
 
 	paymentID := uuid.New().String()
 	now := time.Now()
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO payments (id, order_id, amount, payment_method, status, external_payment_id, created_at, completed_at)
 		VALUES ($1, $2, $3, $4, 'SUCCESS', $5, $6, $7)
 	`, paymentID, req.OrderID, finalAmount, req.PaymentMethod, &req.IdempotencyKey, now, now)
@@ -178,7 +239,29 @@ func checkout(db *sql.DB, promotionServiceURL string, w http.ResponseWriter, r *
 		return
 	}
 
-	_, _ = db.Exec(`UPDATE orders SET status = 'PAID', paid_at = NOW() WHERE id = $1`, req.OrderID)
+	err = recordOrderEvent(tx, req.OrderID, eventPaymentRecorded, userID, nil, map[string]any{
+		"payment_id":     paymentID,
+		"amount":         finalAmount,
+		"payment_method": req.PaymentMethod,
+		"status":         "SUCCESS",
+	})
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err := markOrderPaidTx(tx, req.OrderID, userID, "checkout"); err != nil {
+		log.Printf("Failed to mark order paid: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
 
 	writeJSON(w, http.StatusOK, map[string]any{
 		"payment_id": paymentID,
@@ -189,6 +272,93 @@ func checkout(db *sql.DB, promotionServiceURL string, w http.ResponseWriter, r *
 	})
 }
 
+// applyPromotion records a promotion discount on the order and returns the
+// recalculated total. Service charge and tax depend on the discounted amount,
+// so the shared totals engine recomputes the order instead of subtracting.
+func applyPromotion(tx *sql.Tx, orderID, code string, promo *PromotionEvalResponse, userID string, existingDiscount, orderTotal float64) (float64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO order_discounts (id, order_id, promotion_id, discount_name, discount_amount, applied_by, applied_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	`, uuid.New().String(), orderID, promo.PromotionID, code, promo.DiscountAmount, nullable(userID))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE orders SET discount_amount = discount_amount + $1, updated_at = NOW()
+		WHERE id = $2
+	`, promo.DiscountAmount, orderID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	if err := tx.QueryRow(`SELECT new_total FROM recalc_order_totals($1)`, orderID).Scan(&total); err != nil {
+		return 0, err
+	}
+
+	err = recordOrderEvent(tx, orderID, eventDiscountApplied, userID,
+		map[string]float64{"discount_amount": existingDiscount, "total_amount": orderTotal},
+		map[string]any{
+			"promotion_id":    promo.PromotionID,
+			"promotion_code":  code,
+			"discount_amount": existingDiscount + promo.DiscountAmount,
+			"total_amount":    total,
+		})
+	return total, err
+}
+
+func orderETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatches reports whether an If-Match header accepts the order version,
+// using the same "<version>" ETags as order-service.
+func ifMatches(header string, version int) bool {
+	current := orderETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// isPayableStatus reports whether an order may move to PAID; only payments do
+// that, order-service's status endpoint cannot.
+func isPayableStatus(status string) bool {
+	return status == "CONFIRMED" || status == "COMPLETED"
+}
+
+// markOrderPaidTx moves the order to PAID inside the caller's transaction and
+// records the transition in order_status_history and on the order's timeline.
+func markOrderPaidTx(tx *sql.Tx, orderID, userID, reason string) error {
+	var from string
+	if err := tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&from); err != nil {
+		return err
+	}
+	if !isPayableStatus(from) {
+		return fmt.Errorf("order %s is %s", orderID, from)
+	}
+
+	if _, err := tx.Exec(`UPDATE orders SET status = 'PAID', paid_at = NOW(), updated_at = NOW() WHERE id = $1`, orderID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO order_status_history (order_id, from_status, to_status, reason, changed_by, changed_at)
+		VALUES ($1, $2, 'PAID', $3, $4, NOW())
+	`, orderID, from, reason, nullable(userID))
+	if err != nil {
+		return err
+	}
+
+	return recordOrderEvent(tx, orderID, eventStatusChanged, userID,
+		map[string]string{"status": from},
+		map[string]string{"status": "PAID", "reason": reason})
+}
+
 func getPayment(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
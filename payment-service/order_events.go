@@ -0,0 +1,42 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Timeline event types written by payment-service; order-service owns the
+// order_events read side (GET /api/orders/{id}/timeline).
+const (
+	eventStatusChanged   = "status_changed"
+	eventDiscountApplied = "discount_applied"
+	eventPaymentRecorded = "payment_recorded"
+	eventSplitCreated    = "split_created"
+	eventSplitCancelled  = "split_cancelled"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// recordOrderEvent appends to the order's timeline. Run it in the same
+// transaction as the change so the log never disagrees with the order.
+func recordOrderEvent(ex execer, orderID, eventType, actorID string, before, after any) error {
+	var values [2]interface{}
+	for i, v := range []any{before, after} {
+		if v == nil {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		values[i] = string(b)
+	}
+	_, err := ex.Exec(`
+		INSERT INTO order_events (order_id, source, event_type, actor_id, before_value, after_value, created_at)
+		VALUES ($1, 'payment', $2, $3, $4, $5, NOW())
+	`, orderID, eventType, nullable(actorID), values[0], values[1])
+	return err
+}
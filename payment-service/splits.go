@@ -0,0 +1,817 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	splitByItems = "BY_ITEMS"
+	splitEqual   = "EQUAL"
+	splitCustom  = "CUSTOM"
+
+	maxSplitShares = 50
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+type BillSplit struct {
+	ID          string       `json:"id"`
+	OrderID     *string      `json:"order_id"`
+	QRSessionID *string      `json:"qr_session_id"`
+	Mode        string       `json:"mode"`
+	Status      string       `json:"status"`
+	TotalAmount float64      `json:"total_amount"`
+	OrderIDs    []string     `json:"order_ids"`
+	Shares      []SplitShare `json:"shares"`
+	CreatedAt   time.Time    `json:"created_at"`
+	SettledAt   *time.Time   `json:"settled_at"`
+}
+
+type SplitShare struct {
+	ID            string     `json:"id"`
+	Position      int        `json:"position"`
+	Label         string     `json:"label"`
+	Amount        float64    `json:"amount"`
+	Status        string     `json:"status"`
+	PaymentMethod *string    `json:"payment_method"`
+	PaidAt        *time.Time `json:"paid_at"`
+	ItemIDs       []string   `json:"item_ids,omitempty"`
+}
+
+type CreateSplitRequest struct {
+	OrderID     string `json:"order_id"`
+	QRSessionID string `json:"qr_session_id"`
+	Mode        string `json:"mode"`
+
+	// EQUAL uses Parts; CUSTOM uses Shares[].Amount; BY_ITEMS uses Shares[].ItemIDs
+	Parts  int                 `json:"parts"`
+	Shares []SplitShareRequest `json:"shares"`
+}
+
+type SplitShareRequest struct {
+	Label   string   `json:"label"`
+	Amount  float64  `json:"amount"`
+	ItemIDs []string `json:"item_ids"`
+}
+
+type PayShareRequest struct {
+	PaymentMethod  string `json:"payment_method"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+// splitOrder is a covered order and what is still owed on it.
+type splitOrder struct {
+	ID          string
+	Status      string
+	Outstanding int64 // cents
+}
+
+// splitError is a client-facing failure while building or paying a split.
+type splitError struct {
+	Status int
+	Body   map[string]any
+}
+
+func (e *splitError) Error() string {
+	return fmt.Sprint(e.Body["error"])
+}
+
+func newSplitError(status int, code string) *splitError {
+	return &splitError{Status: status, Body: map[string]any{"error": code}}
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// lockSplitOrders locks the orders a new split would cover: the single order,
+// or every unpaid, non-cancelled order of the session.
+func lockSplitOrders(tx *sql.Tx, req CreateSplitRequest) ([]splitOrder, error) {
+	query := `
+		SELECT o.id, o.status,
+		       o.total_amount - COALESCE((SELECT SUM(p.amount) FROM payments p
+		                                  WHERE p.order_id = o.id AND p.status = 'SUCCESS'), 0)
+		FROM orders o`
+	var arg string
+	if req.OrderID != "" {
+		query += ` WHERE o.id = $1`
+		arg = req.OrderID
+	} else {
+		query += ` WHERE o.qr_session_id = $1 AND o.status NOT IN ('PAID', 'CANCELLED')`
+		arg = req.QRSessionID
+	}
+	query += ` ORDER BY o.created_at FOR UPDATE OF o`
+
+	return scanSplitOrders(tx.Query(query, arg))
+}
+
+// lockCoveredOrders locks the orders of an existing split.
+func lockCoveredOrders(tx *sql.Tx, splitID string) ([]splitOrder, error) {
+	return scanSplitOrders(tx.Query(`
+		SELECT o.id, o.status,
+		       o.total_amount - COALESCE((SELECT SUM(p.amount) FROM payments p
+		                                  WHERE p.order_id = o.id AND p.status = 'SUCCESS'), 0)
+		FROM orders o
+		JOIN bill_split_orders bso ON bso.order_id = o.id
+		WHERE bso.split_id = $1
+		ORDER BY o.created_at
+		FOR UPDATE OF o
+	`, splitID))
+}
+
+func scanSplitOrders(rows *sql.Rows, err error) ([]splitOrder, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []splitOrder
+	for rows.Next() {
+		var o splitOrder
+		var outstanding float64
+		if err := rows.Scan(&o.ID, &o.Status, &outstanding); err != nil {
+			return nil, err
+		}
+		o.Outstanding = toCents(outstanding)
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// equalShares divides total into n shares; the first shares absorb the leftover cents.
+func equalShares(total int64, n int) []int64 {
+	shares := make([]int64, n)
+	base, rest := total/int64(n), total%int64(n)
+	for i := range shares {
+		shares[i] = base
+		if int64(i) < rest {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+// itemShares prices BY_ITEMS shares. Every live item of the covered orders must
+// belong to exactly one share; tax, service charge and discounts are spread in
+// proportion to each share's item totals, and the shares add up to total.
+func itemShares(tx *sql.Tx, orders []splitOrder, shares []SplitShareRequest, total int64) ([]int64, error) {
+	orderIDs := make([]string, len(orders))
+	for i, o := range orders {
+		orderIDs[i] = o.ID
+	}
+	rows, err := tx.Query(`
+		SELECT id, item_total FROM order_items
+		WHERE order_id = ANY($1) AND item_status <> 'CANCELLED'
+	`, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	itemTotals := map[string]int64{}
+	var subtotal int64
+	for rows.Next() {
+		var id string
+		var itemTotal float64
+		if err := rows.Scan(&id, &itemTotal); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		itemTotals[id] = toCents(itemTotal)
+		subtotal += itemTotals[id]
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if subtotal <= 0 {
+		return nil, newSplitError(http.StatusConflict, "nothing_to_split")
+	}
+
+	assigned := map[string]bool{}
+	sums := make([]int64, len(shares))
+	for i, share := range shares {
+		if len(share.ItemIDs) == 0 {
+			return nil, newSplitError(http.StatusBadRequest, "share_without_items")
+		}
+		for _, itemID := range share.ItemIDs {
+			itemTotal, ok := itemTotals[itemID]
+			if !ok {
+				e := newSplitError(http.StatusBadRequest, "item_not_found")
+				e.Body["item_id"] = itemID
+				return nil, e
+			}
+			if assigned[itemID] {
+				e := newSplitError(http.StatusBadRequest, "item_assigned_twice")
+				e.Body["item_id"] = itemID
+				return nil, e
+			}
+			assigned[itemID] = true
+			sums[i] += itemTotal
+		}
+	}
+	var unassigned []string
+	for itemID := range itemTotals {
+		if !assigned[itemID] {
+			unassigned = append(unassigned, itemID)
+		}
+	}
+	if len(unassigned) > 0 {
+		e := newSplitError(http.StatusBadRequest, "items_unassigned")
+		e.Body["item_ids"] = unassigned
+		return nil, e
+	}
+
+	// Largest remainder: every share gets its exact part rounded down, then the
+	// cents left over go one each to the shares that lost the most.
+	amounts := make([]int64, len(shares))
+	remainders := make([]int64, len(shares))
+	byRemainder := make([]int, len(shares))
+	var allocated int64
+	for i := range shares {
+		amounts[i] = total * sums[i] / subtotal
+		remainders[i] = total * sums[i] % subtotal
+		allocated += amounts[i]
+		byRemainder[i] = i
+	}
+	sort.SliceStable(byRemainder, func(a, b int) bool {
+		return remainders[byRemainder[a]] > remainders[byRemainder[b]]
+	})
+	for k := int64(0); k < total-allocated; k++ {
+		amounts[byRemainder[k%int64(len(shares))]]++
+	}
+	return amounts, nil
+}
+
+// shareAmounts works out each share in cents according to the split mode.
+func shareAmounts(tx *sql.Tx, req CreateSplitRequest, orders []splitOrder, total int64) ([]int64, error) {
+	switch req.Mode {
+	case splitEqual:
+		if req.Parts < 2 || req.Parts > maxSplitShares {
+			return nil, newSplitError(http.StatusBadRequest, "invalid_parts")
+		}
+		return equalShares(total, req.Parts), nil
+
+	case splitCustom:
+		if len(req.Shares) < 2 || len(req.Shares) > maxSplitShares {
+			return nil, newSplitError(http.StatusBadRequest, "invalid_parts")
+		}
+		amounts := make([]int64, len(req.Shares))
+		var sum int64
+		for i, share := range req.Shares {
+			amounts[i] = toCents(share.Amount)
+			if amounts[i] <= 0 {
+				return nil, newSplitError(http.StatusBadRequest, "invalid_amount")
+			}
+			sum += amounts[i]
+		}
+		if sum != total {
+			e := newSplitError(http.StatusBadRequest, "split_amount_mismatch")
+			e.Body["expected"] = fromCents(total)
+			e.Body["actual"] = fromCents(sum)
+			return nil, e
+		}
+		return amounts, nil
+
+	case splitByItems:
+		if len(req.Shares) < 2 || len(req.Shares) > maxSplitShares {
+			return nil, newSplitError(http.StatusBadRequest, "invalid_parts")
+		}
+		return itemShares(tx, orders, req.Shares, total)
+	}
+	return nil, newSplitError(http.StatusBadRequest, "invalid_mode")
+}
+
+// activeSplitFor returns the id of an ACTIVE split covering the order, if any.
+func activeSplitFor(q queryer, orderID string) (string, error) {
+	var splitID string
+	err := q.QueryRow(`
+		SELECT bs.id FROM bill_splits bs
+		JOIN bill_split_orders bso ON bso.split_id = bs.id
+		WHERE bso.order_id = $1 AND bs.status = 'ACTIVE'
+	`, orderID).Scan(&splitID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return splitID, err
+}
+
+func writeSplitError(w http.ResponseWriter, err error, action string) {
+	if e, ok := err.(*splitError); ok {
+		writeJSON(w, e.Status, e.Body)
+		return
+	}
+	log.Printf("Failed to %s: %v", action, err)
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+}
+
+func createSplit(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var req CreateSplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	req.Mode = strings.ToUpper(strings.TrimSpace(req.Mode))
+	if (req.OrderID == "") == (req.QRSessionID == "") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "order_or_session_required"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	orders, err := lockSplitOrders(tx, req)
+	if err != nil {
+		writeSplitError(w, err, "lock split orders")
+		return
+	}
+	if len(orders) == 0 {
+		if req.OrderID != "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "order_not_found"})
+		} else {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "nothing_to_split"})
+		}
+		return
+	}
+
+	var total int64
+	for _, o := range orders {
+		if !isPayableStatus(o.Status) {
+			writeJSON(w, http.StatusConflict, map[string]any{
+				"error":    "invalid_status_transition",
+				"order_id": o.ID,
+				"from":     o.Status,
+				"to":       "PAID",
+			})
+			return
+		}
+		existing, err := activeSplitFor(tx, o.ID)
+		if err != nil {
+			writeSplitError(w, err, "check existing split")
+			return
+		}
+		if existing != "" {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "split_exists", "split_id": existing})
+			return
+		}
+		if o.Outstanding > 0 {
+			total += o.Outstanding
+		}
+	}
+	if total <= 0 {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "nothing_to_split"})
+		return
+	}
+
+	amounts, err := shareAmounts(tx, req, orders, total)
+	if err != nil {
+		writeSplitError(w, err, "compute split shares")
+		return
+	}
+
+	var splitID string
+	err = tx.QueryRow(`
+		INSERT INTO bill_splits (order_id, qr_session_id, mode, status, total_amount, created_by, created_at)
+		VALUES ($1, $2, $3, 'ACTIVE', $4, $5, NOW())
+		RETURNING id
+	`, nullable(req.OrderID), nullable(req.QRSessionID), req.Mode, fromCents(total), nullable(r.Header.Get("X-User-ID"))).Scan(&splitID)
+	if err != nil {
+		writeSplitError(w, err, "create split")
+		return
+	}
+
+	for _, o := range orders {
+		if _, err := tx.Exec(`INSERT INTO bill_split_orders (split_id, order_id) VALUES ($1, $2)`, splitID, o.ID); err != nil {
+			writeSplitError(w, err, "link split order")
+			return
+		}
+		err := recordOrderEvent(tx, o.ID, eventSplitCreated, r.Header.Get("X-User-ID"), nil, map[string]any{
+			"split_id":     splitID,
+			"mode":         req.Mode,
+			"shares":       len(amounts),
+			"total_amount": fromCents(total),
+		})
+		if err != nil {
+			writeSplitError(w, err, "record order event")
+			return
+		}
+	}
+
+	for i, amount := range amounts {
+		label := fmt.Sprintf("Guest %d", i+1)
+		var itemIDs []string
+		if i < len(req.Shares) {
+			if l := strings.TrimSpace(req.Shares[i].Label); l != "" {
+				label = l
+			}
+			if req.Mode == splitByItems {
+				itemIDs = req.Shares[i].ItemIDs
+			}
+		}
+
+		var shareID string
+		err := tx.QueryRow(`
+			INSERT INTO bill_split_shares (split_id, position, label, amount, status)
+			VALUES ($1, $2, $3, $4, 'UNPAID')
+			RETURNING id
+		`, splitID, i+1, label, fromCents(amount)).Scan(&shareID)
+		if err != nil {
+			writeSplitError(w, err, "create split share")
+			return
+		}
+		for _, itemID := range itemIDs {
+			if _, err := tx.Exec(`INSERT INTO bill_split_share_items (share_id, order_item_id) VALUES ($1, $2)`, shareID, itemID); err != nil {
+				writeSplitError(w, err, "assign share item")
+				return
+			}
+		}
+	}
+
+	split, err := loadSplit(tx, splitID)
+	if err != nil {
+		writeSplitError(w, err, "load split")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, split)
+}
+
+// loadSplit reads a split with its covered orders and shares.
+func loadSplit(q queryer, splitID string) (*BillSplit, error) {
+	split := &BillSplit{ID: splitID, OrderIDs: []string{}, Shares: []SplitShare{}}
+	err := q.QueryRow(`
+		SELECT order_id, qr_session_id, mode, status, total_amount, created_at, settled_at
+		FROM bill_splits WHERE id = $1
+	`, splitID).Scan(&split.OrderID, &split.QRSessionID, &split.Mode, &split.Status, &split.TotalAmount, &split.CreatedAt, &split.SettledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`SELECT order_id FROM bill_split_orders WHERE split_id = $1`, splitID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var orderID string
+		if err := rows.Scan(&orderID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		split.OrderIDs = append(split.OrderIDs, orderID)
+	}
+	rows.Close()
+
+	rows, err = q.Query(`
+		SELECT s.id, s.position, s.label, s.amount, s.status, s.payment_method, s.paid_at,
+		       COALESCE(array_agg(si.order_item_id::TEXT) FILTER (WHERE si.order_item_id IS NOT NULL), '{}')
+		FROM bill_split_shares s
+		LEFT JOIN bill_split_share_items si ON si.share_id = s.id
+		WHERE s.split_id = $1
+		GROUP BY s.id
+		ORDER BY s.position
+	`, splitID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var share SplitShare
+		if err := rows.Scan(&share.ID, &share.Position, &share.Label, &share.Amount, &share.Status,
+			&share.PaymentMethod, &share.PaidAt, pq.Array(&share.ItemIDs)); err != nil {
+			return nil, err
+		}
+		split.Shares = append(split.Shares, share)
+	}
+	return split, rows.Err()
+}
+
+func getSplit(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	split, err := loadSplit(db, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "split_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get split: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, split)
+}
+
+// cancelSplit drops an ACTIVE split so the bill can be split again or paid in
+// one go. Splits with paid shares cannot be cancelled.
+func cancelSplit(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	splitID := mux.Vars(r)["id"]
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM bill_splits WHERE id = $1 FOR UPDATE`, splitID).Scan(&status)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "split_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock split: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if status != "ACTIVE" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_not_active"})
+		return
+	}
+
+	var paid int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM bill_split_shares WHERE split_id = $1 AND status = 'PAID'`, splitID).Scan(&paid); err != nil {
+		log.Printf("Failed to count paid shares: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if paid > 0 {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_has_payments"})
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE bill_splits SET status = 'CANCELLED', cancelled_at = NOW() WHERE id = $1`, splitID); err != nil {
+		log.Printf("Failed to cancel split: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	rows, err := tx.Query(`SELECT order_id FROM bill_split_orders WHERE split_id = $1`, splitID)
+	if err != nil {
+		log.Printf("Failed to get split orders: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	var orderIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("Scan split order failed: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	for _, id := range orderIDs {
+		err := recordOrderEvent(tx, id, eventSplitCancelled, r.Header.Get("X-User-ID"),
+			map[string]string{"split_id": splitID, "status": "ACTIVE"},
+			map[string]string{"split_id": splitID, "status": "CANCELLED"})
+		if err != nil {
+			log.Printf("Failed to record order event: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "cancelled"})
+}
+
+// payShare records the payment for one share. The amount is spread over the
+// covered orders oldest first, so every payment row still belongs to an order.
+// Paying the last share marks all covered orders PAID.
+func payShare(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	splitID, shareID := vars["id"], vars["shareId"]
+	userID := r.Header.Get("X-User-ID")
+
+	var req PayShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if req.PaymentMethod == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing_fields"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var splitStatus string
+	err = tx.QueryRow(`SELECT status FROM bill_splits WHERE id = $1 FOR UPDATE`, splitID).Scan(&splitStatus)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "split_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock split: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	var amount float64
+	var shareStatus string
+	var paidKey sql.NullString
+	err = tx.QueryRow(`
+		SELECT amount, status, idempotency_key FROM bill_split_shares
+		WHERE id = $1 AND split_id = $2
+		FOR UPDATE
+	`, shareID, splitID).Scan(&amount, &shareStatus, &paidKey)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "share_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock share: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	if shareStatus == "PAID" {
+		// A retried request with the same key gets the original result.
+		if req.IdempotencyKey != "" && paidKey.String == req.IdempotencyKey {
+			split, err := loadSplit(tx, splitID)
+			if err != nil {
+				writeSplitError(w, err, "load split")
+				return
+			}
+			writeJSON(w, http.StatusOK, split)
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "share_already_paid"})
+		return
+	}
+	if splitStatus != "ACTIVE" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "split_not_active"})
+		return
+	}
+
+	orders, err := lockCoveredOrders(tx, splitID)
+	if err != nil {
+		writeSplitError(w, err, "lock split orders")
+		return
+	}
+
+	// The shares must still add up to what is owed; items added or discounts
+	// applied after splitting make the split stale.
+	var unpaid float64
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM bill_split_shares WHERE split_id = $1 AND status = 'UNPAID'
+	`, splitID).Scan(&unpaid); err != nil {
+		log.Printf("Failed to sum unpaid shares: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	var owed int64
+	for _, o := range orders {
+		if o.Outstanding > 0 {
+			owed += o.Outstanding
+		}
+	}
+	if owed != toCents(unpaid) {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":       "split_out_of_date",
+			"balance_due": fromCents(owed),
+			"unpaid":      unpaid,
+		})
+		return
+	}
+
+	now := time.Now()
+	remaining := toCents(amount)
+	var paymentIDs []string
+	for i, o := range orders {
+		if remaining == 0 {
+			break
+		}
+		part := o.Outstanding
+		if part > remaining || i == len(orders)-1 {
+			part = remaining
+		}
+		if part <= 0 {
+			continue
+		}
+
+		var key interface{}
+		if len(paymentIDs) == 0 {
+			key = nullable(req.IdempotencyKey)
+		}
+		var paymentID string
+		err := tx.QueryRow(`
+			INSERT INTO payments (order_id, amount, payment_method, status, external_payment_id, split_share_id, created_at, completed_at)
+			VALUES ($1, $2, $3, 'SUCCESS', $4, $5, $6, $6)
+			RETURNING id
+		`, o.ID, fromCents(part), req.PaymentMethod, key, shareID, now).Scan(&paymentID)
+		if err != nil {
+			writeSplitError(w, err, "create payment")
+			return
+		}
+		err = recordOrderEvent(tx, o.ID, eventPaymentRecorded, userID, nil, map[string]any{
+			"payment_id":     paymentID,
+			"amount":         fromCents(part),
+			"payment_method": req.PaymentMethod,
+			"status":         "SUCCESS",
+			"split_id":       splitID,
+			"split_share_id": shareID,
+		})
+		if err != nil {
+			writeSplitError(w, err, "record order event")
+			return
+		}
+		paymentIDs = append(paymentIDs, paymentID)
+		remaining -= part
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bill_split_shares
+		SET status = 'PAID', payment_method = $1, idempotency_key = $2, paid_at = $3
+		WHERE id = $4
+	`, req.PaymentMethod, nullable(req.IdempotencyKey), now, shareID)
+	if err != nil {
+		writeSplitError(w, err, "mark share paid")
+		return
+	}
+
+	var left int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM bill_split_shares WHERE split_id = $1 AND status = 'UNPAID'`, splitID).Scan(&left); err != nil {
+		writeSplitError(w, err, "count unpaid shares")
+		return
+	}
+	if left == 0 {
+		if _, err := tx.Exec(`UPDATE bill_splits SET status = 'SETTLED', settled_at = $1 WHERE id = $2`, now, splitID); err != nil {
+			writeSplitError(w, err, "settle split")
+			return
+		}
+		for _, o := range orders {
+			if err := markOrderPaidTx(tx, o.ID, userID, "split_settled"); err != nil {
+				writeSplitError(w, err, "mark order paid")
+				return
+			}
+		}
+	}
+
+	split, err := loadSplit(tx, splitID)
+	if err != nil {
+		writeSplitError(w, err, "load split")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"payment_ids": paymentIDs,
+		"settled":     left == 0,
+		"split":       split,
+	})
+}
+
+// nullable wraps empty strings as NULL for SQL parameters.
+func nullable(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -93,6 +93,20 @@ func nullable(value string) interface{} {
 	return value
 }
 
+// recordOrderEvent appends to the order's timeline (order_events), in the same
+// transaction as the change.
+func recordOrderEvent(tx *sql.Tx, orderID, eventType, actorID string, after any) error {
+	value, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO order_events (order_id, source, event_type, actor_id, after_value, created_at)
+		VALUES ($1, 'promotion', $2, $3, $4, NOW())
+	`, orderID, eventType, nullable(actorID), string(value))
+	return err
+}
+
 // ensureOrgFromBranch fetches organization_id when branch context is provided.
 func ensureOrgFromBranch(db *sql.DB, branchID, orgID string) (string, string, error) {
 	if branchID == "" {
@@ -635,19 +649,27 @@ func applyPromotion(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify promotion exists
-	var promoID string
+	var promoID, promoCode string
 	if branchID != "" {
-		err = db.QueryRow(`SELECT id FROM promotions WHERE id = $1 AND (branch_id = $2 OR (branch_id IS NULL AND organization_id = $3))`, req.PromotionID, branchID, orgID).Scan(&promoID)
+		err = db.QueryRow(`SELECT id, code FROM promotions WHERE id = $1 AND (branch_id = $2 OR (branch_id IS NULL AND organization_id = $3))`, req.PromotionID, branchID, orgID).Scan(&promoID, &promoCode)
 	} else {
-		err = db.QueryRow(`SELECT id FROM promotions WHERE id = $1 AND (organization_id = $2 OR organization_id IS NULL)`, req.PromotionID, orgID).Scan(&promoID)
+		err = db.QueryRow(`SELECT id, code FROM promotions WHERE id = $1 AND (organization_id = $2 OR organization_id IS NULL)`, req.PromotionID, orgID).Scan(&promoID, &promoCode)
 	}
 	if err != nil {
 		writeJSON(w, http.StatusForbidden, map[string]string{"error": "promotion_not_found"})
 		return
 	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+	defer tx.Rollback()
+
 	usageID := uuid.New().String()
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO promotion_usage (id, promotion_id, order_id, used_at)
 		VALUES ($1, $2, $3, NOW())
 	`, usageID, req.PromotionID, req.OrderID)
@@ -658,6 +680,23 @@ func applyPromotion(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = recordOrderEvent(tx, req.OrderID, "promotion_applied", r.Header.Get("X-User-ID"), map[string]string{
+		"usage_id":       usageID,
+		"promotion_id":   req.PromotionID,
+		"promotion_code": promoCode,
+	})
+	if err != nil {
+		log.Printf("Failed to record order event: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failed to commit: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "db_error"})
+		return
+	}
+
 	writeJSON(w, http.StatusOK, map[string]any{
 		"usage_id":     usageID,
 		"promotion_id": req.PromotionID,